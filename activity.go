@@ -0,0 +1,127 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/WOsaka/chirpy-server/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultActivityDays = 30
+	maxActivityDays     = 365
+)
+
+type dailyCount struct {
+	Date  string `json:"date"`
+	Count int64  `json:"count"`
+}
+
+// fillDailyCounts expands the sparse per-day rows from the database into one
+// entry per day for the days days ending on today, using zero for days
+// without chirps.
+func fillDailyCounts(rows []database.GetDailyChirpCountsRow, today time.Time, days int) []dailyCount {
+	counts := make(map[string]int64, len(rows))
+	for _, row := range rows {
+		counts[row.Day.Format(time.DateOnly)] = row.Count
+	}
+
+	start := today.AddDate(0, 0, -(days - 1))
+	activity := make([]dailyCount, 0, days)
+	for i := 0; i < days; i++ {
+		date := start.AddDate(0, 0, i).Format(time.DateOnly)
+		activity = append(activity, dailyCount{Date: date, Count: counts[date]})
+	}
+	return activity
+}
+
+// getUserActivityHandler returns a per-day chirp histogram for a user over
+// the last days days (default defaultActivityDays), oldest first.
+func (cfg *apiConfig) getUserActivityHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, r, http.StatusBadRequest, codeValidationError, "Invalid user ID")
+		return
+	}
+
+	days := defaultActivityDays
+	if v := r.URL.Query().Get("days"); v != "" {
+		days, err = strconv.Atoi(v)
+		if err != nil || days < 1 || days > maxActivityDays {
+			respondWithError(w, r, http.StatusBadRequest, codeValidationError, "days must be between 1 and 365")
+			return
+		}
+	}
+
+	now := time.Now().UTC()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	rows, err := cfg.db.GetDailyChirpCounts(r.Context(), database.GetDailyChirpCountsParams{
+		UserID: userID,
+		Since:  today.AddDate(0, 0, -(days - 1)),
+	})
+	if err != nil {
+		requestLogger(r).Error("Error fetching chirp activity", "error", err)
+		respondWithError(w, r, http.StatusInternalServerError, codeInternal, "Failed to fetch activity")
+		return
+	}
+
+	if err := respondWithJSON(w, http.StatusOK, fillDailyCounts(rows, today, days)); err != nil {
+		requestLogger(r).Error("Error responding with JSON", "error", err)
+		return
+	}
+}
+
+type userStats struct {
+	ChirpCount         int64      `json:"chirp_count"`
+	TotalLikesReceived int64      `json:"total_likes_received"`
+	FirstChirpAt       *time.Time `json:"first_chirp_at"`
+	LastChirpAt        *time.Time `json:"last_chirp_at"`
+}
+
+// getUserStatsHandler returns totals over a user's live chirps for their
+// profile page. The chirp timestamps are null for users who haven't chirped.
+func (cfg *apiConfig) getUserStatsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, r, http.StatusBadRequest, codeValidationError, "Invalid user ID")
+		return
+	}
+
+	if _, err := cfg.db.GetUserByID(r.Context(), userID); errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, r, http.StatusNotFound, codeNotFound, "User not found")
+		return
+	} else if err != nil {
+		requestLogger(r).Error("Error fetching user", "user_id", userID, "error", err)
+		respondWithError(w, r, http.StatusInternalServerError, codeInternal, "Failed to fetch stats")
+		return
+	}
+
+	row, err := cfg.db.GetUserChirpStats(r.Context(), userID)
+	if err != nil {
+		requestLogger(r).Error("Error fetching user stats", "user_id", userID, "error", err)
+		respondWithError(w, r, http.StatusInternalServerError, codeInternal, "Failed to fetch stats")
+		return
+	}
+
+	stats := userStats{
+		ChirpCount:         row.ChirpCount,
+		TotalLikesReceived: row.TotalLikesReceived,
+	}
+	if row.FirstChirpAt.Valid {
+		firstChirpAt := row.FirstChirpAt.Time.UTC()
+		stats.FirstChirpAt = &firstChirpAt
+	}
+	if row.LastChirpAt.Valid {
+		lastChirpAt := row.LastChirpAt.Time.UTC()
+		stats.LastChirpAt = &lastChirpAt
+	}
+
+	if err := respondWithJSON(w, http.StatusOK, stats); err != nil {
+		requestLogger(r).Error("Error responding with JSON", "error", err)
+		return
+	}
+}
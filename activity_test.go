@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/WOsaka/chirpy-server/internal/database"
+	"github.com/google/uuid"
+)
+
+func TestFillDailyCounts(t *testing.T) {
+	today := time.Date(2024, time.March, 2, 0, 0, 0, 0, time.UTC)
+	rows := []database.GetDailyChirpCountsRow{
+		{Day: time.Date(2024, time.February, 28, 0, 0, 0, 0, time.UTC), Count: 3},
+		{Day: time.Date(2024, time.March, 2, 0, 0, 0, 0, time.UTC), Count: 1},
+	}
+
+	got := fillDailyCounts(rows, today, 4)
+	want := []dailyCount{
+		{Date: "2024-02-28", Count: 3},
+		{Date: "2024-02-29", Count: 0},
+		{Date: "2024-03-01", Count: 0},
+		{Date: "2024-03-02", Count: 1},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("fillDailyCounts() = %v; want %v", got, want)
+	}
+}
+
+func TestGetUserActivityInvalidDays(t *testing.T) {
+	cfg := &apiConfig{}
+	userID := uuid.New().String()
+
+	for _, days := range []string{"0", "-5", "366", "week"} {
+		req := httptest.NewRequest(http.MethodGet, "/api/users/"+userID+"/activity?days="+days, nil)
+		req.SetPathValue("userID", userID)
+		rec := httptest.NewRecorder()
+		cfg.getUserActivityHandler(rec, req)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("days=%s: got status %d, want %d", days, rec.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+// statsDB computes GetUserChirpStats from seeded chirps and likes the way
+// the aggregate query does.
+type statsDB struct {
+	database.Querier
+	users  map[uuid.UUID]bool
+	chirps []database.Chirp
+	likes  map[uuid.UUID]int64
+}
+
+func (db *statsDB) GetUserByID(ctx context.Context, id uuid.UUID) (database.User, error) {
+	if !db.users[id] {
+		return database.User{}, sql.ErrNoRows
+	}
+	return database.User{ID: id}, nil
+}
+
+func (db *statsDB) GetUserChirpStats(ctx context.Context, userID uuid.UUID) (database.GetUserChirpStatsRow, error) {
+	var row database.GetUserChirpStatsRow
+	for _, chirp := range db.chirps {
+		if chirp.UserID != userID || chirp.DeletedAt.Valid {
+			continue
+		}
+		row.ChirpCount++
+		row.TotalLikesReceived += db.likes[chirp.ID]
+		if !row.FirstChirpAt.Valid || chirp.CreatedAt.Before(row.FirstChirpAt.Time) {
+			row.FirstChirpAt = sql.NullTime{Time: chirp.CreatedAt, Valid: true}
+		}
+		if !row.LastChirpAt.Valid || chirp.CreatedAt.After(row.LastChirpAt.Time) {
+			row.LastChirpAt = sql.NullTime{Time: chirp.CreatedAt, Valid: true}
+		}
+	}
+	return row, nil
+}
+
+func TestGetUserStats(t *testing.T) {
+	authorID, quietID, otherID := uuid.New(), uuid.New(), uuid.New()
+	first := time.Date(2024, time.March, 1, 9, 0, 0, 0, time.UTC)
+	last := first.Add(48 * time.Hour)
+	chirps := []database.Chirp{
+		{ID: uuid.New(), UserID: authorID, CreatedAt: last},
+		{ID: uuid.New(), UserID: authorID, CreatedAt: first},
+		{ID: uuid.New(), UserID: authorID, CreatedAt: first.Add(time.Hour)},
+		{ID: uuid.New(), UserID: authorID, CreatedAt: first.Add(-time.Hour), DeletedAt: sql.NullTime{Time: last, Valid: true}},
+		{ID: uuid.New(), UserID: otherID, CreatedAt: first},
+	}
+	db := &statsDB{
+		users:  map[uuid.UUID]bool{authorID: true, quietID: true, otherID: true},
+		chirps: chirps,
+		likes:  map[uuid.UUID]int64{chirps[0].ID: 2, chirps[1].ID: 3, chirps[3].ID: 10, chirps[4].ID: 7},
+	}
+	cfg := &apiConfig{db: db}
+
+	getStats := func(userID string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/users/"+userID+"/stats", nil)
+		req.SetPathValue("userID", userID)
+		rec := httptest.NewRecorder()
+		cfg.getUserStatsHandler(rec, req)
+		return rec
+	}
+
+	tests := []struct {
+		name   string
+		userID uuid.UUID
+		want   string
+	}{
+		{"author", authorID, `{"chirp_count":3,"total_likes_received":5,"first_chirp_at":"2024-03-01T09:00:00Z","last_chirp_at":"2024-03-03T09:00:00Z"}`},
+		{"no chirps", quietID, `{"chirp_count":0,"total_likes_received":0,"first_chirp_at":null,"last_chirp_at":null}`},
+	}
+	for _, test := range tests {
+		rec := getStats(test.userID.String())
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s: got status %d, want %d", test.name, rec.Code, http.StatusOK)
+			continue
+		}
+		var got, want any
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Fatalf("%s: decoding response: %v", test.name, err)
+		}
+		json.Unmarshal([]byte(test.want), &want)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %s, want %s", test.name, rec.Body.String(), test.want)
+		}
+	}
+
+	if rec := getStats(uuid.NewString()); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown user: got status %d, want %d", rec.Code, http.StatusNotFound)
+	}
+	if rec := getStats("nope"); rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid user ID: got status %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
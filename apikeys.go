@@ -0,0 +1,161 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/WOsaka/chirpy-server/internal/auth"
+	"github.com/WOsaka/chirpy-server/internal/database"
+	"github.com/google/uuid"
+)
+
+const maxAPIKeyNameLength = 100
+
+// APIKey is an API key as listed to its owner. Key is only set in the
+// response that creates it; afterwards only its hash is stored.
+type APIKey struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Name      string    `json:"name"`
+	Key       string    `json:"key,omitempty"`
+}
+
+func newAPIKey(dbKey database.ApiKey) APIKey {
+	return APIKey{
+		ID:        dbKey.ID,
+		CreatedAt: dbKey.CreatedAt,
+		Name:      dbKey.Name,
+	}
+}
+
+// authenticateAPIKey is authenticate for requests sending
+// "Authorization: ApiKey <key>".
+func (cfg *apiConfig) authenticateAPIKey(w http.ResponseWriter, r *http.Request) (uuid.UUID, bool) {
+	key, err := auth.GetAPIKey(r.Header)
+	if err != nil {
+		requestLogger(r).Warn("Error getting API key", "error", err)
+		respondWithError(w, r, http.StatusUnauthorized, codeUnauthorized, "Unauthorized")
+		return uuid.Nil, false
+	}
+
+	dbKey, err := cfg.db.GetAPIKeyByHash(r.Context(), auth.HashAPIKey(key))
+	if errors.Is(err, sql.ErrNoRows) {
+		requestLogger(r).Warn("Unknown API key")
+		respondWithError(w, r, http.StatusUnauthorized, codeInvalidToken, "Invalid API key")
+		return uuid.Nil, false
+	}
+	if err != nil {
+		requestLogger(r).Error("Error fetching API key", "error", err)
+		respondWithError(w, r, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return uuid.Nil, false
+	}
+	if dbKey.RevokedAt.Valid {
+		requestLogger(r).Warn("Revoked API key used", "user_id", dbKey.UserID, "api_key_id", dbKey.ID)
+		respondWithError(w, r, http.StatusUnauthorized, codeInvalidToken, "API key revoked")
+		return uuid.Nil, false
+	}
+	return dbKey.UserID, true
+}
+
+// createAPIKeyHandler issues a long-lived API key for the authenticated
+// user. The key is returned once; only its hash is stored.
+func (cfg *apiConfig) createAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	userID := userIDFromContext(r)
+
+	var params struct {
+		Name string `json:"name"`
+	}
+
+	if err := cfg.decodeJSON(w, r, &params); err != nil {
+		requestLogger(r).Warn("Error decoding parameters", "error", err)
+		respondWithError(w, r, http.StatusBadRequest, codeBadRequest, err.Error())
+		return
+	}
+
+	params.Name = strings.TrimSpace(params.Name)
+	if len([]rune(params.Name)) > maxAPIKeyNameLength {
+		respondWithError(w, r, http.StatusBadRequest, codeValidationError, fmt.Sprintf("Name must be at most %d characters long", maxAPIKeyNameLength))
+		return
+	}
+
+	key, err := auth.MakeRefreshToken()
+	if err != nil {
+		requestLogger(r).Error("Error creating API key", "user_id", userID, "error", err)
+		respondWithError(w, r, http.StatusInternalServerError, codeInternal, "Failed to create API key")
+		return
+	}
+
+	dbKey, err := cfg.db.CreateAPIKey(r.Context(), database.CreateAPIKeyParams{
+		UserID:  userID,
+		Name:    params.Name,
+		KeyHash: auth.HashAPIKey(key),
+	})
+	if err != nil {
+		requestLogger(r).Error("Error creating API key in database", "user_id", userID, "error", err)
+		respondWithError(w, r, http.StatusInternalServerError, codeInternal, "Failed to create API key")
+		return
+	}
+
+	apiKey := newAPIKey(dbKey)
+	apiKey.Key = key
+
+	if err := respondWithJSON(w, http.StatusCreated, apiKey); err != nil {
+		requestLogger(r).Error("Error responding with JSON", "error", err)
+		return
+	}
+}
+
+// getAPIKeysHandler lists the authenticated user's unrevoked API keys,
+// newest first, without the keys themselves.
+func (cfg *apiConfig) getAPIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	userID := userIDFromContext(r)
+
+	dbKeys, err := cfg.db.ListAPIKeysByUserID(r.Context(), userID)
+	if err != nil {
+		requestLogger(r).Error("Error fetching API keys", "user_id", userID, "error", err)
+		respondWithError(w, r, http.StatusInternalServerError, codeInternal, "Failed to fetch API keys")
+		return
+	}
+
+	apiKeys := []APIKey{}
+	for _, dbKey := range dbKeys {
+		apiKeys = append(apiKeys, newAPIKey(dbKey))
+	}
+
+	if err := respondWithJSON(w, http.StatusOK, apiKeys); err != nil {
+		requestLogger(r).Error("Error responding with JSON", "error", err)
+		return
+	}
+}
+
+// revokeAPIKeyHandler revokes one of the authenticated user's API keys.
+// Other users' keys and already revoked ones are reported as not found.
+func (cfg *apiConfig) revokeAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	userID := userIDFromContext(r)
+
+	keyID, err := uuid.Parse(r.PathValue("keyID"))
+	if err != nil {
+		respondWithError(w, r, http.StatusBadRequest, codeValidationError, "Invalid API key ID")
+		return
+	}
+
+	revoked, err := cfg.db.RevokeAPIKey(r.Context(), database.RevokeAPIKeyParams{
+		ID:     keyID,
+		UserID: userID,
+	})
+	if err != nil {
+		requestLogger(r).Error("Error revoking API key", "user_id", userID, "api_key_id", keyID, "error", err)
+		respondWithError(w, r, http.StatusInternalServerError, codeInternal, "Failed to revoke API key")
+		return
+	}
+	if revoked == 0 {
+		respondWithError(w, r, http.StatusNotFound, codeNotFound, "API key not found")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
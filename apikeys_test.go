@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/WOsaka/chirpy-server/internal/auth"
+	"github.com/WOsaka/chirpy-server/internal/database"
+	"github.com/google/uuid"
+)
+
+type apiKeysDB struct {
+	database.Querier
+	keys map[uuid.UUID]database.ApiKey
+}
+
+func (db *apiKeysDB) CreateAPIKey(ctx context.Context, arg database.CreateAPIKeyParams) (database.ApiKey, error) {
+	key := database.ApiKey{
+		ID:        uuid.New(),
+		CreatedAt: time.Now(),
+		UserID:    arg.UserID,
+		Name:      arg.Name,
+		KeyHash:   arg.KeyHash,
+	}
+	db.keys[key.ID] = key
+	return key, nil
+}
+
+func (db *apiKeysDB) GetAPIKeyByHash(ctx context.Context, keyHash string) (database.ApiKey, error) {
+	for _, key := range db.keys {
+		if key.KeyHash == keyHash {
+			return key, nil
+		}
+	}
+	return database.ApiKey{}, sql.ErrNoRows
+}
+
+func (db *apiKeysDB) ListAPIKeysByUserID(ctx context.Context, userID uuid.UUID) ([]database.ApiKey, error) {
+	var keys []database.ApiKey
+	for _, key := range db.keys {
+		if key.UserID == userID && !key.RevokedAt.Valid {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+func (db *apiKeysDB) RevokeAPIKey(ctx context.Context, arg database.RevokeAPIKeyParams) (int64, error) {
+	key, ok := db.keys[arg.ID]
+	if !ok || key.UserID != arg.UserID || key.RevokedAt.Valid {
+		return 0, nil
+	}
+	key.RevokedAt = sql.NullTime{Time: time.Now(), Valid: true}
+	db.keys[arg.ID] = key
+	return 1, nil
+}
+
+func TestAPIKeyAuthentication(t *testing.T) {
+	userID, otherID := uuid.New(), uuid.New()
+	db := &apiKeysDB{keys: map[uuid.UUID]database.ApiKey{}}
+	cfg := &apiConfig{db: db, jwtKeys: auth.NewHS256Keys("secret")}
+	bearer := "Bearer " + newTestJWT(t, cfg, userID)
+
+	do := func(handler http.Handler, method, path, authorization, body string) *httptest.ResponseRecorder {
+		req := newJSONRequest(method, path, body)
+		req.Header.Set("Authorization", authorization)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := do(cfg.jwtAuthMiddleware(cfg.createAPIKeyHandler), http.MethodPost, "/api/apikeys", bearer, `{"name": "ci"}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create: got status %d, want %d", rec.Code, http.StatusCreated)
+	}
+	var created APIKey
+	if err := json.NewDecoder(rec.Body).Decode(&created); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+	if created.Key == "" || created.Name != "ci" {
+		t.Fatalf("created key = %+v; want a key named ci", created)
+	}
+	if stored := db.keys[created.ID]; stored.KeyHash == created.Key || stored.KeyHash != auth.HashAPIKey(created.Key) {
+		t.Errorf("stored key hash = %q; want the hash of the key", stored.KeyHash)
+	}
+
+	var gotUserID uuid.UUID
+	protected := cfg.authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		gotUserID = userIDFromContext(r)
+	})
+	apiKey := "ApiKey " + created.Key
+	if rec := do(protected, http.MethodGet, "/api/anything", apiKey, ""); rec.Code != http.StatusOK || gotUserID != userID {
+		t.Errorf("API key auth: got status %d and user %v; want %d and %v", rec.Code, gotUserID, http.StatusOK, userID)
+	}
+	if rec := do(protected, http.MethodGet, "/api/anything", bearer, ""); rec.Code != http.StatusOK {
+		t.Errorf("JWT auth: got status %d, want %d", rec.Code, http.StatusOK)
+	}
+	if rec := do(protected, http.MethodGet, "/api/anything", "ApiKey wrong", ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("unknown API key: got status %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+	if rec := do(cfg.jwtAuthMiddleware(cfg.createAPIKeyHandler), http.MethodPost, "/api/apikeys", apiKey, `{}`); rec.Code != http.StatusUnauthorized {
+		t.Errorf("create with API key: got status %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+
+	rec = do(cfg.jwtAuthMiddleware(cfg.getAPIKeysHandler), http.MethodGet, "/api/apikeys", bearer, "")
+	var listed []APIKey
+	if err := json.NewDecoder(rec.Body).Decode(&listed); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+	if len(listed) != 1 || listed[0].ID != created.ID || listed[0].Key != "" {
+		t.Errorf("listed keys = %+v; want only %v without its key", listed, created.ID)
+	}
+
+	revoke := func(authorization, id string) int {
+		req := httptest.NewRequest(http.MethodDelete, "/api/apikeys/"+id, nil)
+		req.SetPathValue("keyID", id)
+		req.Header.Set("Authorization", authorization)
+		rec := httptest.NewRecorder()
+		cfg.jwtAuthMiddleware(cfg.revokeAPIKeyHandler).ServeHTTP(rec, req)
+		return rec.Code
+	}
+	if code := revoke("Bearer "+newTestJWT(t, cfg, otherID), created.ID.String()); code != http.StatusNotFound {
+		t.Errorf("revoke another user's key: got status %d, want %d", code, http.StatusNotFound)
+	}
+	if code := revoke(bearer, created.ID.String()); code != http.StatusNoContent {
+		t.Fatalf("revoke: got status %d, want %d", code, http.StatusNoContent)
+	}
+	if rec := do(protected, http.MethodGet, "/api/anything", apiKey, ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("revoked API key: got status %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+}
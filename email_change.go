@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/WOsaka/chirpy-server/internal/auth"
+	"github.com/WOsaka/chirpy-server/internal/database"
+)
+
+const emailChangeTokenTTL = 24 * time.Hour
+
+// requestEmailChangeHandler starts an email change for the authenticated
+// user. The new address is held on a verification token mailed to it; the
+// account keeps its current email until the token is confirmed.
+func (cfg *apiConfig) requestEmailChangeHandler(w http.ResponseWriter, r *http.Request) {
+	userID := userIDFromContext(r)
+
+	var params struct {
+		CurrentPassword string `json:"current_password"`
+		NewEmail        string `json:"new_email"`
+	}
+
+	if err := cfg.decodeJSON(w, r, &params); err != nil {
+		requestLogger(r).Warn("Error decoding parameters", "error", err)
+		respondWithError(w, r, http.StatusBadRequest, codeBadRequest, err.Error())
+		return
+	}
+
+	if params.CurrentPassword == "" || params.NewEmail == "" {
+		respondWithError(w, r, http.StatusBadRequest, codeValidationError, "Current password and new email are required")
+		return
+	}
+
+	newEmail := normalizeEmail(params.NewEmail)
+	if !isValidEmail(newEmail) {
+		respondWithError(w, r, http.StatusBadRequest, codeValidationError, "Invalid email address")
+		return
+	}
+
+	dbUser, err := cfg.db.GetUserByID(r.Context(), userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, r, http.StatusNotFound, codeNotFound, "User not found")
+		return
+	}
+	if err != nil {
+		requestLogger(r).Error("Error fetching user", "user_id", userID, "error", err)
+		respondWithError(w, r, http.StatusInternalServerError, codeInternal, "Failed to request email change")
+		return
+	}
+
+	if err := auth.CheckPasswordHash(dbUser.HashedPassword, params.CurrentPassword); err != nil {
+		if errors.Is(err, auth.ErrPasswordMismatch) {
+			requestLogger(r).Warn("Incorrect password", "user_id", userID)
+		} else {
+			requestLogger(r).Error("Error checking password", "user_id", userID, "error", err)
+		}
+		respondWithError(w, r, http.StatusUnauthorized, codeUnauthorized, "Incorrect password")
+		return
+	}
+
+	if newEmail == dbUser.Email {
+		respondWithError(w, r, http.StatusBadRequest, codeValidationError, "New email is the same as the current email")
+		return
+	}
+
+	if ok, err := cfg.emailAvailable(r.Context(), newEmail); err != nil {
+		requestLogger(r).Error("Error checking email", "user_id", userID, "error", err)
+		respondWithError(w, r, http.StatusInternalServerError, codeInternal, "Failed to request email change")
+		return
+	} else if !ok {
+		respondWithError(w, r, http.StatusConflict, codeConflict, "Email already in use")
+		return
+	}
+
+	changeToken, err := auth.MakeRefreshToken()
+	if err != nil {
+		requestLogger(r).Error("Error creating email change token", "user_id", userID, "error", err)
+		respondWithError(w, r, http.StatusInternalServerError, codeInternal, "Failed to create verification token")
+		return
+	}
+
+	_, err = cfg.db.CreateEmailChangeToken(r.Context(), database.CreateEmailChangeTokenParams{
+		Token:     changeToken,
+		UserID:    userID,
+		NewEmail:  newEmail,
+		ExpiresAt: time.Now().Add(emailChangeTokenTTL),
+	})
+	if err != nil {
+		requestLogger(r).Error("Error creating email change token in database", "user_id", userID, "error", err)
+		respondWithError(w, r, http.StatusInternalServerError, codeInternal, "Failed to create verification token")
+		return
+	}
+
+	body := fmt.Sprintf("Use this token to confirm your new Chirpy email address: %s\nIt expires in %s.", changeToken, emailChangeTokenTTL)
+	if err := cfg.mailer.Send(newEmail, "Confirm your new Chirpy email", body); err != nil {
+		requestLogger(r).Error("Error sending email change verification", "user_id", userID, "error", err)
+		respondWithError(w, r, http.StatusInternalServerError, codeInternal, "Failed to send verification email")
+		return
+	}
+
+	if err := respondWithJSON(w, http.StatusAccepted, struct {
+		PendingEmail string `json:"pending_email"`
+	}{
+		PendingEmail: newEmail,
+	}); err != nil {
+		requestLogger(r).Error("Error responding with JSON", "error", err)
+		return
+	}
+}
+
+// confirmEmailChangeHandler applies a pending email change and revokes the
+// user's refresh tokens, so every other session has to log in again.
+func (cfg *apiConfig) confirmEmailChangeHandler(w http.ResponseWriter, r *http.Request) {
+	userID := userIDFromContext(r)
+
+	var params struct {
+		Token string `json:"token"`
+	}
+
+	if err := cfg.decodeJSON(w, r, &params); err != nil {
+		requestLogger(r).Warn("Error decoding parameters", "error", err)
+		respondWithError(w, r, http.StatusBadRequest, codeBadRequest, err.Error())
+		return
+	}
+
+	if params.Token == "" {
+		respondWithError(w, r, http.StatusBadRequest, codeValidationError, "Token is required")
+		return
+	}
+
+	dbToken, err := cfg.db.GetEmailChangeToken(r.Context(), params.Token)
+	if err != nil || dbToken.UserID != userID {
+		requestLogger(r).Warn("Error fetching email change token", "user_id", userID, "error", err)
+		respondWithError(w, r, http.StatusUnauthorized, codeInvalidToken, "Invalid verification token")
+		return
+	}
+
+	if dbToken.UsedAt.Valid {
+		requestLogger(r).Warn("Email change token already used", "user_id", userID)
+		respondWithError(w, r, http.StatusUnauthorized, codeInvalidToken, "Verification token already used")
+		return
+	}
+
+	if dbToken.ExpiresAt.Before(time.Now()) {
+		requestLogger(r).Warn("Email change token expired", "user_id", userID)
+		respondWithError(w, r, http.StatusUnauthorized, codeTokenExpired, "Verification token expired")
+		return
+	}
+
+	// The address may have been registered since the change was requested.
+	if ok, err := cfg.emailAvailable(r.Context(), dbToken.NewEmail); err != nil {
+		requestLogger(r).Error("Error checking email", "user_id", userID, "error", err)
+		respondWithError(w, r, http.StatusInternalServerError, codeInternal, "Failed to change email")
+		return
+	} else if !ok {
+		respondWithError(w, r, http.StatusConflict, codeConflict, "Email already in use")
+		return
+	}
+
+	// Claiming the token and setting the email are one statement, so if
+	// the address is taken between the check above and now, the token is
+	// left unused.
+	dbUser, err := cfg.db.ConfirmEmailChange(r.Context(), database.ConfirmEmailChangeParams{
+		Token:  dbToken.Token,
+		UserID: userID,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, r, http.StatusUnauthorized, codeInvalidToken, "Verification token already used")
+		return
+	}
+	if isUniqueViolation(err) {
+		respondWithError(w, r, http.StatusConflict, codeConflict, "Email already in use")
+		return
+	}
+	if err != nil {
+		requestLogger(r).Error("Error setting email", "user_id", userID, "error", err)
+		respondWithError(w, r, http.StatusInternalServerError, codeInternal, "Failed to change email")
+		return
+	}
+
+	if err := cfg.db.RevokeAllUserRefreshTokens(r.Context(), userID); err != nil {
+		requestLogger(r).Error("Error revoking refresh tokens", "user_id", userID, "error", err)
+		respondWithError(w, r, http.StatusInternalServerError, codeInternal, "Failed to revoke refresh tokens")
+		return
+	}
+
+	user := newUser(dbUser)
+
+	if err := respondWithJSON(w, http.StatusOK, user); err != nil {
+		requestLogger(r).Error("Error responding with JSON", "error", err)
+		return
+	}
+}
+
+// emailAvailable reports whether no account is registered with email.
+func (cfg *apiConfig) emailAvailable(ctx context.Context, email string) (bool, error) {
+	_, err := cfg.db.GetUserByEmail(ctx, email)
+	if errors.Is(err, sql.ErrNoRows) {
+		return true, nil
+	}
+	return false, err
+}
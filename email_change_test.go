@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/WOsaka/chirpy-server/internal/auth"
+	"github.com/WOsaka/chirpy-server/internal/database"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+type emailChangeDB struct {
+	database.Querier
+	users   map[uuid.UUID]database.User
+	tokens  map[string]database.EmailChangeToken
+	revoked map[uuid.UUID]bool
+}
+
+func (db *emailChangeDB) GetUserByID(ctx context.Context, id uuid.UUID) (database.User, error) {
+	user, ok := db.users[id]
+	if !ok {
+		return database.User{}, sql.ErrNoRows
+	}
+	return user, nil
+}
+
+func (db *emailChangeDB) GetUserByEmail(ctx context.Context, email string) (database.User, error) {
+	for _, user := range db.users {
+		if strings.EqualFold(user.Email, email) {
+			return user, nil
+		}
+	}
+	return database.User{}, sql.ErrNoRows
+}
+
+func (db *emailChangeDB) CreateEmailChangeToken(ctx context.Context, arg database.CreateEmailChangeTokenParams) (database.EmailChangeToken, error) {
+	token := database.EmailChangeToken{
+		Token:     arg.Token,
+		CreatedAt: time.Now(),
+		UserID:    arg.UserID,
+		NewEmail:  arg.NewEmail,
+		ExpiresAt: arg.ExpiresAt,
+	}
+	db.tokens[arg.Token] = token
+	return token, nil
+}
+
+func (db *emailChangeDB) GetEmailChangeToken(ctx context.Context, token string) (database.EmailChangeToken, error) {
+	t, ok := db.tokens[token]
+	if !ok {
+		return database.EmailChangeToken{}, sql.ErrNoRows
+	}
+	return t, nil
+}
+
+// ConfirmEmailChange mimics the CTE: the token is claimed and the email set
+// together, or neither if the email is taken.
+func (db *emailChangeDB) ConfirmEmailChange(ctx context.Context, arg database.ConfirmEmailChangeParams) (database.User, error) {
+	t, ok := db.tokens[arg.Token]
+	if !ok || t.UserID != arg.UserID || t.UsedAt.Valid {
+		return database.User{}, sql.ErrNoRows
+	}
+	for id, user := range db.users {
+		if id != arg.UserID && strings.EqualFold(user.Email, t.NewEmail) {
+			return database.User{}, &pq.Error{Code: "23505", Message: "duplicate key value violates unique constraint"}
+		}
+	}
+	t.UsedAt = sql.NullTime{Time: time.Now(), Valid: true}
+	db.tokens[arg.Token] = t
+	user := db.users[arg.UserID]
+	user.Email = t.NewEmail
+	user.EmailVerified = true
+	db.users[arg.UserID] = user
+	return user, nil
+}
+
+func (db *emailChangeDB) RevokeAllUserRefreshTokens(ctx context.Context, userID uuid.UUID) error {
+	db.revoked[userID] = true
+	return nil
+}
+
+func TestEmailChange(t *testing.T) {
+	hashedPassword, err := auth.HashPassword("current-password")
+	if err != nil {
+		t.Fatalf("HashPassword failed: %v", err)
+	}
+	userID := uuid.New()
+	db := &emailChangeDB{
+		users:   map[uuid.UUID]database.User{userID: {ID: userID, Email: "old@example.com", HashedPassword: hashedPassword}},
+		tokens:  map[string]database.EmailChangeToken{},
+		revoked: map[uuid.UUID]bool{},
+	}
+	cfg := &apiConfig{db: db, jwtKeys: auth.NewHS256Keys("secret"), mailer: logMailer{}}
+	jwt := newTestJWT(t, cfg, userID)
+
+	post := func(handler http.HandlerFunc, body string) int {
+		req := newJSONRequest(http.MethodPost, "/api/me/email", body)
+		req.Header.Set("Authorization", "Bearer "+jwt)
+		rec := httptest.NewRecorder()
+		cfg.jwtAuthMiddleware(handler).ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	code := post(cfg.requestEmailChangeHandler, `{"current_password": "wrong-password", "new_email": "new@example.com"}`)
+	if code != http.StatusUnauthorized {
+		t.Errorf("wrong password: got status %d, want %d", code, http.StatusUnauthorized)
+	}
+	if len(db.tokens) != 0 {
+		t.Errorf("wrong password created %d verification tokens; want 0", len(db.tokens))
+	}
+
+	code = post(cfg.requestEmailChangeHandler, `{"current_password": "current-password", "new_email": "New@Example.com"}`)
+	if code != http.StatusAccepted {
+		t.Fatalf("request: got status %d, want %d", code, http.StatusAccepted)
+	}
+	if len(db.tokens) != 1 {
+		t.Fatalf("request created %d verification tokens; want 1", len(db.tokens))
+	}
+	var pending database.EmailChangeToken
+	for _, token := range db.tokens {
+		pending = token
+	}
+	if pending.NewEmail != "new@example.com" {
+		t.Errorf("pending email = %q; want %q", pending.NewEmail, "new@example.com")
+	}
+	if got := db.users[userID].Email; got != "old@example.com" {
+		t.Errorf("email changed to %q before confirmation", got)
+	}
+
+	if code := post(cfg.confirmEmailChangeHandler, `{"token": "unknown"}`); code != http.StatusUnauthorized {
+		t.Errorf("unknown token: got status %d, want %d", code, http.StatusUnauthorized)
+	}
+
+	if code := post(cfg.confirmEmailChangeHandler, `{"token": "`+pending.Token+`"}`); code != http.StatusOK {
+		t.Fatalf("confirm: got status %d, want %d", code, http.StatusOK)
+	}
+	if got := db.users[userID]; got.Email != "new@example.com" || !got.EmailVerified {
+		t.Errorf("after confirm email = %q (verified %v); want %q (verified)", got.Email, got.EmailVerified, "new@example.com")
+	}
+	if !db.revoked[userID] {
+		t.Error("confirm did not revoke refresh tokens")
+	}
+
+	if code := post(cfg.confirmEmailChangeHandler, `{"token": "`+pending.Token+`"}`); code != http.StatusUnauthorized {
+		t.Errorf("reused token: got status %d, want %d", code, http.StatusUnauthorized)
+	}
+}
+
+// racingEmailChangeDB misses addresses in GetUserByEmail, as if they were
+// registered just after the confirm handler checked for them.
+type racingEmailChangeDB struct {
+	*emailChangeDB
+}
+
+func (db racingEmailChangeDB) GetUserByEmail(ctx context.Context, email string) (database.User, error) {
+	return database.User{}, sql.ErrNoRows
+}
+
+func TestConfirmEmailChangeConflict(t *testing.T) {
+	userID, otherID := uuid.New(), uuid.New()
+	db := &emailChangeDB{
+		users: map[uuid.UUID]database.User{
+			userID:  {ID: userID, Email: "old@example.com"},
+			otherID: {ID: otherID, Email: "taken@example.com"},
+		},
+		tokens: map[string]database.EmailChangeToken{
+			"pending": {Token: "pending", UserID: userID, NewEmail: "taken@example.com", ExpiresAt: time.Now().Add(time.Hour)},
+		},
+		revoked: map[uuid.UUID]bool{},
+	}
+	cfg := &apiConfig{db: racingEmailChangeDB{db}, jwtKeys: auth.NewHS256Keys("secret")}
+
+	req := newJSONRequest(http.MethodPost, "/api/me/email/confirm", `{"token": "pending"}`)
+	req.Header.Set("Authorization", "Bearer "+newTestJWT(t, cfg, userID))
+	rec := httptest.NewRecorder()
+	cfg.jwtAuthMiddleware(cfg.confirmEmailChangeHandler).ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("got status %d, want %d", rec.Code, http.StatusConflict)
+	}
+	if db.tokens["pending"].UsedAt.Valid {
+		t.Error("token was used up by a conflicting change")
+	}
+	if got := db.users[userID].Email; got != "old@example.com" {
+		t.Errorf("email changed to %q", got)
+	}
+}
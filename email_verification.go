@@ -0,0 +1,128 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/WOsaka/chirpy-server/internal/auth"
+	"github.com/WOsaka/chirpy-server/internal/database"
+)
+
+const emailVerificationTokenTTL = 24 * time.Hour
+
+// requestEmailVerificationHandler mails the authenticated user a token that
+// confirmEmailVerificationHandler accepts as proof they own their address.
+func (cfg *apiConfig) requestEmailVerificationHandler(w http.ResponseWriter, r *http.Request) {
+	userID := userIDFromContext(r)
+
+	dbUser, err := cfg.db.GetUserByID(r.Context(), userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, r, http.StatusNotFound, codeNotFound, "User not found")
+		return
+	}
+	if err != nil {
+		requestLogger(r).Error("Error fetching user", "user_id", userID, "error", err)
+		respondWithError(w, r, http.StatusInternalServerError, codeInternal, "Failed to request email verification")
+		return
+	}
+
+	if dbUser.EmailVerified {
+		respondWithError(w, r, http.StatusConflict, codeConflict, "Email already verified")
+		return
+	}
+
+	verificationToken, err := auth.MakeRefreshToken()
+	if err != nil {
+		requestLogger(r).Error("Error creating email verification token", "user_id", userID, "error", err)
+		respondWithError(w, r, http.StatusInternalServerError, codeInternal, "Failed to create verification token")
+		return
+	}
+
+	_, err = cfg.db.CreateEmailVerificationToken(r.Context(), database.CreateEmailVerificationTokenParams{
+		Token:     verificationToken,
+		UserID:    userID,
+		ExpiresAt: time.Now().Add(emailVerificationTokenTTL),
+	})
+	if err != nil {
+		requestLogger(r).Error("Error creating email verification token in database", "user_id", userID, "error", err)
+		respondWithError(w, r, http.StatusInternalServerError, codeInternal, "Failed to create verification token")
+		return
+	}
+
+	body := fmt.Sprintf("Use this token to verify your Chirpy email address: %s\nIt expires in %s.", verificationToken, emailVerificationTokenTTL)
+	if err := cfg.mailer.Send(dbUser.Email, "Verify your Chirpy email", body); err != nil {
+		requestLogger(r).Error("Error sending email verification", "user_id", userID, "error", err)
+		respondWithError(w, r, http.StatusInternalServerError, codeInternal, "Failed to send verification email")
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// confirmEmailVerificationHandler marks the authenticated user's email as
+// verified using a token from requestEmailVerificationHandler.
+func (cfg *apiConfig) confirmEmailVerificationHandler(w http.ResponseWriter, r *http.Request) {
+	userID := userIDFromContext(r)
+
+	var params struct {
+		Token string `json:"token"`
+	}
+
+	if err := cfg.decodeJSON(w, r, &params); err != nil {
+		requestLogger(r).Warn("Error decoding parameters", "error", err)
+		respondWithError(w, r, http.StatusBadRequest, codeBadRequest, err.Error())
+		return
+	}
+
+	if params.Token == "" {
+		respondWithError(w, r, http.StatusBadRequest, codeValidationError, "Token is required")
+		return
+	}
+
+	dbToken, err := cfg.db.GetEmailVerificationToken(r.Context(), params.Token)
+	if err != nil || dbToken.UserID != userID {
+		requestLogger(r).Warn("Error fetching email verification token", "user_id", userID, "error", err)
+		respondWithError(w, r, http.StatusUnauthorized, codeInvalidToken, "Invalid verification token")
+		return
+	}
+
+	if dbToken.UsedAt.Valid {
+		requestLogger(r).Warn("Email verification token already used", "user_id", userID)
+		respondWithError(w, r, http.StatusUnauthorized, codeInvalidToken, "Verification token already used")
+		return
+	}
+
+	if dbToken.ExpiresAt.Before(time.Now()) {
+		requestLogger(r).Warn("Email verification token expired", "user_id", userID)
+		respondWithError(w, r, http.StatusUnauthorized, codeTokenExpired, "Verification token expired")
+		return
+	}
+
+	claimed, err := cfg.db.MarkEmailVerificationTokenUsed(r.Context(), dbToken.Token)
+	if err != nil {
+		requestLogger(r).Error("Error marking email verification token used", "user_id", userID, "error", err)
+		respondWithError(w, r, http.StatusInternalServerError, codeInternal, "Failed to verify email")
+		return
+	}
+	if claimed == 0 {
+		respondWithError(w, r, http.StatusUnauthorized, codeInvalidToken, "Verification token already used")
+		return
+	}
+
+	dbUser, err := cfg.db.SetEmailVerifiedByUserID(r.Context(), userID)
+	if err != nil {
+		requestLogger(r).Error("Error setting email verified", "user_id", userID, "error", err)
+		respondWithError(w, r, http.StatusInternalServerError, codeInternal, "Failed to verify email")
+		return
+	}
+
+	user := newUser(dbUser)
+
+	if err := respondWithJSON(w, http.StatusOK, user); err != nil {
+		requestLogger(r).Error("Error responding with JSON", "error", err)
+		return
+	}
+}
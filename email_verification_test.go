@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/WOsaka/chirpy-server/internal/auth"
+	"github.com/WOsaka/chirpy-server/internal/database"
+	"github.com/google/uuid"
+)
+
+type emailVerificationDB struct {
+	database.Querier
+	users  map[uuid.UUID]database.User
+	tokens map[string]database.EmailVerificationToken
+}
+
+func (db *emailVerificationDB) GetUserByID(ctx context.Context, id uuid.UUID) (database.User, error) {
+	user, ok := db.users[id]
+	if !ok {
+		return database.User{}, sql.ErrNoRows
+	}
+	return user, nil
+}
+
+func (db *emailVerificationDB) CreateEmailVerificationToken(ctx context.Context, arg database.CreateEmailVerificationTokenParams) (database.EmailVerificationToken, error) {
+	token := database.EmailVerificationToken{
+		Token:     arg.Token,
+		CreatedAt: time.Now(),
+		UserID:    arg.UserID,
+		ExpiresAt: arg.ExpiresAt,
+	}
+	db.tokens[arg.Token] = token
+	return token, nil
+}
+
+func (db *emailVerificationDB) GetEmailVerificationToken(ctx context.Context, token string) (database.EmailVerificationToken, error) {
+	t, ok := db.tokens[token]
+	if !ok {
+		return database.EmailVerificationToken{}, sql.ErrNoRows
+	}
+	return t, nil
+}
+
+func (db *emailVerificationDB) MarkEmailVerificationTokenUsed(ctx context.Context, token string) (int64, error) {
+	t, ok := db.tokens[token]
+	if !ok || t.UsedAt.Valid {
+		return 0, nil
+	}
+	t.UsedAt = sql.NullTime{Time: time.Now(), Valid: true}
+	db.tokens[token] = t
+	return 1, nil
+}
+
+func (db *emailVerificationDB) SetEmailVerifiedByUserID(ctx context.Context, id uuid.UUID) (database.User, error) {
+	user := db.users[id]
+	user.EmailVerified = true
+	db.users[id] = user
+	return user, nil
+}
+
+func TestEmailVerification(t *testing.T) {
+	userID, otherID := uuid.New(), uuid.New()
+	db := &emailVerificationDB{
+		users: map[uuid.UUID]database.User{
+			userID:  {ID: userID, Email: "user@example.com"},
+			otherID: {ID: otherID, Email: "other@example.com"},
+		},
+		tokens: map[string]database.EmailVerificationToken{},
+	}
+	cfg := &apiConfig{db: db, jwtKeys: auth.NewHS256Keys("secret"), mailer: logMailer{}}
+
+	post := func(handler http.HandlerFunc, asUser uuid.UUID, body string) int {
+		req := newJSONRequest(http.MethodPost, "/api/users/verify", body)
+		req.Header.Set("Authorization", "Bearer "+newTestJWT(t, cfg, asUser))
+		rec := httptest.NewRecorder()
+		cfg.authMiddleware(handler).ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := post(cfg.requestEmailVerificationHandler, userID, ""); code != http.StatusAccepted {
+		t.Fatalf("request: got status %d, want %d", code, http.StatusAccepted)
+	}
+	if len(db.tokens) != 1 {
+		t.Fatalf("request created %d verification tokens; want 1", len(db.tokens))
+	}
+	var pending database.EmailVerificationToken
+	for _, token := range db.tokens {
+		pending = token
+	}
+
+	if code := post(cfg.confirmEmailVerificationHandler, userID, `{"token": "unknown"}`); code != http.StatusUnauthorized {
+		t.Errorf("unknown token: got status %d, want %d", code, http.StatusUnauthorized)
+	}
+	if code := post(cfg.confirmEmailVerificationHandler, otherID, `{"token": "`+pending.Token+`"}`); code != http.StatusUnauthorized {
+		t.Errorf("another user's token: got status %d, want %d", code, http.StatusUnauthorized)
+	}
+	if db.users[userID].EmailVerified {
+		t.Fatal("email verified before confirmation")
+	}
+
+	if code := post(cfg.confirmEmailVerificationHandler, userID, `{"token": "`+pending.Token+`"}`); code != http.StatusOK {
+		t.Fatalf("confirm: got status %d, want %d", code, http.StatusOK)
+	}
+	if !db.users[userID].EmailVerified {
+		t.Error("confirm did not verify the email")
+	}
+
+	if code := post(cfg.confirmEmailVerificationHandler, userID, `{"token": "`+pending.Token+`"}`); code != http.StatusUnauthorized {
+		t.Errorf("reused token: got status %d, want %d", code, http.StatusUnauthorized)
+	}
+	if code := post(cfg.requestEmailVerificationHandler, userID, ""); code != http.StatusConflict {
+		t.Errorf("request once verified: got status %d, want %d", code, http.StatusConflict)
+	}
+}
+
+func TestCreateChirpRequiresVerifiedEmail(t *testing.T) {
+	verifiedID, unverifiedID := uuid.New(), uuid.New()
+	tests := []struct {
+		name     string
+		required bool
+		userID   uuid.UUID
+		expected int
+	}{
+		{"ungated unverified", false, unverifiedID, http.StatusCreated},
+		{"gated verified", true, verifiedID, http.StatusCreated},
+		{"gated unverified", true, unverifiedID, http.StatusForbidden},
+	}
+
+	for _, test := range tests {
+		db := &chirpsDB{
+			chirps: map[uuid.UUID]database.Chirp{},
+			authors: map[uuid.UUID]database.User{
+				verifiedID:   {ID: verifiedID, EmailVerified: true},
+				unverifiedID: {ID: unverifiedID},
+			},
+		}
+		cfg := &apiConfig{
+			db:                   db,
+			jwtKeys:              auth.NewHS256Keys("secret"),
+			maxChirpLength:       140,
+			requireVerifiedEmail: test.required,
+		}
+
+		rec := postChirp(t, cfg, test.userID, `{"body": "hello"}`)
+		if rec.Code != test.expected {
+			t.Errorf("%s: got status %d, want %d", test.name, rec.Code, test.expected)
+		}
+		if created := len(db.chirps) == 1; created != (test.expected == http.StatusCreated) {
+			t.Errorf("%s: chirp created = %v", test.name, created)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/WOsaka/chirpy-server/internal/database"
+	"github.com/google/uuid"
+)
+
+// chirpExportBatchSize is how many chirps exportChirpsCSVHandler fetches and
+// writes at a time, bounding its memory use whatever the table's size.
+const chirpExportBatchSize = 500
+
+// chirpExportTimeout replaces the request timeout for exports, which take as
+// long as the table is large; see streamingRoutes.
+const chirpExportTimeout = 10 * time.Minute
+
+// exportChirpsCSVHandler streams every live chirp, oldest first, as a CSV
+// download. author_id limits the export to one user's chirps.
+func (cfg *apiConfig) exportChirpsCSVHandler(w http.ResponseWriter, r *http.Request) {
+	params := database.ListChirpsAfterParams{Limit: chirpExportBatchSize}
+	if v := r.URL.Query().Get("author_id"); v != "" {
+		authorID, err := uuid.Parse(v)
+		if err != nil {
+			respondWithError(w, r, http.StatusBadRequest, codeValidationError, "Invalid author_id")
+			return
+		}
+		params.AuthorID = uuid.NullUUID{UUID: authorID, Valid: true}
+	}
+
+	// Fetch the first batch before writing anything, so a database that's
+	// down still gets a proper error response.
+	dbChirps, err := cfg.db.ListChirpsAfter(r.Context(), params)
+	if err != nil {
+		requestLogger(r).Error("Error fetching chirps for export", "error", err)
+		respondWithError(w, r, http.StatusInternalServerError, codeInternal, "Failed to export chirps")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="chirps.csv"`)
+	w.WriteHeader(http.StatusOK)
+
+	rc := http.NewResponseController(w)
+	out := csv.NewWriter(w)
+	out.Write([]string{"id", "created_at", "updated_at", "user_id", "body"})
+	for {
+		for _, dbChirp := range dbChirps {
+			out.Write([]string{
+				dbChirp.ID.String(),
+				dbChirp.CreatedAt.UTC().Format(time.RFC3339Nano),
+				dbChirp.UpdatedAt.UTC().Format(time.RFC3339Nano),
+				dbChirp.UserID.String(),
+				dbChirp.Body,
+			})
+		}
+		out.Flush()
+		if err := out.Error(); err != nil {
+			requestLogger(r).Warn("Error writing chirp export", "error", err)
+			return
+		}
+		if err := rc.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+			requestLogger(r).Warn("Error flushing chirp export", "error", err)
+			return
+		}
+
+		if len(dbChirps) < chirpExportBatchSize {
+			return
+		}
+		last := dbChirps[len(dbChirps)-1]
+		params.AfterCreatedAt, params.AfterID = last.CreatedAt, last.ID
+
+		// The status is already sent, so a failure now can only cut the
+		// download short.
+		dbChirps, err = cfg.db.ListChirpsAfter(r.Context(), params)
+		if err != nil {
+			requestLogger(r).Error("Error fetching chirps for export", "error", err)
+			return
+		}
+	}
+}
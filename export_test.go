@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/WOsaka/chirpy-server/internal/database"
+	"github.com/google/uuid"
+)
+
+type exportDB struct {
+	database.Querier
+	chirps []database.Chirp
+	calls  int
+}
+
+func (db *exportDB) ListChirpsAfter(ctx context.Context, arg database.ListChirpsAfterParams) ([]database.Chirp, error) {
+	db.calls++
+	sorted := slices.Clone(db.chirps)
+	slices.SortFunc(sorted, func(a, b database.Chirp) int {
+		if c := a.CreatedAt.Compare(b.CreatedAt); c != 0 {
+			return c
+		}
+		return slices.Compare(a.ID[:], b.ID[:])
+	})
+
+	var page []database.Chirp
+	for _, chirp := range sorted {
+		if arg.AuthorID.Valid && chirp.UserID != arg.AuthorID.UUID {
+			continue
+		}
+		if c := chirp.CreatedAt.Compare(arg.AfterCreatedAt); c < 0 || c == 0 && slices.Compare(chirp.ID[:], arg.AfterID[:]) <= 0 {
+			continue
+		}
+		page = append(page, chirp)
+		if len(page) == int(arg.Limit) {
+			break
+		}
+	}
+	return page, nil
+}
+
+func exportChirps(t *testing.T, cfg *apiConfig, query string) [][]string {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/api/chirps.csv?"+query, nil)
+	rec := httptest.NewRecorder()
+	cfg.exportChirpsCSVHandler(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", rec.Code, http.StatusOK)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "text/csv; charset=utf-8" {
+		t.Errorf("Content-Type = %q; want text/csv", ct)
+	}
+	if cd := rec.Header().Get("Content-Disposition"); cd != `attachment; filename="chirps.csv"` {
+		t.Errorf("Content-Disposition = %q", cd)
+	}
+
+	records, err := csv.NewReader(rec.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("parsing CSV: %v", err)
+	}
+	if len(records) == 0 || !slices.Equal(records[0], []string{"id", "created_at", "updated_at", "user_id", "body"}) {
+		t.Fatalf("header = %v", records)
+	}
+	return records[1:]
+}
+
+func TestExportChirpsCSV(t *testing.T) {
+	userID := uuid.New()
+	created := time.Date(2024, 5, 1, 12, 30, 0, 123456000, time.UTC)
+	tricky := database.Chirp{
+		ID:        uuid.New(),
+		CreatedAt: created,
+		UpdatedAt: created.Add(time.Minute),
+		UserID:    userID,
+		Body:      "commas, \"quotes\"\nand newlines",
+	}
+	db := &exportDB{chirps: []database.Chirp{tricky}}
+	cfg := &apiConfig{db: db}
+
+	rows := exportChirps(t, cfg, "")
+	if len(rows) != 1 {
+		t.Fatalf("got %d rows, want 1", len(rows))
+	}
+	want := []string{
+		tricky.ID.String(),
+		"2024-05-01T12:30:00.123456Z",
+		"2024-05-01T12:31:00.123456Z",
+		userID.String(),
+		tricky.Body,
+	}
+	if !slices.Equal(rows[0], want) {
+		t.Errorf("row = %q; want %q", rows[0], want)
+	}
+}
+
+func TestExportChirpsCSVBatches(t *testing.T) {
+	userID, otherID := uuid.New(), uuid.New()
+	db := &exportDB{}
+	start := time.Now()
+	for i := range chirpExportBatchSize + 10 {
+		author := userID
+		if i%2 == 1 {
+			author = otherID
+		}
+		// Pairs share a timestamp, so batches must break ties by ID.
+		db.chirps = append(db.chirps, database.Chirp{
+			ID:        uuid.New(),
+			CreatedAt: start.Add(time.Duration(i/2) * time.Second),
+			UserID:    author,
+			Body:      fmt.Sprintf("chirp %d", i),
+		})
+	}
+	cfg := &apiConfig{db: db}
+
+	rows := exportChirps(t, cfg, "")
+	if len(rows) != len(db.chirps) {
+		t.Fatalf("got %d rows, want %d", len(rows), len(db.chirps))
+	}
+	if db.calls != 2 {
+		t.Errorf("fetched %d batches; want 2", db.calls)
+	}
+	seen := map[string]bool{}
+	for _, row := range rows {
+		if seen[row[0]] {
+			t.Fatalf("chirp %s exported twice", row[0])
+		}
+		seen[row[0]] = true
+	}
+
+	rows = exportChirps(t, cfg, "author_id="+otherID.String())
+	if len(rows) != (chirpExportBatchSize+10)/2 {
+		t.Errorf("author_id: got %d rows, want %d", len(rows), (chirpExportBatchSize+10)/2)
+	}
+	for _, row := range rows {
+		if row[3] != otherID.String() {
+			t.Errorf("author_id: exported chirp by %s", row[3])
+		}
+	}
+}
+
+// flushRecorder notes how much of the body had been written at each Flush.
+type flushRecorder struct {
+	*httptest.ResponseRecorder
+	flushedAt []int
+}
+
+func (rec *flushRecorder) Flush() {
+	rec.flushedAt = append(rec.flushedAt, rec.Body.Len())
+	rec.ResponseRecorder.Flush()
+}
+
+func TestExportChirpsCSVFlushesThroughMiddleware(t *testing.T) {
+	db := &exportDB{}
+	start := time.Now()
+	for i := range chirpExportBatchSize + 10 {
+		db.chirps = append(db.chirps, database.Chirp{
+			ID:        uuid.New(),
+			CreatedAt: start.Add(time.Duration(i) * time.Second),
+			Body:      fmt.Sprintf("chirp %d", i),
+		})
+	}
+	cfg := &apiConfig{db: db}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/chirps.csv", cfg.exportChirpsCSVHandler)
+	// A request timeout shorter than the export shows it isn't applied.
+	handler := serverHandler(mux, newHTTPMetrics(), newRateLimiter(rateLimit{}, nil), time.Nanosecond)
+
+	rec := &flushRecorder{ResponseRecorder: httptest.NewRecorder()}
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/chirps.csv", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", rec.Code, http.StatusOK)
+	}
+	if len(rec.flushedAt) != 2 {
+		t.Fatalf("flushed %d times; want once per batch", len(rec.flushedAt))
+	}
+	if first := rec.flushedAt[0]; first == 0 || first >= rec.Body.Len() {
+		t.Errorf("first flush after %d of %d bytes; want the first batch on its own", first, rec.Body.Len())
+	}
+
+	records, err := csv.NewReader(rec.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("parsing CSV: %v", err)
+	}
+	if len(records) != len(db.chirps)+1 {
+		t.Errorf("got %d records, want %d", len(records), len(db.chirps)+1)
+	}
+}
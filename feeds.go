@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"time"
+
+	"github.com/WOsaka/chirpy-server/internal/database"
+)
+
+// feedSize is how many of the newest chirps the syndication feeds carry.
+const feedSize = 50
+
+const feedTitle = "Chirpy"
+
+// jsonFeed is a JSON Feed 1.1 document; see https://jsonfeed.org/version/1.1.
+type jsonFeed struct {
+	Version     string         `json:"version"`
+	Title       string         `json:"title"`
+	HomePageURL string         `json:"home_page_url"`
+	FeedURL     string         `json:"feed_url"`
+	Items       []jsonFeedItem `json:"items"`
+}
+
+type jsonFeedItem struct {
+	ID            string           `json:"id"`
+	URL           string           `json:"url"`
+	ContentText   string           `json:"content_text"`
+	DatePublished time.Time        `json:"date_published"`
+	Authors       []jsonFeedAuthor `json:"authors"`
+}
+
+type jsonFeedAuthor struct {
+	Name string `json:"name"`
+}
+
+// rssFeed is an RSS 2.0 document. Items credit their author with
+// dc:creator, since RSS's own author element must be an email address.
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	DC      string     `xml:"xmlns:dc,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title       string    `xml:"title"`
+	Link        string    `xml:"link"`
+	Description string    `xml:"description"`
+	Items       []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	GUID        rssGUID `xml:"guid"`
+	Link        string  `xml:"link"`
+	Description string  `xml:"description"`
+	PubDate     string  `xml:"pubDate"`
+	Creator     string  `xml:"dc:creator"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	ID          string `xml:",chardata"`
+}
+
+// requestBaseURL is the scheme and host the client used to reach the server,
+// for building absolute links.
+func requestBaseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// feedAuthorName credits a chirp to its author's display name, falling back
+// to their username and then their ID.
+func feedAuthorName(row database.GetRecentChirpsWithAuthorsRow) string {
+	if row.DisplayName.Valid && row.DisplayName.String != "" {
+		return row.DisplayName.String
+	}
+	if row.Username.Valid && row.Username.String != "" {
+		return row.Username.String
+	}
+	return row.Chirp.UserID.String()
+}
+
+// getJSONFeedHandler serves the newest chirps as a JSON Feed.
+func (cfg *apiConfig) getJSONFeedHandler(w http.ResponseWriter, r *http.Request) {
+	rows, err := cfg.db.GetRecentChirpsWithAuthors(r.Context(), feedSize)
+	if err != nil {
+		requestLogger(r).Error("Error fetching feed chirps", "error", err)
+		respondWithError(w, r, http.StatusInternalServerError, codeInternal, "Failed to fetch chirps")
+		return
+	}
+
+	baseURL := requestBaseURL(r)
+	feed := jsonFeed{
+		Version:     "https://jsonfeed.org/version/1.1",
+		Title:       feedTitle,
+		HomePageURL: baseURL + "/app/",
+		FeedURL:     baseURL + r.URL.Path,
+		Items:       []jsonFeedItem{},
+	}
+	for _, row := range rows {
+		feed.Items = append(feed.Items, jsonFeedItem{
+			ID:            row.Chirp.ID.String(),
+			URL:           baseURL + "/api/chirps/" + row.Chirp.ID.String(),
+			ContentText:   row.Chirp.Body,
+			DatePublished: row.Chirp.CreatedAt.UTC(),
+			Authors:       []jsonFeedAuthor{{Name: feedAuthorName(row)}},
+		})
+	}
+
+	response, err := json.Marshal(feed)
+	if err != nil {
+		requestLogger(r).Error("Error encoding JSON feed", "error", err)
+		respondWithError(w, r, http.StatusInternalServerError, codeInternal, "Failed to build feed")
+		return
+	}
+	w.Header().Set("Content-Type", "application/feed+json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(response)
+}
+
+// getRSSFeedHandler serves the newest chirps as an RSS 2.0 feed.
+func (cfg *apiConfig) getRSSFeedHandler(w http.ResponseWriter, r *http.Request) {
+	rows, err := cfg.db.GetRecentChirpsWithAuthors(r.Context(), feedSize)
+	if err != nil {
+		requestLogger(r).Error("Error fetching feed chirps", "error", err)
+		respondWithError(w, r, http.StatusInternalServerError, codeInternal, "Failed to fetch chirps")
+		return
+	}
+
+	baseURL := requestBaseURL(r)
+	feed := rssFeed{
+		Version: "2.0",
+		DC:      "http://purl.org/dc/elements/1.1/",
+		Channel: rssChannel{
+			Title:       feedTitle,
+			Link:        baseURL + "/app/",
+			Description: "The latest chirps",
+		},
+	}
+	for _, row := range rows {
+		feed.Channel.Items = append(feed.Channel.Items, rssItem{
+			GUID:        rssGUID{ID: row.Chirp.ID.String()},
+			Link:        baseURL + "/api/chirps/" + row.Chirp.ID.String(),
+			Description: row.Chirp.Body,
+			PubDate:     row.Chirp.CreatedAt.UTC().Format(time.RFC1123Z),
+			Creator:     feedAuthorName(row),
+		})
+	}
+
+	response, err := xml.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		requestLogger(r).Error("Error encoding RSS feed", "error", err)
+		respondWithError(w, r, http.StatusInternalServerError, codeInternal, "Failed to build feed")
+		return
+	}
+	w.Header().Set("Content-Type", "application/rss+xml; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(xml.Header))
+	w.Write(response)
+}
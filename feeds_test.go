@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/WOsaka/chirpy-server/internal/database"
+	"github.com/google/uuid"
+)
+
+type feedDB struct {
+	database.Querier
+	rows []database.GetRecentChirpsWithAuthorsRow
+}
+
+func (db *feedDB) GetRecentChirpsWithAuthors(ctx context.Context, limit int32) ([]database.GetRecentChirpsWithAuthorsRow, error) {
+	return db.rows[:min(int(limit), len(db.rows))], nil
+}
+
+func newFeedDB() *feedDB {
+	published := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	return &feedDB{rows: []database.GetRecentChirpsWithAuthorsRow{
+		{
+			Chirp:       database.Chirp{ID: uuid.New(), UserID: uuid.New(), Body: "newest <chirp>", CreatedAt: published.Add(time.Hour)},
+			DisplayName: sql.NullString{String: "Ada", Valid: true},
+		},
+		{
+			Chirp:    database.Chirp{ID: uuid.New(), UserID: uuid.New(), Body: "older chirp", CreatedAt: published},
+			Username: sql.NullString{String: "grace", Valid: true},
+		},
+	}}
+}
+
+func TestJSONFeed(t *testing.T) {
+	db := newFeedDB()
+	cfg := &apiConfig{db: db}
+
+	req := httptest.NewRequest(http.MethodGet, "http://chirpy.example/api/feed.json", nil)
+	rec := httptest.NewRecorder()
+	cfg.getJSONFeedHandler(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", rec.Code, http.StatusOK)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/feed+json" {
+		t.Errorf("Content-Type = %q; want application/feed+json", ct)
+	}
+
+	var feed jsonFeed
+	if err := json.NewDecoder(rec.Body).Decode(&feed); err != nil {
+		t.Fatalf("decoding feed: %v", err)
+	}
+	if feed.Version != "https://jsonfeed.org/version/1.1" || feed.FeedURL != "http://chirpy.example/api/feed.json" {
+		t.Errorf("feed = %+v", feed)
+	}
+	if len(feed.Items) != len(db.rows) {
+		t.Fatalf("got %d items, want %d", len(feed.Items), len(db.rows))
+	}
+	for i, want := range []struct{ body, author string }{{"newest <chirp>", "Ada"}, {"older chirp", "grace"}} {
+		item, row := feed.Items[i], db.rows[i]
+		if item.ID != row.Chirp.ID.String() || item.ContentText != want.body || !item.DatePublished.Equal(row.Chirp.CreatedAt) {
+			t.Errorf("item %d = %+v", i, item)
+		}
+		if len(item.Authors) != 1 || item.Authors[0].Name != want.author {
+			t.Errorf("item %d authors = %+v; want %q", i, item.Authors, want.author)
+		}
+	}
+}
+
+func TestRSSFeed(t *testing.T) {
+	db := newFeedDB()
+	cfg := &apiConfig{db: db}
+
+	req := httptest.NewRequest(http.MethodGet, "http://chirpy.example/api/feed.rss", nil)
+	rec := httptest.NewRecorder()
+	cfg.getRSSFeedHandler(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", rec.Code, http.StatusOK)
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/rss+xml") {
+		t.Errorf("Content-Type = %q; want application/rss+xml", ct)
+	}
+
+	var feed struct {
+		Version string `xml:"version,attr"`
+		Channel struct {
+			Title string `xml:"title"`
+			Items []struct {
+				GUID        string `xml:"guid"`
+				Description string `xml:"description"`
+				PubDate     string `xml:"pubDate"`
+				Creator     string `xml:"http://purl.org/dc/elements/1.1/ creator"`
+			} `xml:"item"`
+		} `xml:"channel"`
+	}
+	if err := xml.NewDecoder(rec.Body).Decode(&feed); err != nil {
+		t.Fatalf("decoding feed: %v", err)
+	}
+	if feed.Version != "2.0" || feed.Channel.Title != feedTitle {
+		t.Errorf("feed = %+v", feed)
+	}
+	if len(feed.Channel.Items) != len(db.rows) {
+		t.Fatalf("got %d items, want %d", len(feed.Channel.Items), len(db.rows))
+	}
+	item := feed.Channel.Items[0]
+	if item.GUID != db.rows[0].Chirp.ID.String() || item.Description != "newest <chirp>" || item.Creator != "Ada" {
+		t.Errorf("first item = %+v", item)
+	}
+	if published, err := time.Parse(time.RFC1123Z, item.PubDate); err != nil || !published.Equal(db.rows[0].Chirp.CreatedAt) {
+		t.Errorf("pubDate = %q; want %v", item.PubDate, db.rows[0].Chirp.CreatedAt)
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/WOsaka/chirpy-server/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultFeedLimit = 20
+	maxFeedLimit     = 100
+)
+
+func (cfg *apiConfig) followUserHandler(w http.ResponseWriter, r *http.Request) {
+	cfg.setFollow(w, r, true)
+}
+
+func (cfg *apiConfig) unfollowUserHandler(w http.ResponseWriter, r *http.Request) {
+	cfg.setFollow(w, r, false)
+}
+
+// setFollow follows or unfollows the user in the path for the authenticated
+// user. Both directions are idempotent; following yourself is rejected.
+func (cfg *apiConfig) setFollow(w http.ResponseWriter, r *http.Request, follow bool) {
+	followerID := userIDFromContext(r)
+
+	followeeID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, r, http.StatusBadRequest, codeValidationError, "Invalid user ID")
+		return
+	}
+
+	if follow {
+		if followeeID == followerID {
+			respondWithError(w, r, http.StatusBadRequest, codeValidationError, "You cannot follow yourself")
+			return
+		}
+
+		exists, err := cfg.db.UserExists(r.Context(), followeeID)
+		if err != nil {
+			requestLogger(r).Error("Error fetching user", "user_id", followerID, "error", err)
+			respondWithError(w, r, http.StatusInternalServerError, codeInternal, "Failed to follow user")
+			return
+		}
+		if !exists {
+			respondWithError(w, r, http.StatusNotFound, codeNotFound, "User not found")
+			return
+		}
+
+		if err := cfg.db.FollowUser(r.Context(), database.FollowUserParams{
+			FollowerID: followerID,
+			FolloweeID: followeeID,
+		}); err != nil {
+			requestLogger(r).Error("Error following user", "user_id", followerID, "error", err)
+			respondWithError(w, r, http.StatusInternalServerError, codeInternal, "Failed to follow user")
+			return
+		}
+	} else {
+		if err := cfg.db.UnfollowUser(r.Context(), database.UnfollowUserParams{
+			FollowerID: followerID,
+			FolloweeID: followeeID,
+		}); err != nil {
+			requestLogger(r).Error("Error unfollowing user", "user_id", followerID, "error", err)
+			respondWithError(w, r, http.StatusInternalServerError, codeInternal, "Failed to unfollow user")
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getFeedHandler returns chirps from the users the caller follows, newest
+// first. limit defaults to defaultFeedLimit and is capped at maxFeedLimit;
+// offset skips that many chirps for paging.
+func (cfg *apiConfig) getFeedHandler(w http.ResponseWriter, r *http.Request) {
+	userID := userIDFromContext(r)
+
+	limit := defaultFeedLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		parsed, err := strconv.Atoi(v)
+		if err != nil || parsed < 1 {
+			respondWithError(w, r, http.StatusBadRequest, codeValidationError, "Invalid limit")
+			return
+		}
+		limit = min(parsed, maxFeedLimit)
+	}
+
+	offset := 0
+	if v := r.URL.Query().Get("offset"); v != "" {
+		parsed, err := strconv.Atoi(v)
+		if err != nil || parsed < 0 {
+			respondWithError(w, r, http.StatusBadRequest, codeValidationError, "Invalid offset")
+			return
+		}
+		offset = parsed
+	}
+
+	dbChirps, err := cfg.db.GetFeedChirps(r.Context(), database.GetFeedChirpsParams{
+		FollowerID: userID,
+		Limit:      int32(limit),
+		Offset:     int32(offset),
+	})
+	if err != nil {
+		requestLogger(r).Error("Error fetching feed", "user_id", userID, "error", err)
+		respondWithError(w, r, http.StatusInternalServerError, codeInternal, "Failed to fetch feed")
+		return
+	}
+
+	chirps := []Chirp{}
+	for _, dbChirp := range dbChirps {
+		chirps = append(chirps, newChirp(dbChirp))
+	}
+
+	if err := cfg.attachLikeCounts(r.Context(), chirps); err != nil {
+		requestLogger(r).Error("Error fetching like counts", "user_id", userID, "error", err)
+		respondWithError(w, r, http.StatusInternalServerError, codeInternal, "Failed to fetch feed")
+		return
+	}
+
+	if err := respondWithJSON(w, http.StatusOK, chirps); err != nil {
+		requestLogger(r).Error("Error responding with JSON", "error", err)
+		return
+	}
+}
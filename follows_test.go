@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/WOsaka/chirpy-server/internal/auth"
+	"github.com/WOsaka/chirpy-server/internal/database"
+	"github.com/google/uuid"
+)
+
+type followsDB struct {
+	*chirpsDB
+	users map[uuid.UUID]bool
+	// follows maps follower IDs to the set of users they follow.
+	follows map[uuid.UUID]map[uuid.UUID]bool
+}
+
+func (db *followsDB) UserExists(ctx context.Context, id uuid.UUID) (bool, error) {
+	return db.users[id], nil
+}
+
+func (db *followsDB) FollowUser(ctx context.Context, arg database.FollowUserParams) error {
+	if db.follows[arg.FollowerID] == nil {
+		db.follows[arg.FollowerID] = map[uuid.UUID]bool{}
+	}
+	db.follows[arg.FollowerID][arg.FolloweeID] = true
+	return nil
+}
+
+func (db *followsDB) UnfollowUser(ctx context.Context, arg database.UnfollowUserParams) error {
+	delete(db.follows[arg.FollowerID], arg.FolloweeID)
+	return nil
+}
+
+// GetFeedChirps mimics the join on follows, ordered newest first and paged
+// with LIMIT/OFFSET.
+func (db *followsDB) GetFeedChirps(ctx context.Context, arg database.GetFeedChirpsParams) ([]database.Chirp, error) {
+	var chirps []database.Chirp
+	for _, chirp := range db.chirps {
+		if db.follows[arg.FollowerID][chirp.UserID] {
+			chirps = append(chirps, chirp)
+		}
+	}
+	sort.Slice(chirps, func(i, j int) bool {
+		return chirps[i].CreatedAt.After(chirps[j].CreatedAt)
+	})
+	start := min(int(arg.Offset), len(chirps))
+	end := min(start+int(arg.Limit), len(chirps))
+	return chirps[start:end], nil
+}
+
+func TestFollowAndFeed(t *testing.T) {
+	me, alice, bob := uuid.New(), uuid.New(), uuid.New()
+	now := time.Now()
+	chirps := map[uuid.UUID]database.Chirp{}
+	for i, author := range []uuid.UUID{alice, bob, alice, me, alice} {
+		id := uuid.New()
+		chirps[id] = database.Chirp{ID: id, UserID: author, Body: "chirp", CreatedAt: now.Add(time.Duration(i) * time.Minute)}
+	}
+	db := &followsDB{
+		chirpsDB: &chirpsDB{chirps: chirps},
+		users:    map[uuid.UUID]bool{me: true, alice: true, bob: true},
+		follows:  map[uuid.UUID]map[uuid.UUID]bool{},
+	}
+	cfg := &apiConfig{db: db, jwtKeys: auth.NewHS256Keys("secret")}
+	token := newTestJWT(t, cfg, me)
+
+	setFollow := func(method string, id uuid.UUID) int {
+		req := httptest.NewRequest(method, "/api/users/"+id.String()+"/follow", nil)
+		req.SetPathValue("userID", id.String())
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		if method == http.MethodPost {
+			cfg.authMiddleware(cfg.followUserHandler).ServeHTTP(rec, req)
+		} else {
+			cfg.authMiddleware(cfg.unfollowUserHandler).ServeHTTP(rec, req)
+		}
+		return rec.Code
+	}
+	getFeed := func(query string) []Chirp {
+		req := httptest.NewRequest(http.MethodGet, "/api/feed"+query, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		cfg.authMiddleware(cfg.getFeedHandler).ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET /api/feed%s: got status %d, want %d", query, rec.Code, http.StatusOK)
+		}
+		var feed []Chirp
+		if err := json.NewDecoder(rec.Body).Decode(&feed); err != nil {
+			t.Fatalf("decoding response: %v", err)
+		}
+		return feed
+	}
+
+	if code := setFollow(http.MethodPost, me); code != http.StatusBadRequest {
+		t.Errorf("follow self: got status %d, want %d", code, http.StatusBadRequest)
+	}
+	if code := setFollow(http.MethodPost, uuid.New()); code != http.StatusNotFound {
+		t.Errorf("follow unknown user: got status %d, want %d", code, http.StatusNotFound)
+	}
+	for range 2 {
+		if code := setFollow(http.MethodPost, alice); code != http.StatusNoContent {
+			t.Fatalf("follow: got status %d, want %d", code, http.StatusNoContent)
+		}
+	}
+
+	feed := getFeed("")
+	if len(feed) != 3 {
+		t.Fatalf("feed has %d chirps; want 3", len(feed))
+	}
+	for i, chirp := range feed {
+		if chirp.UserID != alice {
+			t.Errorf("feed[%d] is by %v; want only chirps by %v", i, chirp.UserID, alice)
+		}
+		if i > 0 && chirp.CreatedAt.After(feed[i-1].CreatedAt) {
+			t.Errorf("feed is not newest first at index %d", i)
+		}
+	}
+
+	page := getFeed("?limit=2&offset=2")
+	if len(page) != 1 || page[0].ID != feed[2].ID {
+		t.Errorf("second page = %v; want only %v", page, feed[2].ID)
+	}
+
+	if code := setFollow(http.MethodDelete, alice); code != http.StatusNoContent {
+		t.Fatalf("unfollow: got status %d, want %d", code, http.StatusNoContent)
+	}
+	if feed := getFeed(""); len(feed) != 0 {
+		t.Errorf("feed after unfollow has %d chirps; want 0", len(feed))
+	}
+}
@@ -1,13 +1,26 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"io"
 	"net/http"
-	"sort"
+	"net/netip"
+	"net/url"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
+	"unicode/utf8"
 
 	"github.com/WOsaka/chirpy-server/internal/auth"
 	"github.com/WOsaka/chirpy-server/internal/database"
@@ -16,28 +29,145 @@ import (
 
 type apiConfig struct {
 	fileserverHits atomic.Int32
-	db             *database.Queries
-	platform       string
-	jwtSecret      string
-	polkaKey       string
+	// hitsMu guards flushedHits, the part of fileserverHits already added to
+	// the metrics table.
+	hitsMu            sync.Mutex
+	flushedHits       int32
+	db                database.Querier
+	sqlDB             *sql.DB
+	platform          string
+	jwtKeys           auth.JWTKeys
+	polkaKey          string
+	mailer            mailer
+	maxChirpLength    int
+	registrationOpen  bool
+	passwordMinLength int
+	// storeChirpIPs records the creating client's IP on each chirp for abuse
+	// investigation. Off by default for privacy.
+	storeChirpIPs  bool
+	trustedProxies []netip.Prefix
+	// maxBodyBytes caps JSON request bodies; zero means defaultMaxBodyBytes.
+	maxBodyBytes int64
+	// verifyPolkaSignature additionally requires Polka webhooks to carry an
+	// X-Polka-Signature HMAC of the body keyed with polkaKey.
+	verifyPolkaSignature bool
+	// bcryptCost is the work factor for new password hashes; zero means
+	// auth.DefaultBcryptCost.
+	bcryptCost int
+	// profaneWords are censored in chirp bodies; nil means
+	// defaultProfaneWords.
+	profaneWords []string
+	// profaneMask replaces censored words; profaneMaskLength matches each
+	// word's length in asterisks and empty means defaultProfaneMask.
+	profaneMask string
+	// requireVerifiedEmail stops users chirping until they have verified
+	// their email address.
+	requireVerifiedEmail bool
+	// chirpLimiter caps how many chirps each user may create in any window,
+	// independently of the per-IP limiter; nil means unlimited.
+	chirpLimiter *slidingWindowLimiter
+	// startedAt is when the server process started, for reporting uptime.
+	startedAt time.Time
+	// refreshTokenTTL is how long issued refresh tokens last; zero means
+	// defaultRefreshTokenTTL.
+	refreshTokenTTL time.Duration
+	// refreshTokenGrace is how long a rotated refresh token still answers
+	// with its replacement, for clients that refresh twice concurrently.
+	// Zero disables it.
+	refreshTokenGrace time.Duration
+	// authCookieName is the cookie that carries access tokens for browser
+	// clients; empty means defaultAuthCookieName.
+	authCookieName string
 }
 
 type User struct {
-	ID           uuid.UUID `json:"id"`
-	CreatedAt    time.Time `json:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at"`
-	Email        string    `json:"email"`
-	Token        string    `json:"token"`
-	RefreshToken string    `json:"refresh_token"`
-	IsChirpyRed  bool      `json:"is_chirpy_red"`
+	ID            uuid.UUID `json:"id"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+	Email         string    `json:"email"`
+	Token         string    `json:"token"`
+	RefreshToken  string    `json:"refresh_token"`
+	IsChirpyRed   bool      `json:"is_chirpy_red"`
+	EmailVerified bool      `json:"email_verified"`
+	IsAdmin       bool      `json:"is_admin"`
+	Username      string    `json:"username,omitempty"`
+	DisplayName   string    `json:"display_name,omitempty"`
+	// LastLoginAt is unset until the user's first login.
+	LastLoginAt *time.Time `json:"last_login_at,omitempty"`
 }
 
 type Chirp struct {
-	ID        uuid.UUID `json:"id"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
-	Body      string    `json:"body"`
-	UserID    uuid.UUID `json:"user_id"`
+	ID        uuid.UUID  `json:"id"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	Body      string     `json:"body"`
+	Length    int        `json:"length"`
+	UserID    uuid.UUID  `json:"user_id"`
+	ParentID  *uuid.UUID `json:"parent_id,omitempty"`
+	MediaURL  string     `json:"media_url,omitempty"`
+	LikeCount int64      `json:"like_count"`
+	// ReplyCount is only filled in when fetching a single chirp.
+	ReplyCount *int64 `json:"reply_count,omitempty"`
+	// RawBody is the uncensored body, only shown to admins fetching a single
+	// chirp with ?raw=true, and only when filtering changed it.
+	RawBody string `json:"raw_body,omitempty"`
+	// DeletedAt is only set on soft-deleted chirps, which are listed only
+	// for admins asking for ?include_deleted=true.
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	// Author is only filled in when the client asks for ?expand=author.
+	Author *ChirpAuthor `json:"author,omitempty"`
+}
+
+type ChirpAuthor struct {
+	ID          uuid.UUID `json:"id"`
+	Email       string    `json:"email"`
+	IsChirpyRed bool      `json:"is_chirpy_red"`
+	Username    string    `json:"username,omitempty"`
+	DisplayName string    `json:"display_name,omitempty"`
+}
+
+// newUser maps a database row to the API representation. Timestamps are
+// converted to UTC so they always serialize with a "Z" suffix, whatever the
+// server's or database's time zone.
+func newUser(dbUser database.User) User {
+	user := User{
+		ID:            dbUser.ID,
+		CreatedAt:     dbUser.CreatedAt.UTC(),
+		UpdatedAt:     dbUser.UpdatedAt.UTC(),
+		Email:         dbUser.Email,
+		IsChirpyRed:   dbUser.IsChirpyRed,
+		EmailVerified: dbUser.EmailVerified,
+		IsAdmin:       dbUser.IsAdmin,
+		Username:      dbUser.Username.String,
+		DisplayName:   dbUser.DisplayName.String,
+	}
+	if dbUser.LastLoginAt.Valid {
+		lastLoginAt := dbUser.LastLoginAt.Time.UTC()
+		user.LastLoginAt = &lastLoginAt
+	}
+	return user
+}
+
+// newChirp maps a database row to the API representation, with timestamps
+// in UTC like newUser.
+func newChirp(dbChirp database.Chirp) Chirp {
+	chirp := Chirp{
+		ID:        dbChirp.ID,
+		CreatedAt: dbChirp.CreatedAt.UTC(),
+		UpdatedAt: dbChirp.UpdatedAt.UTC(),
+		Body:      dbChirp.Body,
+		Length:    utf8.RuneCountInString(dbChirp.Body),
+		UserID:    dbChirp.UserID,
+		MediaURL:  dbChirp.MediaUrl.String,
+	}
+	if dbChirp.ParentChirpID.Valid {
+		chirp.ParentID = &dbChirp.ParentChirpID.UUID
+	}
+	if dbChirp.DeletedAt.Valid {
+		deletedAt := dbChirp.DeletedAt.Time.UTC()
+		chirp.DeletedAt = &deletedAt
+	}
+	return chirp
 }
 
 func healthCheckHandler(w http.ResponseWriter, r *http.Request) {
@@ -48,9 +178,102 @@ func healthCheckHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("OK"))
 }
 
+const readinessTimeout = 2 * time.Second
+
+// readinessHandler reports whether the server can serve requests, which
+// unlike healthCheckHandler requires a reachable database.
+func (cfg *apiConfig) readinessHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+
+	if err := cfg.sqlDB.PingContext(ctx); err != nil {
+		requestLogger(r).Error("Database ping failed", "error", err)
+		respondWithError(w, r, http.StatusServiceUnavailable, codeServiceUnavailable, "Database unavailable")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("OK"))
+}
+
+// versionHandler reports which build is running and for how long, to
+// confirm deployments.
+func (cfg *apiConfig) versionHandler(w http.ResponseWriter, r *http.Request) {
+	if err := respondWithJSON(w, http.StatusOK, struct {
+		Version       string    `json:"version"`
+		GoVersion     string    `json:"go_version"`
+		StartedAt     time.Time `json:"started_at"`
+		UptimeSeconds int64     `json:"uptime_seconds"`
+	}{
+		Version:       version,
+		GoVersion:     runtime.Version(),
+		StartedAt:     cfg.startedAt,
+		UptimeSeconds: int64(time.Since(cfg.startedAt).Seconds()),
+	}); err != nil {
+		requestLogger(r).Error("Error responding with JSON", "error", err)
+		return
+	}
+}
+
+type publicConfig struct {
+	MaxChirpLength            int  `json:"max_chirp_length"`
+	RegistrationOpen          bool `json:"registration_open"`
+	EmailVerificationRequired bool `json:"email_verification_required"`
+	MaxChirpsPageLimit        int  `json:"max_chirps_page_limit"`
+	RecentChirpsDefaultLimit  int  `json:"recent_chirps_default_limit"`
+	RecentChirpsMaxLimit      int  `json:"recent_chirps_max_limit"`
+}
+
+func (cfg *apiConfig) publicConfigHandler(w http.ResponseWriter, r *http.Request) {
+	resp := publicConfig{
+		MaxChirpLength:            cfg.maxChirpLength,
+		RegistrationOpen:          cfg.registrationOpen,
+		EmailVerificationRequired: cfg.requireVerifiedEmail,
+		MaxChirpsPageLimit:        maxChirpsPageLimit,
+		RecentChirpsDefaultLimit:  defaultRecentChirpsLimit,
+		RecentChirpsMaxLimit:      maxRecentChirpsLimit,
+	}
+	if err := respondWithJSON(w, http.StatusOK, resp); err != nil {
+		requestLogger(r).Error("Error responding with JSON", "error", err)
+		return
+	}
+}
+
+// metricsHandler renders the admin metrics page, or with Accept:
+// application/json the same numbers plus user and chirp counts as JSON.
 func (cfg *apiConfig) metricsHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	hits := cfg.fileserverHits.Load()
+
+	if acceptsJSON(r) {
+		userCount, err := cfg.db.CountUsers(r.Context())
+		if err != nil {
+			requestLogger(r).Error("Error counting users", "error", err)
+			respondWithError(w, r, http.StatusInternalServerError, codeInternal, "Failed to fetch metrics")
+			return
+		}
+		chirpCount, err := cfg.db.CountChirps(r.Context())
+		if err != nil {
+			requestLogger(r).Error("Error counting chirps", "error", err)
+			respondWithError(w, r, http.StatusInternalServerError, codeInternal, "Failed to fetch metrics")
+			return
+		}
+
+		if err := respondWithJSON(w, http.StatusOK, struct {
+			FileserverHits int32 `json:"fileserver_hits"`
+			UserCount      int64 `json:"user_count"`
+			ChirpCount     int64 `json:"chirp_count"`
+		}{
+			FileserverHits: hits,
+			UserCount:      userCount,
+			ChirpCount:     chirpCount,
+		}); err != nil {
+			requestLogger(r).Error("Error responding with JSON", "error", err)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	htmlTempl := `
 		<html>
 			<body>
@@ -65,102 +288,355 @@ func (cfg *apiConfig) metricsHandler(w http.ResponseWriter, r *http.Request) {
 
 func (cfg *apiConfig) resetHandler(w http.ResponseWriter, r *http.Request) {
 	if cfg.platform != "dev" {
-		http.Error(w, "Reset is only allowed in development mode", http.StatusForbidden)
+		respondWithError(w, r, http.StatusForbidden, codeForbidden, "Reset is only allowed in development mode")
+		return
+	}
+	if err := cfg.resetFileserverHits(r.Context()); err != nil {
+		requestLogger(r).Error("Error resetting fileserver hits", "error", err)
+		respondWithError(w, r, http.StatusInternalServerError, codeInternal, "Failed to reset metrics")
 		return
 	}
-	cfg.fileserverHits.Store(0)
-	cfg.db.DeleteAllUsers(r.Context())
+
+	// Children first so the reset doesn't depend on ON DELETE CASCADE.
+	resets := []struct {
+		table string
+		reset func(context.Context) error
+	}{
+		{"refresh_tokens", cfg.db.DeleteAllRefreshTokens},
+		{"chirps", cfg.db.DeleteAllChirps},
+		{"users", cfg.db.DeleteAllUsers},
+		{"deleted_chirp_ids", cfg.db.DeleteAllChirpTombstones},
+	}
+	for _, table := range resets {
+		if err := table.reset(r.Context()); err != nil {
+			requestLogger(r).Error("Error resetting table", "table", table.table, "error", err)
+			respondWithError(w, r, http.StatusInternalServerError, codeInternal, "Failed to reset database")
+			return
+		}
+	}
+
 	w.Write([]byte("Hits counter and user table reset"))
 }
 
-func (cfg *apiConfig) createChirpHandler(w http.ResponseWriter, r *http.Request) {
+// adminCreateUserHandler lets admins onboard users directly, even while
+// public registration is closed. It sits behind adminMiddleware since it can
+// create other admins.
+func (cfg *apiConfig) adminCreateUserHandler(w http.ResponseWriter, r *http.Request) {
+	if cfg.platform != "dev" {
+		respondWithError(w, r, http.StatusForbidden, codeForbidden, "Admin user creation is only allowed in development mode")
+		return
+	}
+
 	var params struct {
-		Body string `json:"body"`
+		Email         string `json:"email"`
+		Password      string `json:"password"`
+		EmailVerified bool   `json:"email_verified"`
+		IsChirpyRed   bool   `json:"is_chirpy_red"`
+		IsAdmin       bool   `json:"is_admin"`
+		Username      string `json:"username"`
+		DisplayName   string `json:"display_name"`
+	}
+
+	if err := cfg.decodeJSON(w, r, &params); err != nil {
+		requestLogger(r).Warn("Error decoding parameters", "error", err)
+		respondWithError(w, r, http.StatusBadRequest, codeBadRequest, err.Error())
+		return
 	}
 
-	decoder := json.NewDecoder(r.Body)
-	defer r.Body.Close()
+	email, fieldErrors := cfg.credentialFieldErrors(params.Email, params.Password, false)
+	if len(fieldErrors) > 0 {
+		respondWithValidationError(w, r, "Invalid user details", fieldErrors)
+		return
+	}
+	params.Email = email
 
-	if err := decoder.Decode(&params); err != nil {
-		log.Printf("Error decoding parameters: %s", err)
-		respondWithError(w, http.StatusInternalServerError, "Invalid request body")
+	username, displayName, err := profileFields(params.Username, params.DisplayName)
+	if err != nil {
+		respondWithError(w, r, http.StatusBadRequest, codeValidationError, err.Error())
 		return
 	}
+	if username.Valid {
+		if ok, err := cfg.usernameAvailable(r.Context(), username.String, uuid.Nil); err != nil {
+			requestLogger(r).Error("Error checking username", "error", err)
+			respondWithError(w, r, http.StatusInternalServerError, codeInternal, "Failed to create user")
+			return
+		} else if !ok {
+			respondWithError(w, r, http.StatusConflict, codeConflict, "Username already taken")
+			return
+		}
+	}
 
-	token, err := auth.GetBearerToken(r.Header)
+	hashedPassword, err := cfg.hashPassword(params.Password)
 	if err != nil {
-		log.Printf("Error getting bearer token: %s", err)
-		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		requestLogger(r).Error("Error hashing password", "error", err)
+		respondWithError(w, r, http.StatusInternalServerError, codeInternal, "Failed to hash password")
 		return
 	}
 
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	dbUser, err := cfg.db.CreateUserWithOptions(r.Context(), database.CreateUserWithOptionsParams{
+		Email:          params.Email,
+		HashedPassword: hashedPassword,
+		IsChirpyRed:    params.IsChirpyRed,
+		EmailVerified:  params.EmailVerified,
+		IsAdmin:        params.IsAdmin,
+		Username:       username,
+		DisplayName:    displayName,
+	})
+	if isUniqueViolation(err) {
+		respondWithError(w, r, http.StatusConflict, codeConflict, "Email already registered")
+		return
+	}
 	if err != nil {
-		log.Printf("Error validating JWT: %s", err)
-		respondWithError(w, http.StatusUnauthorized, "Invalid token")
+		requestLogger(r).Error("Error creating user", "error", err)
+		respondWithError(w, r, http.StatusInternalServerError, codeInternal, "Failed to create user")
+		return
+	}
+
+	user := newUser(dbUser)
+
+	if err := respondWithJSON(w, http.StatusCreated, user); err != nil {
+		requestLogger(r).Error("Error responding with JSON", "error", err)
 		return
 	}
+}
+
+const (
+	idempotencyKeyTTL       = 24 * time.Hour
+	maxIdempotencyKeyLength = 255
+	maxMediaURLLength       = 2048
+)
+
+// validMediaURL reports whether s is an absolute http or https URL with a
+// host, short enough to store as a chirp's media_url.
+func validMediaURL(s string) bool {
+	if len(s) > maxMediaURLLength {
+		return false
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return false
+	}
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+func (cfg *apiConfig) createChirpHandler(w http.ResponseWriter, r *http.Request) {
+	var params struct {
+		Body     string     `json:"body"`
+		ParentID *uuid.UUID `json:"parent_id"`
+		MediaURL *string    `json:"media_url"`
+	}
+
+	if err := cfg.decodeJSON(w, r, &params); err != nil {
+		requestLogger(r).Warn("Error decoding parameters", "error", err)
+		respondWithError(w, r, http.StatusBadRequest, codeBadRequest, err.Error())
+		return
+	}
+
+	userID := userIDFromContext(r)
+
+	if cfg.requireVerifiedEmail {
+		dbUser, err := cfg.db.GetUserByID(r.Context(), userID)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			requestLogger(r).Error("Error fetching user", "user_id", userID, "error", err)
+			respondWithError(w, r, http.StatusInternalServerError, codeInternal, "Failed to create chirp")
+			return
+		}
+		if !dbUser.EmailVerified {
+			requestLogger(r).Warn("Unverified user tried to chirp", "user_id", userID)
+			respondWithError(w, r, http.StatusForbidden, codeEmailNotVerified, "Verify your email address before chirping")
+			return
+		}
+	}
+
+	// A retried request with the same Idempotency-Key gets the chirp the
+	// first attempt created instead of a duplicate.
+	idempotencyKey := r.Header.Get("Idempotency-Key")
+	if len(idempotencyKey) > maxIdempotencyKeyLength {
+		respondWithError(w, r, http.StatusBadRequest, codeValidationError, "Idempotency-Key is too long")
+		return
+	}
+	if idempotencyKey != "" {
+		chirpID, err := cfg.db.GetChirpIDForIdempotencyKey(r.Context(), database.GetChirpIDForIdempotencyKeyParams{
+			UserID:         userID,
+			IdempotencyKey: idempotencyKey,
+			Since:          time.Now().Add(-idempotencyKeyTTL),
+		})
+		if err == nil {
+			dbChirp, err := cfg.db.GetChirpByID(r.Context(), chirpID)
+			if err != nil {
+				requestLogger(r).Error("Error fetching chirp for idempotency key", "user_id", userID, "error", err)
+				respondWithError(w, r, http.StatusInternalServerError, codeInternal, "Failed to fetch chirp")
+				return
+			}
+			w.Header().Set("Location", "/api/chirps/"+dbChirp.ID.String())
+			if err := respondWithJSON(w, http.StatusOK, newChirp(dbChirp)); err != nil {
+				requestLogger(r).Error("Error responding with JSON", "error", err)
+			}
+			return
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			requestLogger(r).Error("Error fetching idempotency key", "user_id", userID, "error", err)
+			respondWithError(w, r, http.StatusInternalServerError, codeInternal, "Failed to create chirp")
+			return
+		}
+	}
 
 	chirp := params.Body
-	if len(chirp) > 140 {
-		respondWithError(w, http.StatusBadRequest, "Chirp is too long")
+	if utf8.RuneCountInString(chirp) > cfg.maxChirpLength {
+		respondWithError(w, r, http.StatusBadRequest, codeValidationError, fmt.Sprintf("Chirp is too long (max %d characters)", cfg.maxChirpLength))
 		return
 	}
 
+	var mediaURL sql.NullString
+	if params.MediaURL != nil {
+		if !validMediaURL(*params.MediaURL) {
+			respondWithValidationError(w, r, "Invalid chirp", map[string]string{
+				"media_url": "must be an http or https URL",
+			})
+			return
+		}
+		mediaURL = sql.NullString{String: *params.MediaURL, Valid: true}
+	}
+
+	var creatorIP sql.NullString
+	if cfg.storeChirpIPs {
+		creatorIP = sql.NullString{String: forwardedClientIP(r, cfg.trustedProxies), Valid: true}
+	}
+
+	var parentChirpID uuid.NullUUID
+	if params.ParentID != nil {
+		parent, err := cfg.db.GetChirpByID(r.Context(), *params.ParentID)
+		if errors.Is(err, sql.ErrNoRows) {
+			respondWithError(w, r, http.StatusNotFound, codeNotFound, "Parent chirp not found")
+			return
+		}
+		if err != nil {
+			requestLogger(r).Error("Error fetching parent chirp", "user_id", userID, "error", err)
+			respondWithError(w, r, http.StatusInternalServerError, codeInternal, "Failed to fetch parent chirp")
+			return
+		}
+		parentChirpID = uuid.NullUUID{UUID: parent.ID, Valid: true}
+	}
+
+	if cfg.chirpLimiter != nil {
+		if allowed, retryAfter := cfg.chirpLimiter.allow(userID.String()); !allowed {
+			requestLogger(r).Warn("Chirp rate limit exceeded", "user_id", userID)
+			respondWithRateLimit(w, r, "Too many chirps", retryAfter)
+			return
+		}
+	}
+
+	// Everyone sees the censored body; the original is kept for admin
+	// review only when there was something to censor.
+	body := cfg.replaceProfane(chirp)
+	var rawBody sql.NullString
+	if body != chirp {
+		rawBody = sql.NullString{String: chirp, Valid: true}
+	}
+
 	dbChirp, err := cfg.db.CreateChirp(r.Context(), database.CreateChirpParams{
-		Body:   replaceProfane(chirp),
-		UserID: userID,
+		Body:          body,
+		UserID:        userID,
+		ParentChirpID: parentChirpID,
+		CreatorIp:     creatorIP,
+		RawBody:       rawBody,
+		MediaUrl:      mediaURL,
 	})
 	if err != nil {
-		log.Printf("Error creating chirp: %s", err)
-		respondWithError(w, http.StatusInternalServerError, "Failed to create chirp")
+		requestLogger(r).Error("Error creating chirp", "user_id", userID, "error", err)
+		respondWithError(w, r, http.StatusInternalServerError, codeInternal, "Failed to create chirp")
 		return
 	}
 
-	resp := Chirp{
-		ID:        dbChirp.ID,
-		CreatedAt: dbChirp.CreatedAt,
-		UpdatedAt: dbChirp.UpdatedAt,
-		Body:      dbChirp.Body,
-		UserID:    dbChirp.UserID,
+	if idempotencyKey != "" {
+		if err := cfg.db.SaveChirpIdempotencyKey(r.Context(), database.SaveChirpIdempotencyKeyParams{
+			UserID:         userID,
+			IdempotencyKey: idempotencyKey,
+			ChirpID:        dbChirp.ID,
+		}); err != nil {
+			requestLogger(r).Error("Error saving idempotency key", "user_id", userID, "error", err)
+		}
+	}
+
+	for _, hashtag := range extractHashtags(dbChirp.Body) {
+		if err := cfg.db.AddChirpHashtag(r.Context(), database.AddChirpHashtagParams{
+			ChirpID: dbChirp.ID,
+			Hashtag: hashtag,
+		}); err != nil {
+			requestLogger(r).Error("Error saving hashtag", "user_id", userID, "hashtag", hashtag, "error", err)
+		}
 	}
+	cfg.saveMentions(r, dbChirp)
+
+	resp := newChirp(dbChirp)
+	w.Header().Set("Location", "/api/chirps/"+dbChirp.ID.String())
 	if err := respondWithJSON(w, http.StatusCreated, resp); err != nil {
-		log.Printf("Error responding with JSON: %s", err)
+		requestLogger(r).Error("Error responding with JSON", "error", err)
 		return
 	}
 }
 
 func (cfg *apiConfig) createUserHandler(w http.ResponseWriter, r *http.Request) {
+	if !cfg.registrationOpen {
+		respondWithError(w, r, http.StatusForbidden, codeForbidden, "Registration closed")
+		return
+	}
+
 	var params struct {
-		Password string `json:"password"`
-		Email    string `json:"email"`
+		Password    string `json:"password"`
+		Email       string `json:"email"`
+		Username    string `json:"username"`
+		DisplayName string `json:"display_name"`
 	}
 
-	decoder := json.NewDecoder(r.Body)
-	defer r.Body.Close()
+	if err := cfg.decodeJSON(w, r, &params); err != nil {
+		requestLogger(r).Warn("Error decoding parameters", "error", err)
+		respondWithError(w, r, http.StatusBadRequest, codeBadRequest, err.Error())
+		return
+	}
 
-	if err := decoder.Decode(&params); err != nil {
-		log.Printf("Error decoding parameters: %s", err)
-		respondWithError(w, http.StatusInternalServerError, "Invalid request body")
+	email, fieldErrors := cfg.credentialFieldErrors(params.Email, params.Password, false)
+	if len(fieldErrors) > 0 {
+		respondWithValidationError(w, r, "Invalid user details", fieldErrors)
 		return
 	}
+	params.Email = email
 
-	if params.Email == "" || params.Password == "" {
-		respondWithError(w, http.StatusBadRequest, "Email is required")
+	username, displayName, err := profileFields(params.Username, params.DisplayName)
+	if err != nil {
+		respondWithError(w, r, http.StatusBadRequest, codeValidationError, err.Error())
 		return
 	}
+	if username.Valid {
+		if ok, err := cfg.usernameAvailable(r.Context(), username.String, uuid.Nil); err != nil {
+			requestLogger(r).Error("Error checking username", "error", err)
+			respondWithError(w, r, http.StatusInternalServerError, codeInternal, "Failed to create user")
+			return
+		} else if !ok {
+			respondWithError(w, r, http.StatusConflict, codeConflict, "Username already taken")
+			return
+		}
+	}
 
-	dbUser, err := cfg.db.CreateUser(r.Context(), params.Email)
+	dbUser, err := cfg.db.CreateUser(r.Context(), database.CreateUserParams{
+		Email:       params.Email,
+		Username:    username,
+		DisplayName: displayName,
+	})
+	if isUniqueViolation(err) {
+		respondWithError(w, r, http.StatusConflict, codeConflict, "Email already registered")
+		return
+	}
 	if err != nil {
-		log.Printf("Error creating user: %s", err)
-		respondWithError(w, http.StatusInternalServerError, "Failed to create user")
+		requestLogger(r).Error("Error creating user", "error", err)
+		respondWithError(w, r, http.StatusInternalServerError, codeInternal, "Failed to create user")
 		return
 	}
 
-	hashedPassword, err := auth.HashPassword(params.Password)
+	hashedPassword, err := cfg.hashPassword(params.Password)
 	if err != nil {
-		log.Printf("Error hashing password: %s", err)
-		respondWithError(w, http.StatusInternalServerError, "Failed to hash password")
+		requestLogger(r).Error("Error hashing password", "error", err)
+		respondWithError(w, r, http.StatusInternalServerError, codeInternal, "Failed to hash password")
 		return
 	}
 
@@ -168,402 +644,1103 @@ func (cfg *apiConfig) createUserHandler(w http.ResponseWriter, r *http.Request)
 		HashedPassword: hashedPassword,
 		Email:          params.Email,
 	}); err != nil {
-		log.Printf("Error setting password: %s", err)
-		respondWithError(w, http.StatusInternalServerError, "Failed to set password")
+		requestLogger(r).Error("Error setting password", "error", err)
+		respondWithError(w, r, http.StatusInternalServerError, codeInternal, "Failed to set password")
 		return
 	}
 
-	user := User{
-		ID:          dbUser.ID,
-		CreatedAt:   dbUser.CreatedAt,
-		UpdatedAt:   dbUser.UpdatedAt,
-		Email:       dbUser.Email,
-		IsChirpyRed: dbUser.IsChirpyRed,
-	}
+	user := newUser(dbUser)
 
 	if err := respondWithJSON(w, http.StatusCreated, user); err != nil {
-		log.Printf("Error responding with JSON: %s", err)
+		requestLogger(r).Error("Error responding with JSON", "error", err)
 		return
 	}
 
 }
 
+// getChirpsHandler lists chirps. All filters are optional and combine with
+// AND: author_id restricts to one author, q keeps only chirps whose body
+// contains q, ignoring case, hashtag keeps only chirps tagged with it (with
+// or without the leading '#'), and created_after and created_before are
+// inclusive RFC3339 bounds on the creation time. sort=asc|desc orders the
+// filtered results by creation time; the default is ascending. expand=author
+// embeds each chirp's author, fetched in the same query. clean=true leaves
+// out chirps that profanity filtering had to censor. min_length keeps only
+// chirps with at least that many characters. Soft-deleted chirps are
+// hidden unless include_deleted=true, which is admin-only. limit and offset
+// return one page of the results, with Link headers to the neighbouring
+// pages. Last-Modified is the latest updated_at of any matching chirp, and a
+// request whose If-Modified-Since is no earlier gets 304 Not Modified; like
+// counts and chirps dropping out of the results don't count as
+// modifications. The array is streamed as rows are read from the database.
 func (cfg *apiConfig) getChirpsHandler(w http.ResponseWriter, r *http.Request) {
+	cfg.listChirps(w, r, uuid.NullUUID{})
+}
+
+// getMyChirpsHandler lists the authenticated user's own chirps, taking the
+// same query parameters as getChirpsHandler apart from author_id.
+func (cfg *apiConfig) getMyChirpsHandler(w http.ResponseWriter, r *http.Request) {
+	cfg.listChirps(w, r, uuid.NullUUID{UUID: userIDFromContext(r), Valid: true})
+}
+
+// listChirps serves getChirpsHandler's listing. A valid author overrides the
+// author_id query parameter.
+func (cfg *apiConfig) listChirps(w http.ResponseWriter, r *http.Request, author uuid.NullUUID) {
 	authorID := r.URL.Query().Get("author_id")
+	query := r.URL.Query().Get("q")
+	hashtag := strings.ToLower(strings.TrimPrefix(r.URL.Query().Get("hashtag"), "#"))
 	sorted := r.URL.Query().Get("sort")
+	expand := r.URL.Query().Get("expand")
+	if expand != "" && expand != "author" {
+		respondWithError(w, r, http.StatusBadRequest, codeValidationError, "Invalid expand, only author is supported")
+		return
+	}
 
-	var dbChirps []database.Chirp
-	var err error
-
-	if authorID != "" {
-		parsedAuthorID, err := uuid.Parse(authorID)
+	var params database.ListChirpsParams
+	if v := r.URL.Query().Get("include_deleted"); v != "" {
+		includeDeleted, err := strconv.ParseBool(v)
 		if err != nil {
-			log.Printf("Error parsing author ID: %s", err)
-			respondWithError(w, http.StatusBadRequest, "Invalid author_id")
+			respondWithError(w, r, http.StatusBadRequest, codeValidationError, "Invalid include_deleted")
 			return
 		}
-
-		dbChirps, err = cfg.db.GetChirpsByUserID(r.Context(), parsedAuthorID)
+		if includeDeleted {
+			userID, claims, ok := cfg.authenticate(w, r)
+			if !ok || !cfg.authorizeAdmin(w, r, userID, claims) {
+				return
+			}
+		}
+		params.IncludeDeleted = includeDeleted
+	}
+	if v := r.URL.Query().Get("clean"); v != "" {
+		clean, err := strconv.ParseBool(v)
 		if err != nil {
-			log.Printf("Error fetching chirps by author ID: %s", err)
-			respondWithError(w, http.StatusInternalServerError, "Failed to fetch chirps")
+			respondWithError(w, r, http.StatusBadRequest, codeValidationError, "Invalid clean")
 			return
 		}
-	} else {
-		dbChirps, err = cfg.db.GetAllChirps(r.Context())
+		params.Clean = clean
+	}
+	if v := r.URL.Query().Get("min_length"); v != "" {
+		minLength, err := strconv.ParseInt(v, 10, 32)
+		if err != nil || minLength < 0 {
+			respondWithError(w, r, http.StatusBadRequest, codeValidationError, "Invalid min_length")
+			return
+		}
+		params.MinLength = int32(minLength)
+	}
+	if author.Valid {
+		params.AuthorID = author
+	} else if authorID != "" {
+		parsedAuthorID, err := uuid.Parse(authorID)
+		if err != nil {
+			requestLogger(r).Warn("Error parsing author ID", "error", err)
+			respondWithError(w, r, http.StatusBadRequest, codeValidationError, "Invalid author_id")
+			return
+		}
+		params.AuthorID = uuid.NullUUID{UUID: parsedAuthorID, Valid: true}
+	}
+	if query != "" {
+		params.Query = sql.NullString{String: escapeLikePattern(query), Valid: true}
+	}
+	if hashtag != "" {
+		params.Hashtag = sql.NullString{String: hashtag, Valid: true}
+	}
+	for _, bound := range []struct {
+		name  string
+		param *sql.NullTime
+	}{
+		{"created_after", &params.CreatedAfter},
+		{"created_before", &params.CreatedBefore},
+	} {
+		v := r.URL.Query().Get(bound.name)
+		if v == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, v)
 		if err != nil {
-			log.Printf("Error fetching chirps: %s", err)
-			respondWithError(w, http.StatusInternalServerError, "Failed to fetch chirps")
+			respondWithError(w, r, http.StatusBadRequest, codeValidationError, "Invalid "+bound.name+", expected an RFC3339 timestamp")
 			return
 		}
+		*bound.param = sql.NullTime{Time: t.UTC(), Valid: true}
+	}
+	if params.CreatedAfter.Valid && params.CreatedBefore.Valid && params.CreatedAfter.Time.After(params.CreatedBefore.Time) {
+		respondWithError(w, r, http.StatusBadRequest, codeValidationError, "created_after must not be later than created_before")
+		return
 	}
 
-	chirps := []Chirp{}
-	for _, dbChirp := range dbChirps {
-		chirp := Chirp{
-			ID:        dbChirp.ID,
-			CreatedAt: dbChirp.CreatedAt,
-			UpdatedAt: dbChirp.UpdatedAt,
-			Body:      dbChirp.Body,
-			UserID:    dbChirp.UserID,
+	params.NewestFirst = sorted == "desc"
+
+	limit, offset := 0, 0
+	if v := r.URL.Query().Get("limit"); v != "" {
+		parsed, err := strconv.Atoi(v)
+		if err != nil || parsed < 1 {
+			respondWithError(w, r, http.StatusBadRequest, codeValidationError, "Invalid limit")
+			return
+		}
+		limit = min(parsed, maxChirpsPageLimit)
+		params.Limit = sql.NullInt32{Int32: int32(limit), Valid: true}
+	}
+	if v := r.URL.Query().Get("offset"); v != "" {
+		parsed, err := strconv.ParseInt(v, 10, 32)
+		if err != nil || parsed < 0 {
+			respondWithError(w, r, http.StatusBadRequest, codeValidationError, "Invalid offset")
+			return
+		}
+		offset = int(parsed)
+		params.Offset = int32(parsed)
+	}
+
+	// The count covers every matching chirp, not just this page, so it
+	// answers both the Link headers and Last-Modified before any rows are
+	// read.
+	summary, err := cfg.db.CountListChirps(r.Context(), database.CountListChirpsParams{
+		IncludeDeleted: params.IncludeDeleted,
+		AuthorID:       params.AuthorID,
+		Query:          params.Query,
+		Hashtag:        params.Hashtag,
+		CreatedAfter:   params.CreatedAfter,
+		CreatedBefore:  params.CreatedBefore,
+		Clean:          params.Clean,
+		MinLength:      params.MinLength,
+	})
+	if err != nil {
+		requestLogger(r).Error("Error counting chirps", "error", err)
+		respondWithError(w, r, http.StatusInternalServerError, codeInternal, "Failed to fetch chirps")
+		return
+	}
+	if limit > 0 {
+		if link := paginationLinks(r.URL, limit, offset, int(summary.Count)); link != "" {
+			w.Header().Set("Link", link)
+		}
+	}
+	if summary.Count > 0 {
+		w.Header().Set("Last-Modified", summary.LastUpdatedAt.UTC().Format(http.TimeFormat))
+		if notModifiedSince(r, summary.LastUpdatedAt) {
+			w.WriteHeader(http.StatusNotModified)
+			return
 		}
-		chirps = append(chirps, chirp)
 	}
 
-	if sorted == "desc" {
-		sort.Slice(chirps, func(i, j int) bool {
-			return chirps[i].CreatedAt.After(chirps[j].CreatedAt)
+	cursor, ok := cfg.db.(chirpCursor)
+	if !ok {
+		requestLogger(r).Error("Error fetching chirps", "error", errNoChirpCursor)
+		respondWithError(w, r, http.StatusInternalServerError, codeInternal, "Failed to fetch chirps")
+		return
+	}
+	out := newJSONArrayWriter(w, http.StatusOK)
+	if expand == "author" {
+		err = cursor.EachListChirpsWithAuthors(r.Context(), database.ListChirpsWithAuthorsParams(params), func(row database.ListChirpsWithAuthorsRow) error {
+			chirp := newChirp(row.Chirp)
+			chirp.LikeCount = row.LikeCount
+			chirp.Author = &ChirpAuthor{
+				ID:          row.Chirp.UserID,
+				Email:       row.Email,
+				IsChirpyRed: row.IsChirpyRed,
+				Username:    row.Username.String,
+				DisplayName: row.DisplayName.String,
+			}
+			return out.Write(&chirp)
 		})
-	} else if sorted == "asc" {
-		sort.Slice(chirps, func(i, j int) bool {
-			return chirps[i].CreatedAt.Before(chirps[j].CreatedAt)
+	} else {
+		err = cursor.EachListChirps(r.Context(), params, func(row database.ListChirpsRow) error {
+			chirp := newChirp(row.Chirp)
+			chirp.LikeCount = row.LikeCount
+			return out.Write(&chirp)
 		})
 	}
+	if err != nil {
+		if !out.Started() {
+			requestLogger(r).Error("Error fetching chirps", "error", err)
+			respondWithError(w, r, http.StatusInternalServerError, codeInternal, "Failed to fetch chirps")
+			return
+		}
+		// The 200 and part of the array are already sent. Aborting drops
+		// the connection, so the client sees a broken response instead of
+		// a truncated array that happens to parse.
+		requestLogger(r).Error("Error streaming chirps", "error", err)
+		panic(http.ErrAbortHandler)
+	}
+	if err := out.Close(); err != nil {
+		requestLogger(r).Warn("Error streaming chirps", "error", err)
+	}
+}
+
+// chirpCursor streams the chirps listing row by row. *database.Queries
+// implements it outside the generated Querier interface.
+type chirpCursor interface {
+	EachListChirps(ctx context.Context, arg database.ListChirpsParams, fn func(database.ListChirpsRow) error) error
+	EachListChirpsWithAuthors(ctx context.Context, arg database.ListChirpsWithAuthorsParams, fn func(database.ListChirpsWithAuthorsRow) error) error
+}
+
+var _ chirpCursor = (*database.Queries)(nil)
+
+var errNoChirpCursor = errors.New("database does not support streaming chirps")
+
+// maxChirpsPageLimit caps the limit getChirpsHandler accepts.
+const maxChirpsPageLimit = 100
+
+const (
+	defaultRecentChirpsLimit = 20
+	maxRecentChirpsLimit     = 100
+)
+
+// getRecentChirpsHandler returns the newest chirps first. limit defaults to
+// defaultRecentChirpsLimit and is capped at maxRecentChirpsLimit.
+func (cfg *apiConfig) getRecentChirpsHandler(w http.ResponseWriter, r *http.Request) {
+	limit := defaultRecentChirpsLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		parsed, err := strconv.Atoi(v)
+		if err != nil || parsed < 1 {
+			respondWithError(w, r, http.StatusBadRequest, codeValidationError, "Invalid limit")
+			return
+		}
+		limit = min(parsed, maxRecentChirpsLimit)
+	}
+
+	dbChirps, err := cfg.db.GetRecentChirps(r.Context(), int32(limit))
+	if err != nil {
+		requestLogger(r).Error("Error fetching recent chirps", "error", err)
+		respondWithError(w, r, http.StatusInternalServerError, codeInternal, "Failed to fetch chirps")
+		return
+	}
+
+	chirps := []Chirp{}
+	for _, dbChirp := range dbChirps {
+		chirps = append(chirps, newChirp(dbChirp))
+	}
+
+	if err := cfg.attachLikeCounts(r.Context(), chirps); err != nil {
+		requestLogger(r).Error("Error fetching like counts", "error", err)
+		respondWithError(w, r, http.StatusInternalServerError, codeInternal, "Failed to fetch chirps")
+		return
+	}
 
 	if err := respondWithJSON(w, http.StatusOK, chirps); err != nil {
-		log.Printf("Error responding with JSON: %s", err)
+		requestLogger(r).Error("Error responding with JSON", "error", err)
+		return
+	}
+}
+
+// getRandomChirpHandler returns one live chirp picked at random, optionally
+// only among those by author_id, or 404 if there are none.
+func (cfg *apiConfig) getRandomChirpHandler(w http.ResponseWriter, r *http.Request) {
+	var authorID uuid.NullUUID
+	if v := r.URL.Query().Get("author_id"); v != "" {
+		parsed, err := uuid.Parse(v)
+		if err != nil {
+			respondWithError(w, r, http.StatusBadRequest, codeValidationError, "Invalid author_id")
+			return
+		}
+		authorID = uuid.NullUUID{UUID: parsed, Valid: true}
+	}
+
+	dbChirp, err := cfg.db.GetRandomChirp(r.Context(), authorID)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, r, http.StatusNotFound, codeNotFound, "No chirps found")
+		return
+	}
+	if err != nil {
+		requestLogger(r).Error("Error fetching random chirp", "error", err)
+		respondWithError(w, r, http.StatusInternalServerError, codeInternal, "Failed to fetch chirp")
+		return
+	}
+
+	chirps := []Chirp{newChirp(dbChirp)}
+	if err := cfg.attachLikeCounts(r.Context(), chirps); err != nil {
+		requestLogger(r).Error("Error fetching like counts", "error", err)
+		respondWithError(w, r, http.StatusInternalServerError, codeInternal, "Failed to fetch chirp")
+		return
+	}
+
+	if err := respondWithJSON(w, http.StatusOK, chirps[0]); err != nil {
+		requestLogger(r).Error("Error responding with JSON", "error", err)
 		return
 	}
 }
 
+// getChirpCountHandler returns how many live chirps there are, optionally
+// only those by author_id, so clients can size pagination without listing.
+func (cfg *apiConfig) getChirpCountHandler(w http.ResponseWriter, r *http.Request) {
+	var authorID uuid.NullUUID
+	if v := r.URL.Query().Get("author_id"); v != "" {
+		parsed, err := uuid.Parse(v)
+		if err != nil {
+			respondWithError(w, r, http.StatusBadRequest, codeValidationError, "Invalid author_id")
+			return
+		}
+		authorID = uuid.NullUUID{UUID: parsed, Valid: true}
+	}
+
+	count, err := cfg.db.CountChirpsByAuthor(r.Context(), authorID)
+	if err != nil {
+		requestLogger(r).Error("Error counting chirps", "error", err)
+		respondWithError(w, r, http.StatusInternalServerError, codeInternal, "Failed to count chirps")
+		return
+	}
+
+	if err := respondWithJSON(w, http.StatusOK, struct {
+		Count int64 `json:"count"`
+	}{Count: count}); err != nil {
+		requestLogger(r).Error("Error responding with JSON", "error", err)
+		return
+	}
+}
+
+// chirpETag identifies a version of a chirp's representation. Likes and
+// replies don't touch updated_at, so their counts are part of it too, as is
+// the raw body admins can ask for.
+func chirpETag(chirp Chirp) string {
+	var replyCount int64
+	if chirp.ReplyCount != nil {
+		replyCount = *chirp.ReplyCount
+	}
+	sum := sha256.Sum256(fmt.Appendf(nil, "%s|%d|%d|%d|%s", chirp.ID, chirp.UpdatedAt.UnixNano(), chirp.LikeCount, replyCount, chirp.RawBody))
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// getChirpHandler returns a single chirp with an ETag, answering 304 Not
+// Modified when If-None-Match already has the current version.
 func (cfg *apiConfig) getChirpHandler(w http.ResponseWriter, r *http.Request) {
 	chirpID := r.PathValue("chirpID")
 
 	parsedChirpID, err := uuid.Parse(chirpID)
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid user_id")
+		respondWithError(w, r, http.StatusBadRequest, codeValidationError, "Invalid user_id")
 		return
 	}
 
-	dbChirp, err := cfg.db.GetChirpByID(r.Context(), parsedChirpID)
+	raw := false
+	if v := r.URL.Query().Get("raw"); v != "" {
+		raw, err = strconv.ParseBool(v)
+		if err != nil {
+			respondWithError(w, r, http.StatusBadRequest, codeValidationError, "Invalid raw")
+			return
+		}
+	}
+	if raw {
+		userID, claims, ok := cfg.authenticate(w, r)
+		if !ok || !cfg.authorizeAdmin(w, r, userID, claims) {
+			return
+		}
+	}
+
+	row, err := cfg.db.GetChirpWithCounts(r.Context(), parsedChirpID)
+	if errors.Is(err, sql.ErrNoRows) {
+		// Soft-deleted chirps are simply not found; only purged chirps, and
+		// those hard-deleted before deleted_at existed, have a tombstone.
+		tombstoned, err := cfg.db.IsChirpTombstoned(r.Context(), parsedChirpID)
+		if err != nil {
+			requestLogger(r).Error("Error checking chirp tombstone", "error", err)
+		}
+		if tombstoned {
+			respondWithError(w, r, http.StatusGone, codeGone, "Chirp has been deleted")
+			return
+		}
+		respondWithError(w, r, http.StatusNotFound, codeNotFound, "Chirp not found")
+		return
+	}
 	if err != nil {
-		log.Printf("Error fetching chirp: %s", err)
-		respondWithError(w, http.StatusNotFound, "Failed to fetch chirp")
+		requestLogger(r).Error("Error fetching chirp", "error", err)
+		respondWithError(w, r, http.StatusInternalServerError, codeInternal, "Failed to fetch chirp")
 		return
 	}
 
-	chirp := Chirp{
-		ID:        dbChirp.ID,
-		CreatedAt: dbChirp.CreatedAt,
-		UpdatedAt: dbChirp.UpdatedAt,
-		Body:      dbChirp.Body,
-		UserID:    dbChirp.UserID,
+	chirp := newChirp(row.Chirp)
+	chirp.LikeCount = row.LikeCount
+	chirp.ReplyCount = &row.ReplyCount
+	if raw {
+		chirp.RawBody = row.Chirp.RawBody.String
+	}
+
+	etag := chirpETag(chirp)
+	w.Header().Set("ETag", etag)
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
 	}
 
 	if err := respondWithJSON(w, http.StatusOK, chirp); err != nil {
-		log.Printf("Error responding with JSON: %s", err)
+		requestLogger(r).Error("Error responding with JSON", "error", err)
 		return
 	}
 }
 
+// maxAccessTokenLifetime is both the default and the upper bound for the
+// lifetime of access tokens issued at login.
+const maxAccessTokenLifetime = time.Hour
+
+// defaultRefreshTokenTTL is how long a refresh token stays usable unless
+// REFRESH_TOKEN_TTL overrides it. Rotation issues each replacement with a
+// fresh lifetime.
+const defaultRefreshTokenTTL = 60 * 24 * time.Hour
+
+// defaultRefreshTokenGrace is the refresh token reuse grace window unless
+// REFRESH_TOKEN_GRACE overrides it.
+const defaultRefreshTokenGrace = 10 * time.Second
+
+func (cfg *apiConfig) refreshTokenLifetime() time.Duration {
+	if cfg.refreshTokenTTL <= 0 {
+		return defaultRefreshTokenTTL
+	}
+	return cfg.refreshTokenTTL
+}
+
+// accessTokenLifetime converts the optional expires_in_seconds login field
+// into a token lifetime, falling back to maxAccessTokenLifetime when the value
+// is absent or out of range.
+func accessTokenLifetime(expiresInSeconds int) time.Duration {
+	lifetime := time.Duration(expiresInSeconds) * time.Second
+	if lifetime <= 0 || lifetime > maxAccessTokenLifetime {
+		return maxAccessTokenLifetime
+	}
+	return lifetime
+}
+
+// defaultAuthCookieName names the access token cookie unless
+// AUTH_COOKIE_NAME overrides it.
+const defaultAuthCookieName = "chirpy_access_token"
+
+// loginHandler exchanges an email and password for an access token and a
+// refresh token. With ?cookie=true the access token is also set as an
+// HttpOnly cookie, which authMiddleware accepts in place of the
+// Authorization header, so browser apps needn't keep it in script-readable
+// storage.
 func (cfg *apiConfig) loginHandler(w http.ResponseWriter, r *http.Request) {
 	var params struct {
-		Password string `json:"password"`
-		Email    string `json:"email"`
+		Password         string `json:"password"`
+		Email            string `json:"email"`
+		ExpiresInSeconds int    `json:"expires_in_seconds"`
 	}
 
-	defer r.Body.Close()
-	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
-		log.Printf("Error decoding parameters: %s", err)
-		respondWithError(w, http.StatusInternalServerError, "Invalid request body")
+	setCookie := false
+	if v := r.URL.Query().Get("cookie"); v != "" {
+		var err error
+		setCookie, err = strconv.ParseBool(v)
+		if err != nil {
+			respondWithError(w, r, http.StatusBadRequest, codeValidationError, "Invalid cookie")
+			return
+		}
+	}
+
+	if err := cfg.decodeJSON(w, r, &params); err != nil {
+		requestLogger(r).Warn("Error decoding parameters", "error", err)
+		respondWithError(w, r, http.StatusBadRequest, codeBadRequest, err.Error())
 		return
 	}
 
-	dbUser, err := cfg.db.GetUserByEmail(r.Context(), params.Email)
+	dbUser, err := cfg.db.GetUserByEmail(r.Context(), normalizeEmail(params.Email))
 	if err != nil {
-		log.Printf("Error fetching user: %s", err)
-		respondWithError(w, http.StatusUnauthorized, "Incorrect email or password")
+		requestLogger(r).Warn("Error fetching user", "error", err)
+		respondWithError(w, r, http.StatusUnauthorized, codeUnauthorized, "Incorrect email or password")
 		return
 	}
 
 	if err := auth.CheckPasswordHash(dbUser.HashedPassword, params.Password); err != nil {
-		log.Printf("Error checking password: %s", err)
-		respondWithError(w, http.StatusUnauthorized, "Incorrect email or password")
+		if errors.Is(err, auth.ErrPasswordMismatch) {
+			requestLogger(r).Warn("Incorrect password", "user_id", dbUser.ID)
+		} else {
+			requestLogger(r).Error("Error checking password", "user_id", dbUser.ID, "error", err)
+		}
+		respondWithError(w, r, http.StatusUnauthorized, codeUnauthorized, "Incorrect email or password")
 		return
 	}
 
-	jwtToken, err := auth.MakeJWT(dbUser.ID, cfg.jwtSecret, time.Hour)
+	lifetime := accessTokenLifetime(params.ExpiresInSeconds)
+	jwtToken, err := cfg.jwtKeys.MakeJWTWithClaims(dbUser.ID, jwtRole(dbUser), dbUser.Email, lifetime)
 	if err != nil {
-		log.Printf("Error creating JWT: %s", err)
-		respondWithError(w, http.StatusInternalServerError, "Failed to create jwt token")
+		requestLogger(r).Error("Error creating JWT", "user_id", dbUser.ID, "error", err)
+		respondWithError(w, r, http.StatusInternalServerError, codeInternal, "Failed to create jwt token")
 		return
 	}
 
 	refreshToken, err := auth.MakeRefreshToken()
 	if err != nil {
-		log.Printf("Error creating refresh token: %s", err)
-		respondWithError(w, http.StatusInternalServerError, "Failed to create refresh token")
+		requestLogger(r).Error("Error creating refresh token", "user_id", dbUser.ID, "error", err)
+		respondWithError(w, r, http.StatusInternalServerError, codeInternal, "Failed to create refresh token")
 		return
 	}
 
 	_, err = cfg.db.CreateRefreshToken(r.Context(), database.CreateRefreshTokenParams{
 		UserID:    dbUser.ID,
 		Token:     refreshToken,
-		ExpiresAt: time.Now().Add(60 * 24 * time.Hour),
+		ExpiresAt: time.Now().Add(cfg.refreshTokenLifetime()),
+		FamilyID:  uuid.New(),
 	})
 	if err != nil {
-		log.Printf("Error creating refresh token in database: %s", err)
-		respondWithError(w, http.StatusInternalServerError, "Failed to create refresh token in database")
+		requestLogger(r).Error("Error creating refresh token in database", "user_id", dbUser.ID, "error", err)
+		respondWithError(w, r, http.StatusInternalServerError, codeInternal, "Failed to create refresh token in database")
 		return
 	}
 
-	user := User{
-		ID:           dbUser.ID,
-		CreatedAt:    dbUser.CreatedAt,
-		UpdatedAt:    dbUser.UpdatedAt,
-		Email:        dbUser.Email,
-		Token:        jwtToken,
-		RefreshToken: refreshToken,
-		IsChirpyRed:  dbUser.IsChirpyRed,
+	// Login tracking is only for reporting, so it never blocks a login. The
+	// response keeps the previous last_login_at, fetched above.
+	if err := cfg.db.UpdateLastLogin(r.Context(), dbUser.ID); err != nil {
+		requestLogger(r).Warn("Error updating last login", "user_id", dbUser.ID, "error", err)
 	}
 
+	if setCookie {
+		http.SetCookie(w, &http.Cookie{
+			Name:     cfg.accessTokenCookieName(),
+			Value:    jwtToken,
+			Path:     "/",
+			MaxAge:   int(lifetime.Seconds()),
+			Secure:   true,
+			HttpOnly: true,
+			SameSite: http.SameSiteStrictMode,
+		})
+	}
+
+	user := newUser(dbUser)
+	user.Token = jwtToken
+	user.RefreshToken = refreshToken
+
 	if err := respondWithJSON(w, http.StatusOK, user); err != nil {
-		log.Printf("Error responding with JSON: %s", err)
+		requestLogger(r).Error("Error responding with JSON", "error", err)
 		return
 	}
 }
 
+// refreshTokenHandler exchanges a refresh token for a new access token and a
+// new refresh token, revoking the one presented. Presenting a token that was
+// already rotated means it has been used twice, so every token in its family
+// is revoked and the client must log in again, unless it was rotated within
+// the refreshTokenGrace window; see refreshTokenSuccessor. The bearer token
+// is the refresh token rather than a JWT, so unlike other authenticated
+// routes this one isn't behind authMiddleware.
 func (cfg *apiConfig) refreshTokenHandler(w http.ResponseWriter, r *http.Request) {
 	token, err := auth.GetBearerToken(r.Header)
 	if err != nil {
-		log.Printf("Error getting bearer token: %s", err)
-		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		requestLogger(r).Warn("Error getting bearer token", "error", err)
+		respondWithError(w, r, http.StatusUnauthorized, codeUnauthorized, "Unauthorized")
 		return
 	}
 
-	dbToken, err := cfg.db.GetRefreshTokenByToken(r.Context(), token)
+	includeUser := false
+	if v := r.URL.Query().Get("include_user"); v != "" {
+		includeUser, err = strconv.ParseBool(v)
+		if err != nil {
+			respondWithError(w, r, http.StatusBadRequest, codeValidationError, "Invalid include_user")
+			return
+		}
+	}
+
+	// The user is fetched alongside the token because the new access token
+	// carries their current role and email. A token whose user is gone is
+	// simply not found.
+	row, err := cfg.db.GetRefreshTokenWithUser(r.Context(), token)
+	if errors.Is(err, sql.ErrNoRows) {
+		requestLogger(r).Warn("Refresh token not found")
+		respondWithError(w, r, http.StatusUnauthorized, codeInvalidToken, "Invalid refresh token")
+		return
+	}
 	if err != nil {
-		log.Printf("Error fetching refresh token: %s", err)
-		respondWithError(w, http.StatusUnauthorized, "Invalid refresh token")
+		requestLogger(r).Error("Error fetching refresh token", "error", err)
+		respondWithError(w, r, http.StatusInternalServerError, codeInternal, "Failed to refresh token")
 		return
 	}
+	dbToken, dbUser := row.RefreshToken, row.User
 
 	if dbToken.ExpiresAt.Before(time.Now()) {
-		log.Printf("Refresh token expired: %s", dbToken.Token)
-		respondWithError(w, http.StatusUnauthorized, "Refresh token expired")
+		requestLogger(r).Warn("Refresh token expired", "user_id", dbToken.UserID)
+		respondWithError(w, r, http.StatusUnauthorized, codeTokenExpired, "Refresh token expired")
 		return
 	}
 
+	var newToken string
 	if dbToken.RevokedAt.Valid {
-		log.Printf("Refresh token revoked: %s", dbToken.Token)
-		respondWithError(w, http.StatusUnauthorized, "Refresh token revoked")
-		return
+		newToken = cfg.refreshTokenSuccessor(r, dbToken)
+		if newToken == "" {
+			respondWithError(w, r, http.StatusUnauthorized, codeInvalidToken, "Refresh token revoked")
+			return
+		}
+	} else {
+		newToken, err = auth.MakeRefreshToken()
+		if err != nil {
+			requestLogger(r).Error("Error creating refresh token", "user_id", dbToken.UserID, "error", err)
+			respondWithError(w, r, http.StatusInternalServerError, codeInternal, "Failed to create refresh token")
+			return
+		}
+
+		// Revoking the old token and inserting its replacement is one
+		// statement, so neither can happen without the other.
+		rows, err := cfg.db.RotateRefreshToken(r.Context(), database.RotateRefreshTokenParams{
+			NewToken:  newToken,
+			Token:     token,
+			ExpiresAt: time.Now().Add(cfg.refreshTokenLifetime()),
+		})
+		if err != nil {
+			requestLogger(r).Error("Error rotating refresh token", "user_id", dbToken.UserID, "error", err)
+			respondWithError(w, r, http.StatusInternalServerError, codeInternal, "Failed to rotate refresh token")
+			return
+		}
+		if rows == 0 {
+			// A concurrent request rotated or revoked the token after it
+			// was read. Within the grace window that's a client refreshing
+			// twice at once, which gets the other request's token.
+			current, err := cfg.db.GetRefreshTokenByToken(r.Context(), token)
+			if err != nil {
+				requestLogger(r).Error("Error fetching refresh token", "user_id", dbToken.UserID, "error", err)
+				respondWithError(w, r, http.StatusInternalServerError, codeInternal, "Failed to rotate refresh token")
+				return
+			}
+			newToken = cfg.refreshTokenSuccessor(r, current)
+			if newToken == "" {
+				respondWithError(w, r, http.StatusUnauthorized, codeInvalidToken, "Refresh token revoked")
+				return
+			}
+		}
 	}
 
-	jwtToken, err := auth.MakeJWT(dbToken.UserID, cfg.jwtSecret, time.Hour)
+	jwtToken, err := cfg.jwtKeys.MakeJWTWithClaims(dbUser.ID, jwtRole(dbUser), dbUser.Email, time.Hour)
 	if err != nil {
-		log.Printf("Error creating JWT: %s", err)
-		respondWithError(w, http.StatusInternalServerError, "Failed to create jwt token")
+		requestLogger(r).Error("Error creating JWT", "user_id", dbToken.UserID, "error", err)
+		respondWithError(w, r, http.StatusInternalServerError, codeInternal, "Failed to create jwt token")
 		return
 	}
 
 	var payload struct {
-		Token string `json:"token"`
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
+		User         *User  `json:"user,omitempty"`
 	}
 	payload.Token = jwtToken
+	payload.RefreshToken = newToken
+	if includeUser {
+		user := newUser(dbUser)
+		payload.User = &user
+	}
 	if err := respondWithJSON(w, http.StatusOK, payload); err != nil {
-		log.Printf("Error responding with JSON: %s", err)
+		requestLogger(r).Error("Error responding with JSON", "error", err)
 		return
 	}
 }
 
+// refreshTokenSuccessor decides what presenting the revoked dbToken gets.
+// A token rotated within the last refreshTokenGrace answers with the token
+// that replaced it, as long as that one is still usable, so a client whose
+// refreshes raced isn't logged out. revoked_at is when a replaced token was
+// rotated, since later revocations leave it alone; it comes from the
+// database's clock, so GetRefreshTokenSuccessor checks the window there.
+// Any other rotated token has been reused, and its family is revoked. It
+// returns "" when the request must be rejected.
+func (cfg *apiConfig) refreshTokenSuccessor(r *http.Request, dbToken database.RefreshToken) string {
+	if !dbToken.ReplacedBy.Valid {
+		requestLogger(r).Warn("Refresh token revoked", "user_id", dbToken.UserID)
+		return ""
+	}
+	if cfg.refreshTokenGrace > 0 {
+		successor, err := cfg.db.GetRefreshTokenSuccessor(r.Context(), database.GetRefreshTokenSuccessorParams{
+			Token:        dbToken.Token,
+			GraceSeconds: cfg.refreshTokenGrace.Seconds(),
+		})
+		if err == nil {
+			requestLogger(r).Info("Rotated refresh token reused within grace window", "user_id", dbToken.UserID)
+			return successor.Token
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			requestLogger(r).Error("Error fetching refresh token", "user_id", dbToken.UserID, "error", err)
+		}
+	}
+	cfg.revokeRefreshTokenFamily(r, dbToken)
+	return ""
+}
+
+// revokeRefreshTokenFamily handles reuse of a rotated refresh token by
+// revoking every token issued from the same login.
+func (cfg *apiConfig) revokeRefreshTokenFamily(r *http.Request, dbToken database.RefreshToken) {
+	requestLogger(r).Warn("Rotated refresh token reused, revoking token family",
+		"user_id", dbToken.UserID, "family_id", dbToken.FamilyID)
+	if err := cfg.db.RevokeRefreshTokenFamily(r.Context(), dbToken.FamilyID); err != nil {
+		requestLogger(r).Error("Error revoking refresh token family", "user_id", dbToken.UserID, "error", err)
+	}
+}
+
+// revokeRefreshTokenHandler revokes the refresh token in the Authorization
+// header. Unknown tokens get a 404; revoking a token twice is a no-op. Like
+// refreshTokenHandler it authenticates with the refresh token itself.
 func (cfg *apiConfig) revokeRefreshTokenHandler(w http.ResponseWriter, r *http.Request) {
 	token, err := auth.GetBearerToken(r.Header)
 	if err != nil {
-		log.Printf("Error getting bearer token: %s", err)
-		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		requestLogger(r).Warn("Error getting bearer token", "error", err)
+		respondWithError(w, r, http.StatusUnauthorized, codeUnauthorized, "Unauthorized")
 		return
 	}
 
-	if err = cfg.db.RevokeRefreshToken(r.Context(), token); err != nil {
-		log.Printf("Error revoking refresh token: %s", err)
-		respondWithError(w, http.StatusInternalServerError, "Failed to revoke refresh token")
+	// Revoking keeps the original revoked_at, so an already-revoked token
+	// still matches a row and the request stays idempotent.
+	rows, err := cfg.db.RevokeRefreshToken(r.Context(), token)
+	if err != nil {
+		requestLogger(r).Error("Error revoking refresh token", "error", err)
+		respondWithError(w, r, http.StatusInternalServerError, codeInternal, "Failed to revoke refresh token")
+		return
+	}
+	if rows == 0 {
+		respondWithError(w, r, http.StatusNotFound, codeNotFound, "Refresh token not found")
 		return
 	}
 
 	w.WriteHeader(http.StatusNoContent)
 }
 
-func (cfg *apiConfig) updateCredentialsHandler(w http.ResponseWriter, r *http.Request) {
-	token, err := auth.GetBearerToken(r.Header)
-	if err != nil {
-		log.Printf("Error getting bearer token: %s", err)
-		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+// logoutAllHandler revokes every active refresh token belonging to the
+// authenticated user, ending all of their sessions.
+func (cfg *apiConfig) logoutAllHandler(w http.ResponseWriter, r *http.Request) {
+	userID := userIDFromContext(r)
+
+	if err := cfg.db.RevokeAllUserRefreshTokens(r.Context(), userID); err != nil {
+		requestLogger(r).Error("Error revoking refresh tokens", "user_id", userID, "error", err)
+		respondWithError(w, r, http.StatusInternalServerError, codeInternal, "Failed to revoke refresh tokens")
 		return
 	}
 
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// updateCredentialsHandler changes the caller's email, password or both.
+// A new email address has to be verified again.
+func (cfg *apiConfig) updateCredentialsHandler(w http.ResponseWriter, r *http.Request) {
+	userID := userIDFromContext(r)
+
 	var params struct {
 		Email    string `json:"email"`
 		Password string `json:"password"`
 	}
-	defer r.Body.Close()
-	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
-		log.Printf("Error decoding parameters: %s", err)
-		respondWithError(w, http.StatusInternalServerError, "Invalid request body")
+	if err := cfg.decodeJSON(w, r, &params); err != nil {
+		requestLogger(r).Warn("Error decoding parameters", "error", err)
+		respondWithError(w, r, http.StatusBadRequest, codeBadRequest, err.Error())
 		return
 	}
 
-	if params.Email == "" || params.Password == "" {
-		respondWithError(w, http.StatusBadRequest, "Email and password are required")
+	if params.Email == "" && params.Password == "" {
+		respondWithValidationError(w, r, "Email or password is required", map[string]string{
+			"email":    "required",
+			"password": "required",
+		})
 		return
 	}
 
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
-	if err != nil {
-		log.Printf("Error validating JWT: %s", err)
-		respondWithError(w, http.StatusUnauthorized, "Invalid token")
+	email, fieldErrors := cfg.credentialFieldErrors(params.Email, params.Password, true)
+	if len(fieldErrors) > 0 {
+		respondWithValidationError(w, r, "Invalid credentials", fieldErrors)
 		return
 	}
 
-	hashedPassword, err := auth.HashPassword(params.Password)
-	if err != nil {
-		log.Printf("Error hashing password: %s", err)
-		respondWithError(w, http.StatusInternalServerError, "Failed to hash password")
-		return
+	// Either field may be omitted; the query keeps the stored value for it.
+	update := database.UpdateUserCredentialsParams{ID: userID}
+
+	if email != "" {
+		update.Email = sql.NullString{String: email, Valid: true}
 	}
 
-	dbUser, err := cfg.db.UpdateUserCredentials(r.Context(), database.UpdateUserCredentialsParams{
-		ID:             userID,
-		Email:          params.Email,
-		HashedPassword: hashedPassword,
-	})
+	if params.Password != "" {
+		hashedPassword, err := cfg.hashPassword(params.Password)
+		if err != nil {
+			requestLogger(r).Error("Error hashing password", "user_id", userID, "error", err)
+			respondWithError(w, r, http.StatusInternalServerError, codeInternal, "Failed to hash password")
+			return
+		}
+		update.HashedPassword = sql.NullString{String: hashedPassword, Valid: true}
+	}
+
+	dbUser, err := cfg.db.UpdateUserCredentials(r.Context(), update)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, r, http.StatusNotFound, codeNotFound, "User not found")
+		return
+	}
 	if err != nil {
-		log.Printf("Error updating user credentials: %s", err)
-		respondWithError(w, http.StatusInternalServerError, "Failed to update user credentials")
+		requestLogger(r).Error("Error updating user credentials", "user_id", userID, "error", err)
+		respondWithError(w, r, http.StatusInternalServerError, codeInternal, "Failed to update user credentials")
 		return
 	}
 
-	user := User{
-		ID:          dbUser.ID,
-		CreatedAt:   dbUser.CreatedAt,
-		UpdatedAt:   dbUser.UpdatedAt,
-		Email:       dbUser.Email,
-		IsChirpyRed: dbUser.IsChirpyRed,
-	}
+	user := newUser(dbUser)
 
 	if err := respondWithJSON(w, http.StatusOK, user); err != nil {
-		log.Printf("Error responding with JSON: %s", err)
+		requestLogger(r).Error("Error responding with JSON", "error", err)
 		return
 	}
 
 }
 
-func (cfg *apiConfig) deleteChirpHandler(w http.ResponseWriter, r *http.Request) {
-	token, err := auth.GetBearerToken(r.Header)
+// deleteUserHandler deletes the authenticated user's account. Their chirps,
+// refresh tokens and other rows go with it via ON DELETE CASCADE.
+func (cfg *apiConfig) deleteUserHandler(w http.ResponseWriter, r *http.Request) {
+	userID := userIDFromContext(r)
+
+	rows, err := cfg.db.DeleteUserByID(r.Context(), userID)
 	if err != nil {
-		log.Printf("Error getting bearer token: %s", err)
-		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		requestLogger(r).Error("Error deleting user", "user_id", userID, "error", err)
+		respondWithError(w, r, http.StatusInternalServerError, codeInternal, "Failed to delete user")
 		return
 	}
-
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
-	if err != nil {
-		log.Printf("Error validating JWT: %s", err)
-		respondWithError(w, http.StatusUnauthorized, "Invalid token")
+	if rows == 0 {
+		respondWithError(w, r, http.StatusNotFound, codeNotFound, "User not found")
 		return
 	}
 
+	requestLogger(r).Info("Deleted user", "user_id", userID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// deleteChirpHandler soft-deletes one of the caller's chirps: the row is kept
+// for moderation history but hidden from every read endpoint. With
+// ?echo=true it responds 200 with the deleted chirp instead of 204.
+func (cfg *apiConfig) deleteChirpHandler(w http.ResponseWriter, r *http.Request) {
+	userID := userIDFromContext(r)
+
 	chirpID := r.PathValue("chirpID")
 	if chirpID == "" {
-		respondWithError(w, http.StatusBadRequest, "Chirp ID is required")
+		respondWithError(w, r, http.StatusBadRequest, codeValidationError, "Chirp ID is required")
 		return
 	}
 
 	parsedChirpID, err := uuid.Parse(chirpID)
 	if err != nil {
-		log.Printf("Error parsing chirp ID: %s", err)
-		respondWithError(w, http.StatusBadRequest, "Invalid chirp ID")
+		requestLogger(r).Warn("Error parsing chirp ID", "error", err)
+		respondWithError(w, r, http.StatusBadRequest, codeValidationError, "Invalid chirp ID")
 		return
 	}
 
+	echo := false
+	if v := r.URL.Query().Get("echo"); v != "" {
+		echo, err = strconv.ParseBool(v)
+		if err != nil {
+			respondWithError(w, r, http.StatusBadRequest, codeValidationError, "Invalid echo")
+			return
+		}
+	}
+
 	dbChirp, err := cfg.db.GetChirpByID(r.Context(), parsedChirpID)
 	if err != nil {
-		log.Printf("Error fetching chirp: %s", err)
-		respondWithError(w, http.StatusNotFound, "Chirp not found")
+		requestLogger(r).Error("Error fetching chirp", "user_id", userID, "error", err)
+		respondWithError(w, r, http.StatusNotFound, codeNotFound, "Chirp not found")
 		return
 	}
 
+	// Admins moderate other users' chirps, so only look the user up when
+	// they aren't the author.
 	if dbChirp.UserID != userID {
-		log.Printf("User %s is not authorized to delete chirp %s", userID, chirpID)
-		respondWithError(w, http.StatusForbidden, "You are not authorized to delete this chirp")
+		dbUser, err := cfg.db.GetUserByID(r.Context(), userID)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			requestLogger(r).Error("Error fetching user", "user_id", userID, "error", err)
+			respondWithError(w, r, http.StatusInternalServerError, codeInternal, "Failed to delete chirp")
+			return
+		}
+		if !dbUser.IsAdmin {
+			requestLogger(r).Warn("User is not authorized to delete chirp", "user_id", userID, "chirp_id", chirpID)
+			respondWithError(w, r, http.StatusForbidden, codeForbidden, "You are not authorized to delete this chirp")
+			return
+		}
+		requestLogger(r).Info("Admin deleting another user's chirp", "user_id", userID, "chirp_id", chirpID, "author_id", dbChirp.UserID)
+	}
+
+	deleted, err := cfg.db.DeleteChirpByID(r.Context(), parsedChirpID)
+	if errors.Is(err, sql.ErrNoRows) {
+		// Deleted by a concurrent request since it was fetched above.
+		respondWithError(w, r, http.StatusNotFound, codeNotFound, "Chirp not found")
+		return
+	}
+	if err != nil {
+		requestLogger(r).Error("Error deleting chirp", "user_id", userID, "error", err)
+		respondWithError(w, r, http.StatusInternalServerError, codeInternal, "Failed to delete chirp")
 		return
 	}
 
-	if err := cfg.db.DeleteChirpByID(r.Context(), parsedChirpID); err != nil {
-		log.Printf("Error deleting chirp: %s", err)
-		respondWithError(w, http.StatusInternalServerError, "Failed to delete chirp")
+	if !echo {
+		w.WriteHeader(http.StatusNoContent)
 		return
 	}
 
-	w.WriteHeader(http.StatusNoContent)
+	chirps := []Chirp{newChirp(deleted)}
+	if err := cfg.attachLikeCounts(r.Context(), chirps); err != nil {
+		requestLogger(r).Error("Error fetching like counts", "error", err)
+	}
+
+	if err := respondWithJSON(w, http.StatusOK, chirps[0]); err != nil {
+		requestLogger(r).Error("Error responding with JSON", "error", err)
+		return
+	}
 }
 
-func (cfg *apiConfig) setChirpyRedHandler(w http.ResponseWriter, r *http.Request) {
-	var params struct {
-		Event string `json:"event"`
-		Data  struct {
-			UserID string `json:"user_id"`
-		} `json:"data"`
+// purgeDeletedChirpsHandler permanently deletes chirps that were soft-deleted
+// more than days days ago, leaving a tombstone for each so fetching one
+// answers 410 Gone, and responds with how many were purged. The deletes and
+// tombstones are one statement, so Postgres applies them in a single
+// transaction.
+func (cfg *apiConfig) purgeDeletedChirpsHandler(w http.ResponseWriter, r *http.Request) {
+	days, err := strconv.Atoi(r.URL.Query().Get("days"))
+	if err != nil || days < 0 {
+		respondWithError(w, r, http.StatusBadRequest, codeValidationError, "days must be a non-negative integer")
+		return
 	}
 
+	purged, err := cfg.db.PurgeDeletedChirps(r.Context(), time.Now().UTC().AddDate(0, 0, -days))
+	if err != nil {
+		requestLogger(r).Error("Error purging deleted chirps", "error", err)
+		respondWithError(w, r, http.StatusInternalServerError, codeInternal, "Failed to purge chirps")
+		return
+	}
+	requestLogger(r).Info("Purged deleted chirps", "user_id", userIDFromContext(r), "days", days, "purged", purged)
+
+	if err := respondWithJSON(w, http.StatusOK, struct {
+		Purged int64 `json:"purged"`
+	}{purged}); err != nil {
+		requestLogger(r).Error("Error responding with JSON", "error", err)
+	}
+}
+
+func (cfg *apiConfig) setChirpyRedHandler(w http.ResponseWriter, r *http.Request) {
+	var params polkaEvent
+
 	apiKey, err := auth.GetAPIKey(r.Header)
 	if err != nil {
-		log.Printf("Error getting API key: %s", err)
-		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		requestLogger(r).Warn("Error getting API key", "error", err)
+		respondWithError(w, r, http.StatusUnauthorized, codeUnauthorized, "Unauthorized")
 		return
 	}
-	if apiKey != cfg.polkaKey {
-		log.Printf("Invalid API key: %s", apiKey)
-		respondWithError(w, http.StatusUnauthorized, "Forbidden")
+	// Compared in constant time so response timing doesn't leak how much
+	// of a guessed key was right.
+	if subtle.ConstantTimeCompare([]byte(apiKey), []byte(cfg.polkaKey)) != 1 {
+		requestLogger(r).Warn("Invalid API key")
+		respondWithError(w, r, http.StatusUnauthorized, codeUnauthorized, "Forbidden")
 		return
 	}
 
+	// Polka's payloads may grow new fields, so unlike decodeJSON this only
+	// caps the body size and doesn't reject unknown fields.
+	r.Body = http.MaxBytesReader(w, r.Body, cfg.bodyLimit())
 	defer r.Body.Close()
-	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
-		log.Printf("Error decoding parameters: %s", err)
-		respondWithError(w, http.StatusInternalServerError, "Invalid request body")
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		requestLogger(r).Warn("Error reading body", "error", err)
+		respondWithError(w, r, http.StatusBadRequest, codeBadRequest, "Failed to read request body")
+		return
+	}
+
+	if cfg.verifyPolkaSignature {
+		if err := auth.VerifyWebhookSignature(body, cfg.polkaKey, r.Header.Get("X-Polka-Signature")); err != nil {
+			requestLogger(r).Warn("Invalid webhook signature", "error", err)
+			respondWithError(w, r, http.StatusUnauthorized, codeUnauthorized, "Invalid signature")
+			return
+		}
+	}
+
+	if err := checkJSONShape(body); err != nil {
+		requestLogger(r).Warn("Rejecting webhook body", "error", err)
+		respondWithError(w, r, http.StatusBadRequest, codeBadRequest, err.Error())
+		return
+	}
+	if err := json.NewDecoder(bytes.NewReader(body)).Decode(&params); err != nil {
+		requestLogger(r).Warn("Error decoding parameters", "error", err)
+		respondWithError(w, r, http.StatusBadRequest, codeBadRequest, "Malformed JSON body")
 		return
 	}
 
+	// Polka retries anything that isn't a 2xx, so events we don't handle are
+	// acknowledged without validating their payload.
 	if params.Event != "user.upgraded" {
-		log.Printf("Invalid event: %s", params.Event)
-		respondWithError(w, http.StatusNoContent, "Invalid event")
+		requestLogger(r).Info("Ignoring event", "event", params.Event)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if params.Data.UserID == "" {
+		respondWithValidationError(w, r, "Invalid webhook payload", map[string]string{
+			"data.user_id": "required",
+		})
+		return
+	}
+
+	if _, err := uuid.Parse(params.Data.UserID); err != nil {
+		requestLogger(r).Warn("Error parsing user ID", "error", err)
+		respondWithValidationError(w, r, "Invalid webhook payload", map[string]string{
+			"data.user_id": "must be a valid UUID",
+		})
+		return
+	}
+
+	// The event is stored before anything else touches the database, so a
+	// redelivery is recognised by its ID and a failure, including a lookup
+	// of the user, is retried in the background rather than lost. Handled
+	// events and duplicates get 200. A first attempt that fails still
+	// reports 404 for an unknown user and 500 otherwise, so Polka can see
+	// it, but the stored event keeps being retried either way.
+	event := database.WebhookEvent{
+		ID:        params.ID,
+		EventType: params.Event,
+		Payload:   string(body),
+	}
+	if event.ID == "" {
+		sum := sha256.Sum256(body)
+		event.ID = "sha256:" + hex.EncodeToString(sum[:])
+	}
+
+	inserted, err := cfg.db.CreateWebhookEvent(r.Context(), database.CreateWebhookEventParams{
+		ID:        event.ID,
+		EventType: event.EventType,
+		Payload:   event.Payload,
+	})
+	if err != nil {
+		requestLogger(r).Error("Error storing webhook event", "event_id", event.ID, "error", err)
+		respondWithError(w, r, http.StatusInternalServerError, codeInternal, "Failed to store webhook event")
 		return
 	}
+	if inserted == 0 {
+		requestLogger(r).Info("Ignoring duplicate webhook event", "event_id", event.ID)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if err := cfg.processWebhookEvent(r.Context(), event); errors.Is(err, sql.ErrNoRows) {
+		requestLogger(r).Warn("Webhook for unknown user, will retry", "event_id", event.ID, "error", err)
+		respondWithError(w, r, http.StatusNotFound, codeNotFound, "User not found")
+		return
+	} else if err != nil {
+		requestLogger(r).Error("Error processing webhook event, will retry", "event_id", event.ID, "error", err)
+		respondWithError(w, r, http.StatusInternalServerError, codeInternal, "Failed to process webhook event")
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (cfg *apiConfig) adminSetChirpyRedHandler(w http.ResponseWriter, r *http.Request) {
+	cfg.adminSetChirpyRed(w, r, true)
+}
 
-	userID, err := uuid.Parse(params.Data.UserID)
+func (cfg *apiConfig) adminUnsetChirpyRedHandler(w http.ResponseWriter, r *http.Request) {
+	cfg.adminSetChirpyRed(w, r, false)
+}
+
+// adminSetChirpyRed upgrades or downgrades the user in the path without going
+// through Polka, for support to settle billing disputes. Both directions are
+// idempotent.
+func (cfg *apiConfig) adminSetChirpyRed(w http.ResponseWriter, r *http.Request, chirpyRed bool) {
+	userID, err := uuid.Parse(r.PathValue("userID"))
 	if err != nil {
-		log.Printf("Error parsing user ID: %s", err)
-		respondWithError(w, http.StatusBadRequest, "Invalid user ID")
+		respondWithError(w, r, http.StatusBadRequest, codeValidationError, "Invalid user ID")
+		return
+	}
+
+	if _, err := cfg.db.GetUserByID(r.Context(), userID); errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, r, http.StatusNotFound, codeNotFound, "User not found")
+		return
+	} else if err != nil {
+		requestLogger(r).Error("Error fetching user", "user_id", userID, "error", err)
+		respondWithError(w, r, http.StatusInternalServerError, codeInternal, "Failed to update Chirpy Red")
 		return
 	}
 
-	if err := cfg.db.SetChirpyRedByID(r.Context(), userID); err != nil {
-		log.Printf("Error setting Chirpy Red for user %s: %s", params.Data.UserID, err)
-		respondWithError(w, http.StatusNotFound, "Failed to set Chirpy Red")
+	if chirpyRed {
+		err = cfg.db.SetChirpyRedByID(r.Context(), userID)
+	} else {
+		err = cfg.db.UnsetChirpyRedByID(r.Context(), userID)
+	}
+	if err != nil {
+		requestLogger(r).Error("Error updating Chirpy Red", "user_id", userID, "chirpy_red", chirpyRed, "error", err)
+		respondWithError(w, r, http.StatusInternalServerError, codeInternal, "Failed to update Chirpy Red")
 		return
 	}
 
+	requestLogger(r).Info("Admin updated Chirpy Red", "admin_id", userIDFromContext(r), "user_id", userID, "chirpy_red", chirpyRed)
 	w.WriteHeader(http.StatusNoContent)
-
 }
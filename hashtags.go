@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/WOsaka/chirpy-server/internal/database"
+)
+
+const (
+	trendingWindow       = 24 * time.Hour
+	defaultTrendingLimit = 10
+	maxTrendingLimit     = 50
+)
+
+// hashtagPattern matches a hashtag: a '#' that doesn't follow a letter,
+// digit or underscore, followed by one or more letters or digits. The tag
+// ends at the first character that isn't a letter or digit, so "#go-lang"
+// yields "go".
+var hashtagPattern = regexp.MustCompile(`(?:^|[^\p{L}\p{N}_])#([\p{L}\p{N}]+)`)
+
+// extractHashtags returns the distinct hashtags in body, lowercased and
+// without the leading '#', in order of first appearance.
+func extractHashtags(body string) []string {
+	seen := map[string]bool{}
+	var hashtags []string
+	for _, match := range hashtagPattern.FindAllStringSubmatch(body, -1) {
+		tag := strings.ToLower(match[1])
+		if seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		hashtags = append(hashtags, tag)
+	}
+	return hashtags
+}
+
+type trendingHashtag struct {
+	Hashtag string `json:"hashtag"`
+	Count   int64  `json:"count"`
+}
+
+// getTrendingHashtagsHandler returns the most used hashtags over the last
+// trendingWindow. limit defaults to defaultTrendingLimit and is capped at
+// maxTrendingLimit.
+func (cfg *apiConfig) getTrendingHashtagsHandler(w http.ResponseWriter, r *http.Request) {
+	limit := defaultTrendingLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		parsed, err := strconv.Atoi(v)
+		if err != nil || parsed < 1 {
+			respondWithError(w, r, http.StatusBadRequest, codeValidationError, "Invalid limit")
+			return
+		}
+		limit = min(parsed, maxTrendingLimit)
+	}
+
+	rows, err := cfg.db.GetTrendingHashtags(r.Context(), database.GetTrendingHashtagsParams{
+		Since: time.Now().Add(-trendingWindow),
+		Limit: int32(limit),
+	})
+	if err != nil {
+		requestLogger(r).Error("Error fetching trending hashtags", "error", err)
+		respondWithError(w, r, http.StatusInternalServerError, codeInternal, "Failed to fetch trending hashtags")
+		return
+	}
+
+	trending := []trendingHashtag{}
+	for _, row := range rows {
+		trending = append(trending, trendingHashtag{Hashtag: row.Hashtag, Count: row.Count})
+	}
+
+	if err := respondWithJSON(w, http.StatusOK, trending); err != nil {
+		requestLogger(r).Error("Error responding with JSON", "error", err)
+		return
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/WOsaka/chirpy-server/internal/auth"
+	"github.com/WOsaka/chirpy-server/internal/database"
+	"github.com/google/uuid"
+)
+
+func (db *chirpsDB) AddChirpHashtag(ctx context.Context, arg database.AddChirpHashtagParams) error {
+	if db.hasHashtag(arg.ChirpID, arg.Hashtag) {
+		return nil
+	}
+	db.hashtags = append(db.hashtags, database.ChirpHashtag{
+		ChirpID:   arg.ChirpID,
+		Hashtag:   arg.Hashtag,
+		CreatedAt: time.Now(),
+	})
+	return nil
+}
+
+// GetTrendingHashtags mimics the GROUP BY hashtag aggregation over live
+// chirps, ordered by count and then hashtag.
+func (db *chirpsDB) GetTrendingHashtags(ctx context.Context, arg database.GetTrendingHashtagsParams) ([]database.GetTrendingHashtagsRow, error) {
+	counts := map[string]int64{}
+	for _, h := range db.hashtags {
+		if chirp, ok := db.chirps[h.ChirpID]; !ok || chirp.DeletedAt.Valid {
+			continue
+		}
+		if !h.CreatedAt.Before(arg.Since) {
+			counts[h.Hashtag]++
+		}
+	}
+	var rows []database.GetTrendingHashtagsRow
+	for hashtag, count := range counts {
+		rows = append(rows, database.GetTrendingHashtagsRow{Hashtag: hashtag, Count: count})
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].Count != rows[j].Count {
+			return rows[i].Count > rows[j].Count
+		}
+		return rows[i].Hashtag < rows[j].Hashtag
+	})
+	if len(rows) > int(arg.Limit) {
+		rows = rows[:arg.Limit]
+	}
+	return rows, nil
+}
+
+func (db *chirpsDB) hasHashtag(chirpID uuid.UUID, hashtag string) bool {
+	for _, h := range db.hashtags {
+		if h.ChirpID == chirpID && h.Hashtag == hashtag {
+			return true
+		}
+	}
+	return false
+}
+
+func TestExtractHashtags(t *testing.T) {
+	tests := []struct {
+		body     string
+		expected []string
+	}{
+		{"no tags here", nil},
+		{"#go is fun", []string{"go"}},
+		{"learning #Go and #go again", []string{"go"}},
+		{"#go-lang #rust2024!", []string{"go", "rust2024"}},
+		{"email me at a#b or ##", nil},
+		{"(#café) #日本", []string{"café", "日本"}},
+		{"# spaced #_under", nil},
+	}
+
+	for _, test := range tests {
+		result := extractHashtags(test.body)
+		if !reflect.DeepEqual(result, test.expected) {
+			t.Errorf("extractHashtags(%q) = %q; want %q", test.body, result, test.expected)
+		}
+	}
+}
+
+func getTrending(t *testing.T, cfg *apiConfig, query string) (int, []trendingHashtag) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/api/trending"+query, nil)
+	rec := httptest.NewRecorder()
+	cfg.getTrendingHashtagsHandler(rec, req)
+	var trending []trendingHashtag
+	if rec.Code == http.StatusOK {
+		if err := json.NewDecoder(rec.Body).Decode(&trending); err != nil {
+			t.Fatalf("decoding response: %v", err)
+		}
+	}
+	return rec.Code, trending
+}
+
+func TestTrendingHashtags(t *testing.T) {
+	db := &chirpsDB{chirps: map[uuid.UUID]database.Chirp{}}
+	cfg := &apiConfig{db: db, jwtKeys: auth.NewHS256Keys("secret"), maxChirpLength: 140}
+	userID := uuid.New()
+
+	for _, body := range []string{
+		`{"body": "#go #sql"}`,
+		`{"body": "more #Go"}`,
+		`{"body": "#rust and #go"}`,
+		`{"body": "#sql again"}`,
+	} {
+		if rec := postChirp(t, cfg, userID, body); rec.Code != http.StatusCreated {
+			t.Fatalf("creating chirp %s: got status %d", body, rec.Code)
+		}
+	}
+	// Hashtags older than the trending window don't count.
+	for range 5 {
+		db.hashtags = append(db.hashtags, database.ChirpHashtag{
+			ChirpID:   uuid.New(),
+			Hashtag:   "stale",
+			CreatedAt: time.Now().Add(-2 * trendingWindow),
+		})
+	}
+
+	code, trending := getTrending(t, cfg, "")
+	if code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", code, http.StatusOK)
+	}
+	expected := []trendingHashtag{{"go", 3}, {"sql", 2}, {"rust", 1}}
+	if !reflect.DeepEqual(trending, expected) {
+		t.Errorf("trending = %v; want %v", trending, expected)
+	}
+
+	if _, trending := getTrending(t, cfg, "?limit=1"); !reflect.DeepEqual(trending, expected[:1]) {
+		t.Errorf("trending with limit=1 = %v; want %v", trending, expected[:1])
+	}
+
+	if code, _ := getTrending(t, cfg, "?limit=0"); code != http.StatusBadRequest {
+		t.Errorf("limit=0: got status %d, want %d", code, http.StatusBadRequest)
+	}
+
+	chirps := listChirps(t, cfg, "hashtag=%23SQL")
+	if len(chirps) != 2 {
+		t.Errorf("hashtag filter returned %d chirps; want 2", len(chirps))
+	}
+
+	// Soft-deleted chirps' hashtags stop counting.
+	for id, chirp := range db.chirps {
+		if chirp.Body == "#rust and #go" {
+			chirp.DeletedAt = sql.NullTime{Time: time.Now(), Valid: true}
+			db.chirps[id] = chirp
+		}
+	}
+	_, trending = getTrending(t, cfg, "")
+	if expected := []trendingHashtag{{"go", 2}, {"sql", 2}}; !reflect.DeepEqual(trending, expected) {
+		t.Errorf("trending after deleting a chirp = %v; want %v", trending, expected)
+	}
+}
@@ -1,17 +1,274 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"mime"
+	"net"
 	"net/http"
+	"net/mail"
+	"net/netip"
+	"net/url"
+	"os"
+	"runtime/debug"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/WOsaka/chirpy-server/internal/auth"
+	"github.com/WOsaka/chirpy-server/internal/database"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// errorCode is a stable, machine-readable identifier for the kind of error
+// in a response. Clients should branch on it rather than on the message,
+// which is meant for people and may be reworded.
+type errorCode string
+
+const (
+	codeBadRequest         errorCode = "bad_request"
+	codeValidationError    errorCode = "validation_error"
+	codeUnauthorized       errorCode = "unauthorized"
+	codeInvalidToken       errorCode = "invalid_token"
+	codeTokenExpired       errorCode = "token_expired"
+	codeForbidden          errorCode = "forbidden"
+	codeEmailNotVerified   errorCode = "email_not_verified"
+	codeNotFound           errorCode = "not_found"
+	codeConflict           errorCode = "conflict"
+	codeGone               errorCode = "gone"
+	codeRateLimited        errorCode = "rate_limited"
+	codeTimeout            errorCode = "timeout"
+	codeInternal           errorCode = "internal_error"
+	codeServiceUnavailable errorCode = "service_unavailable"
+)
+
+// errorBody is the "error" object shared by every error response.
+type errorBody struct {
+	Message string    `json:"message"`
+	Code    errorCode `json:"code"`
+}
+
+func respondWithError(w http.ResponseWriter, r *http.Request, status int, code errorCode, msg string) error {
+	return respondWithJSON(w, status, struct {
+		Error     errorBody `json:"error"`
+		RequestID string    `json:"request_id,omitempty"`
+	}{
+		Error:     errorBody{Message: msg, Code: code},
+		RequestID: requestIDFromContext(r.Context()),
+	})
+}
+
+// respondWithValidationError responds with 400 and a per-field breakdown of
+// what was wrong with the request, keyed by the JSON path of each field.
+func respondWithValidationError(w http.ResponseWriter, r *http.Request, msg string, fields map[string]string) error {
+	return respondWithJSON(w, http.StatusBadRequest, struct {
+		Error     errorBody         `json:"error"`
+		Fields    map[string]string `json:"fields"`
+		RequestID string            `json:"request_id,omitempty"`
+	}{
+		Error:     errorBody{Message: msg, Code: codeValidationError},
+		Fields:    fields,
+		RequestID: requestIDFromContext(r.Context()),
+	})
+}
+
+// respondWithRateLimit responds with 429, setting Retry-After and repeating
+// the wait in the body so clients can show a countdown without reading
+// headers. retryAfter is rounded up to whole seconds.
+func respondWithRateLimit(w http.ResponseWriter, r *http.Request, msg string, retryAfter time.Duration) error {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	return respondWithJSON(w, http.StatusTooManyRequests, struct {
+		Error             errorBody `json:"error"`
+		RetryAfterSeconds int       `json:"retry_after_seconds"`
+		RequestID         string    `json:"request_id,omitempty"`
+	}{
+		Error:             errorBody{Message: msg, Code: codeRateLimited},
+		RetryAfterSeconds: seconds,
+		RequestID:         requestIDFromContext(r.Context()),
+	})
+}
+
+// defaultMaxBodyBytes caps request bodies when apiConfig.maxBodyBytes is
+// unset.
+const defaultMaxBodyBytes = 1 << 20
+
+// maxJSONDepth and maxJSONArrayLength bound the shape of JSON request
+// bodies. No endpoint needs anywhere near either, and a body within the size
+// limit can otherwise still make decoding pathologically expensive.
+const (
+	maxJSONDepth       = 32
+	maxJSONArrayLength = 1000
 )
 
-func respondWithError(w http.ResponseWriter, code int, msg string) error {
-	return respondWithJSON(w, code, map[string]string{"error": msg})
+// checkJSONShape rejects JSON nested more than maxJSONDepth levels or with
+// an array of more than maxJSONArrayLength elements. It only scans brackets
+// and commas outside strings, leaving syntax errors to the decoder. The
+// returned error's message is safe to show to the client.
+func checkJSONShape(data []byte) error {
+	// elements holds, per open bracket, how many array elements have been
+	// seen so far, or -1 for an object.
+	var elements []int
+	inString, escaped := false, false
+	for _, c := range data {
+		if inString {
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == '"':
+				inString = false
+			}
+			continue
+		}
+		switch c {
+		case '"':
+			inString = true
+		case '{', '[':
+			if len(elements) == maxJSONDepth {
+				return fmt.Errorf("Request body must not be nested more than %d levels deep", maxJSONDepth)
+			}
+			if c == '{' {
+				elements = append(elements, -1)
+			} else {
+				elements = append(elements, 1)
+			}
+		case '}', ']':
+			if len(elements) > 0 {
+				elements = elements[:len(elements)-1]
+			}
+		case ',':
+			if n := len(elements); n > 0 && elements[n-1] >= 0 {
+				elements[n-1]++
+				if elements[n-1] > maxJSONArrayLength {
+					return fmt.Errorf("Request body must not contain arrays longer than %d elements", maxJSONArrayLength)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// decodeJSON decodes the JSON request body into dst. It requires a JSON
+// Content-Type, caps the body at cfg.maxBodyBytes, bounds its nesting and
+// array lengths with checkJSONShape and rejects unknown fields so misspelt
+// field names fail loudly instead of being ignored. The returned error's
+// message is safe to show to the client.
+func (cfg *apiConfig) decodeJSON(w http.ResponseWriter, r *http.Request, dst any) error {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "application/json" {
+		return errors.New("Content-Type must be application/json")
+	}
+
+	maxBytes := cfg.bodyLimit()
+	r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+	defer r.Body.Close()
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return fmt.Errorf("Request body must not be larger than %d bytes", maxBytes)
+		}
+		return errors.New("Failed to read request body")
+	}
+	if err := checkJSONShape(body); err != nil {
+		return err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(dst); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		switch {
+		case strings.HasPrefix(err.Error(), "json: unknown field "):
+			return fmt.Errorf("Request body contains unknown field %s", strings.TrimPrefix(err.Error(), "json: unknown field "))
+		case errors.As(err, &typeErr):
+			return fmt.Errorf("Request body has the wrong type for field %q", typeErr.Field)
+		case errors.Is(err, io.EOF):
+			return errors.New("Request body must not be empty")
+		default:
+			return errors.New("Request body contains malformed JSON")
+		}
+	}
+	if err := decoder.Decode(&struct{}{}); !errors.Is(err, io.EOF) {
+		return errors.New("Request body must contain a single JSON object")
+	}
+	return nil
+}
+
+func (cfg *apiConfig) bodyLimit() int64 {
+	if cfg.maxBodyBytes <= 0 {
+		return defaultMaxBodyBytes
+	}
+	return cfg.maxBodyBytes
+}
+
+// hashPassword hashes with the configured bcrypt cost, or bcrypt's default
+// when none is set.
+func (cfg *apiConfig) hashPassword(password string) (string, error) {
+	if cfg.bcryptCost == 0 {
+		return auth.HashPassword(password)
+	}
+	return auth.HashPasswordWithCost(password, cfg.bcryptCost)
+}
+
+// etagMatches reports whether an If-None-Match header value matches etag,
+// using the weak comparison RFC 9110 specifies for If-None-Match.
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// notModifiedSince reports whether the request's If-Modified-Since header is
+// at or after lastModified. HTTP dates only have whole seconds, so
+// lastModified is compared at that precision.
+func notModifiedSince(r *http.Request, lastModified time.Time) bool {
+	ifModifiedSince, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	return !ifModifiedSince.Before(lastModified.Truncate(time.Second))
+}
+
+// acceptsJSON reports whether the request's Accept header lists
+// application/json. Quality values are ignored.
+func acceptsJSON(r *http.Request) bool {
+	for _, accept := range r.Header.Values("Accept") {
+		for _, mediaRange := range strings.Split(accept, ",") {
+			mediaType, _, err := mime.ParseMediaType(mediaRange)
+			if err == nil && mediaType == "application/json" {
+				return true
+			}
+		}
+	}
+	return false
 }
 
 func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) error {
-	response, err := json.Marshal(payload)
+	var response []byte
+	var err error
+	if wantsPrettyJSON(w) {
+		response, err = json.MarshalIndent(payload, "", "  ")
+	} else {
+		response, err = json.Marshal(payload)
+	}
 	if err != nil {
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return err
@@ -22,6 +279,384 @@ func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) error
 	return nil
 }
 
+// jsonArrayWriter writes a JSON array to w one element at a time, so a
+// listing can be encoded as its rows are read rather than collected first.
+// The status and the opening bracket are only sent with the first element,
+// leaving the caller free to respond with an error until then; after that a
+// failure can only cut the body short. Close writes the closing bracket, or
+// the whole of an empty array.
+type jsonArrayWriter struct {
+	w       http.ResponseWriter
+	code    int
+	pretty  bool
+	buf     bytes.Buffer
+	enc     *json.Encoder
+	started bool
+}
+
+func newJSONArrayWriter(w http.ResponseWriter, code int) *jsonArrayWriter {
+	aw := &jsonArrayWriter{w: w, code: code, pretty: wantsPrettyJSON(w)}
+	aw.enc = json.NewEncoder(&aw.buf)
+	if aw.pretty {
+		aw.enc.SetIndent("  ", "  ")
+	}
+	return aw
+}
+
+// Write encodes v as the next element. An encoding error leaves the
+// response untouched.
+func (aw *jsonArrayWriter) Write(v any) error {
+	aw.buf.Reset()
+	if aw.started {
+		aw.buf.WriteString(",")
+	} else {
+		aw.buf.WriteString("[")
+	}
+	if aw.pretty {
+		aw.buf.WriteString("\n  ")
+	}
+	if err := aw.enc.Encode(v); err != nil {
+		return err
+	}
+	// Encode ends every value with a newline the array doesn't want.
+	aw.buf.Truncate(aw.buf.Len() - 1)
+	aw.start()
+	_, err := aw.w.Write(aw.buf.Bytes())
+	return err
+}
+
+// Started reports whether the status has been sent.
+func (aw *jsonArrayWriter) Started() bool {
+	return aw.started
+}
+
+// Close finishes the array.
+func (aw *jsonArrayWriter) Close() error {
+	end := "]"
+	switch {
+	case !aw.started:
+		end = "[]"
+	case aw.pretty:
+		end = "\n]"
+	}
+	aw.start()
+	_, err := io.WriteString(aw.w, end)
+	return err
+}
+
+func (aw *jsonArrayWriter) start() {
+	if aw.started {
+		return
+	}
+	aw.started = true
+	aw.w.Header().Set("Content-Type", "application/json")
+	aw.w.WriteHeader(aw.code)
+}
+
+// prettyJSONWriter marks a response whose JSON body should be indented; see
+// middlewarePrettyJSON.
+type prettyJSONWriter struct {
+	http.ResponseWriter
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w prettyJSONWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// middlewarePrettyJSON makes respondWithJSON indent its output when the
+// request has ?pretty=true, for reading responses with curl while
+// debugging. It must run inside middlewareTimeout, whose writer hides the
+// ones it wraps.
+func middlewarePrettyJSON(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if pretty, _ := strconv.ParseBool(r.URL.Query().Get("pretty")); pretty {
+			w = prettyJSONWriter{w}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// wantsPrettyJSON reports whether middlewarePrettyJSON marked w, looking
+// through any writers wrapped around it.
+func wantsPrettyJSON(w http.ResponseWriter) bool {
+	for {
+		switch rw := w.(type) {
+		case prettyJSONWriter:
+			return true
+		case interface{ Unwrap() http.ResponseWriter }:
+			w = rw.Unwrap()
+		default:
+			return false
+		}
+	}
+}
+
+// serverHandler wraps mux in the middleware every request passes through,
+// outermost first.
+func serverHandler(mux *http.ServeMux, metrics *httpMetrics, limiter *rateLimiter, requestTimeout time.Duration) http.Handler {
+	return middlewareRequestID(middlewareAccessLog(metrics.middleware(mux, middlewareRecover(middlewareTimeout(requestTimeout, mux, middlewarePrettyJSON(limiter.middleware(mux)))))))
+}
+
+// requestLogger returns the default logger annotated with the request's
+// ID, method and path, so handler logs can be correlated in aggregators.
+func requestLogger(r *http.Request) *slog.Logger {
+	return slog.With(
+		"request_id", requestIDFromContext(r.Context()),
+		"method", r.Method,
+		"path", r.URL.Path,
+	)
+}
+
+type requestIDKey struct{}
+
+func requestIDFromContext(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey{}).(string)
+	return requestID
+}
+
+// middlewareRequestID tags every request with a fresh ID, exposed to clients
+// in the X-Request-Id header and to handlers through the request context.
+func middlewareRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := uuid.New().String()
+		w.Header().Set("X-Request-Id", requestID)
+		ctx := context.WithValue(r.Context(), requestIDKey{}, requestID)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// middlewareAccessLog logs one line per request once next has returned, with
+// the status and body size it wrote. It must run inside middlewareRequestID
+// so the line carries the request ID.
+func middlewareAccessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		requestLogger(r).Info("Request served",
+			"status", rec.status,
+			"bytes", rec.bytes,
+			"duration_ms", float64(time.Since(start).Microseconds())/1000,
+		)
+	})
+}
+
+// middlewareRecover turns a panicking handler into a 500 response instead of
+// a dropped connection. It must run inside middlewareRequestID so the
+// response and log line carry the request ID.
+func middlewareRecover(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			err := recover()
+			if err == nil {
+				return
+			}
+			if err == http.ErrAbortHandler {
+				panic(err)
+			}
+			requestLogger(r).Error("Handler panicked", "error", err, "stack", string(debug.Stack()))
+			respondWithError(w, r, http.StatusInternalServerError, codeInternal, "Internal server error")
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// defaultRequestTimeout bounds how long a handler may run when
+// REQUEST_TIMEOUT is unset.
+const defaultRequestTimeout = 30 * time.Second
+
+// streamingRoutes map the mux patterns of responses written a piece at a
+// time to their deadline, where zero means the request timeout.
+// http.TimeoutHandler would buffer the whole body and can't flush, so
+// middlewareTimeout only puts the deadline on their request context.
+var streamingRoutes = map[string]time.Duration{
+	"GET /api/chirps.csv":  chirpExportTimeout,
+	"GET /api/chirps":      0,
+	"GET /api/chirps/mine": 0,
+}
+
+// middlewareTimeout answers 503 when next takes longer than timeout. The
+// deadline is on the request context, so database calls made with
+// r.Context() are cancelled rather than left running. Routes in
+// streamingRoutes, looked up by mux's matched pattern, only get the
+// deadline. Like middlewareRecover, it must run inside middlewareRequestID.
+func middlewareTimeout(timeout time.Duration, mux *http.ServeMux, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, route := mux.Handler(r)
+		if routeTimeout, ok := streamingRoutes[route]; ok {
+			if routeTimeout == 0 {
+				routeTimeout = timeout
+			}
+			ctx, cancel := context.WithTimeout(r.Context(), routeTimeout)
+			defer cancel()
+			next.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
+
+		body, err := json.Marshal(struct {
+			Error     errorBody `json:"error"`
+			RequestID string    `json:"request_id,omitempty"`
+		}{
+			Error:     errorBody{Message: "Request timed out", Code: codeTimeout},
+			RequestID: requestIDFromContext(r.Context()),
+		})
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		http.TimeoutHandler(next, timeout, string(body)).ServeHTTP(timeoutJSONWriter{w}, r)
+	})
+}
+
+// timeoutJSONWriter labels http.TimeoutHandler's 503 body as JSON. Responses
+// written by the wrapped handler arrive with their own Content-Type.
+type timeoutJSONWriter struct {
+	http.ResponseWriter
+}
+
+func (w timeoutJSONWriter) WriteHeader(code int) {
+	if code == http.StatusServiceUnavailable && w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+type (
+	userIDKey struct{}
+	claimsKey struct{}
+)
+
+// authenticate validates the request's credentials, either a JWT (see
+// authenticateJWT) or an "ApiKey" API key, and returns the user they belong
+// to. If they are missing or invalid it responds with 401 and returns false.
+// A JWT's claims are returned too; they are nil for API keys.
+func (cfg *apiConfig) authenticate(w http.ResponseWriter, r *http.Request) (uuid.UUID, *auth.ChirpyClaims, bool) {
+	if scheme, _, _ := strings.Cut(r.Header.Get("Authorization"), " "); strings.EqualFold(scheme, "ApiKey") {
+		userID, ok := cfg.authenticateAPIKey(w, r)
+		return userID, nil, ok
+	}
+	return cfg.authenticateJWT(w, r)
+}
+
+// authenticateJWT is authenticate restricted to JWTs, which come from a
+// bearer Authorization header or, if there is no such header, the access
+// token cookie set by loginHandler.
+func (cfg *apiConfig) authenticateJWT(w http.ResponseWriter, r *http.Request) (uuid.UUID, *auth.ChirpyClaims, bool) {
+	token, err := auth.GetBearerToken(r.Header)
+	if r.Header.Get("Authorization") == "" {
+		if cookie, cookieErr := r.Cookie(cfg.accessTokenCookieName()); cookieErr == nil && cookie.Value != "" {
+			token, err = cookie.Value, nil
+		}
+	}
+	if err != nil {
+		requestLogger(r).Warn("Error getting bearer token", "error", err)
+		respondWithError(w, r, http.StatusUnauthorized, codeUnauthorized, "Unauthorized")
+		return uuid.Nil, nil, false
+	}
+
+	claims, err := cfg.jwtKeys.ParseJWT(token)
+	if err != nil {
+		requestLogger(r).Warn("Error validating JWT", "error", err)
+		respondWithError(w, r, http.StatusUnauthorized, codeInvalidToken, "Invalid token")
+		return uuid.Nil, nil, false
+	}
+	userID, _ := claims.UserID()
+	return userID, claims, true
+}
+
+func (cfg *apiConfig) accessTokenCookieName() string {
+	if cfg.authCookieName == "" {
+		return defaultAuthCookieName
+	}
+	return cfg.authCookieName
+}
+
+// authorizeAdmin loads userID and reports whether they are an admin,
+// responding with 403 if not and 401 if the user no longer exists. A member
+// role claim in claims is denied without the lookup, but an admin claim is
+// never trusted on its own, so a demoted admin loses access immediately.
+func (cfg *apiConfig) authorizeAdmin(w http.ResponseWriter, r *http.Request, userID uuid.UUID, claims *auth.ChirpyClaims) bool {
+	if claims != nil && claims.Role != "" && claims.Role != auth.RoleAdmin {
+		requestLogger(r).Warn("Non-admin denied admin access", "user_id", userID)
+		respondWithError(w, r, http.StatusForbidden, codeForbidden, "Admin access required")
+		return false
+	}
+
+	dbUser, err := cfg.db.GetUserByID(r.Context(), userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		requestLogger(r).Warn("Token for unknown user", "user_id", userID)
+		respondWithError(w, r, http.StatusUnauthorized, codeInvalidToken, "Invalid token")
+		return false
+	}
+	if err != nil {
+		requestLogger(r).Error("Error fetching user", "user_id", userID, "error", err)
+		respondWithError(w, r, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return false
+	}
+	if !dbUser.IsAdmin {
+		requestLogger(r).Warn("Non-admin denied admin access", "user_id", userID)
+		respondWithError(w, r, http.StatusForbidden, codeForbidden, "Admin access required")
+		return false
+	}
+	return true
+}
+
+// authMiddleware validates the request's bearer JWT or API key and passes
+// the authenticated user's ID, and a JWT's claims, to next through the
+// request context. Requests
+// without valid credentials are rejected with 401 before next runs.
+func (cfg *apiConfig) authMiddleware(next http.HandlerFunc) http.Handler {
+	return withAuthenticatedUser(cfg.authenticate, next)
+}
+
+// jwtAuthMiddleware is authMiddleware without API keys, for routes such as
+// API key management that a leaked key must not reach.
+func (cfg *apiConfig) jwtAuthMiddleware(next http.HandlerFunc) http.Handler {
+	return withAuthenticatedUser(cfg.authenticateJWT, next)
+}
+
+func withAuthenticatedUser(authenticate func(http.ResponseWriter, *http.Request) (uuid.UUID, *auth.ChirpyClaims, bool), next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID, claims, ok := authenticate(w, r)
+		if !ok {
+			return
+		}
+		ctx := context.WithValue(r.Context(), userIDKey{}, userID)
+		if claims != nil {
+			ctx = context.WithValue(ctx, claimsKey{}, claims)
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// adminMiddleware is authMiddleware for admin-only routes: it also rejects
+// the authenticated user with 403 unless they are an admin; see
+// authorizeAdmin.
+func (cfg *apiConfig) adminMiddleware(next http.HandlerFunc) http.Handler {
+	return cfg.authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if !cfg.authorizeAdmin(w, r, userIDFromContext(r), claimsFromContext(r)) {
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// userIDFromContext returns the user ID stored by authMiddleware, or
+// uuid.Nil if the request didn't pass through it.
+func userIDFromContext(r *http.Request) uuid.UUID {
+	userID, _ := r.Context().Value(userIDKey{}).(uuid.UUID)
+	return userID
+}
+
+// claimsFromContext returns the JWT claims stored by authMiddleware, or nil
+// if the request was authenticated with an API key or didn't pass through it.
+func claimsFromContext(r *http.Request) *auth.ChirpyClaims {
+	claims, _ := r.Context().Value(claimsKey{}).(*auth.ChirpyClaims)
+	return claims
+}
+
 func (cfg *apiConfig) middlewareMetricsInc(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		cfg.fileserverHits.Add(1)
@@ -29,16 +664,281 @@ func (cfg *apiConfig) middlewareMetricsInc(next http.Handler) http.Handler {
 	})
 }
 
-func replaceProfane(sentence string) string {
-	wordsToCheck := []string{"Kerfuffle", "Sharbert", "Fornax"}
+// defaultProfaneWords are censored in chirps unless PROFANE_WORDS_MODE
+// replaces them.
+var defaultProfaneWords = []string{"Kerfuffle", "Sharbert", "Fornax"}
+
+// loadProfaneWords builds the censored word list from a comma-separated
+// list and a file with one word per line (commas also accepted), either of
+// which may be empty. mode "merge" (the default) adds them to
+// defaultProfaneWords and "replace" uses them instead.
+func loadProfaneWords(list, path, mode string) ([]string, error) {
+	if mode != "" && mode != "merge" && mode != "replace" {
+		return nil, fmt.Errorf("unknown mode %q, expected merge or replace", mode)
+	}
+
+	words := splitProfaneWords(list)
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		words = append(words, splitProfaneWords(string(data))...)
+	}
+
+	if mode == "replace" {
+		if len(words) == 0 {
+			return nil, errors.New("replace mode needs at least one word")
+		}
+		return words, nil
+	}
+	return append(slices.Clone(defaultProfaneWords), words...), nil
+}
+
+func splitProfaneWords(list string) []string {
+	var words []string
+	for _, word := range strings.FieldsFunc(list, func(r rune) bool {
+		return r == ',' || r == '\n'
+	}) {
+		if word = strings.TrimSpace(word); word != "" {
+			words = append(words, word)
+		}
+	}
+	return words
+}
+
+// defaultProfaneMask replaces censored words unless PROFANE_MASK is set.
+const defaultProfaneMask = "****"
+
+// profaneMaskLength is the PROFANE_MASK value that replaces each censored
+// word with one asterisk per rune instead of a fixed string.
+const profaneMaskLength = "length"
+
+// replaceProfane censors the configured profane words, or
+// defaultProfaneWords when none are set, with the configured mask.
+func (cfg *apiConfig) replaceProfane(sentence string) string {
+	words := cfg.profaneWords
+	if words == nil {
+		words = defaultProfaneWords
+	}
+	mask := cfg.profaneMask
+	if mask == "" {
+		mask = defaultProfaneMask
+	}
+	return replaceProfaneWords(sentence, words, mask)
+}
 
-	for _, word := range wordsToCheck {
+func replaceProfaneWords(sentence string, words []string, mask string) string {
+	for _, word := range words {
 		lowerWord := strings.ToLower(word)
 		if strings.Contains(sentence, word) || strings.Contains(sentence, lowerWord) {
-			sentence = strings.ReplaceAll(sentence, word, "****")
-			sentence = strings.ReplaceAll(sentence, lowerWord, "****")
+			sentence = strings.ReplaceAll(sentence, word, maskProfaneWord(word, mask))
+			sentence = strings.ReplaceAll(sentence, lowerWord, maskProfaneWord(lowerWord, mask))
 		}
 	}
 
 	return sentence
-}
\ No newline at end of file
+}
+
+func maskProfaneWord(word, mask string) string {
+	if mask == profaneMaskLength {
+		return strings.Repeat("*", utf8.RuneCountInString(word))
+	}
+	return mask
+}
+
+// normalizeEmail lowercases and trims an address so that differently-cased
+// spellings of the same email map to a single account.
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// isUniqueViolation reports whether err is Postgres rejecting a write that
+// would break a unique constraint or index.
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+// isValidEmail reports whether email is a bare address with a dotted domain,
+// such as "user@example.com". Display-name forms like
+// "User <user@example.com>" are rejected.
+func isValidEmail(email string) bool {
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return false
+	}
+	domain := email[strings.LastIndex(email, "@")+1:]
+	return strings.Contains(domain, ".")
+}
+
+// commonPasswords is a small blocklist of passwords that show up at the top
+// of every leaked-credential list.
+var commonPasswords = map[string]bool{
+	"password":    true,
+	"password1":   true,
+	"password123": true,
+	"12345678":    true,
+	"123456789":   true,
+	"1234567890":  true,
+	"11111111":    true,
+	"qwerty123":   true,
+	"qwertyuiop":  true,
+	"iloveyou":    true,
+	"letmein123":  true,
+	"abc12345":    true,
+	"chirpy123":   true,
+}
+
+// validatePassword enforces the password policy: at least minLength
+// characters and not one of the commonPasswords.
+func validatePassword(password string, minLength int) error {
+	if len([]rune(password)) < minLength {
+		return fmt.Errorf("Password must be at least %d characters long", minLength)
+	}
+	if commonPasswords[strings.ToLower(password)] {
+		return errors.New("Password is too common, please choose another")
+	}
+	return nil
+}
+
+// jwtRole is the role claim for dbUser's access tokens.
+func jwtRole(dbUser database.User) string {
+	if dbUser.IsAdmin {
+		return auth.RoleAdmin
+	}
+	return auth.RoleMember
+}
+
+// credentialFieldErrors validates an email and password from a request
+// body. It returns the normalized email and a message for each invalid field,
+// keyed by JSON field name. Empty fields are "required" unless optional.
+func (cfg *apiConfig) credentialFieldErrors(email, password string, optional bool) (string, map[string]string) {
+	fields := map[string]string{}
+
+	email = normalizeEmail(email)
+	switch {
+	case email == "" && !optional:
+		fields["email"] = "required"
+	case email != "" && !isValidEmail(email):
+		fields["email"] = "must be a valid email address"
+	}
+
+	switch {
+	case password == "" && !optional:
+		fields["password"] = "required"
+	case password != "":
+		if err := validatePassword(password, cfg.passwordMinLength); err != nil {
+			fields["password"] = err.Error()
+		}
+	}
+
+	return email, fields
+}
+
+// paginationLinks builds an RFC 8288 Link header value pointing at the
+// first, previous and next pages of a listing of total items, by rewriting
+// the limit and offset of the current request URL u. next is left out on the
+// last page and prev on the first.
+func paginationLinks(u *url.URL, limit, offset, total int) string {
+	link := func(rel string, offset int) string {
+		query := u.Query()
+		query.Set("limit", strconv.Itoa(limit))
+		query.Set("offset", strconv.Itoa(offset))
+		return fmt.Sprintf(`<%s?%s>; rel="%s"`, u.Path, query.Encode(), rel)
+	}
+
+	links := []string{link("first", 0)}
+	if offset > 0 {
+		links = append(links, link("prev", max(offset-limit, 0)))
+	}
+	if offset+limit < total {
+		links = append(links, link("next", offset+limit))
+	}
+	return strings.Join(links, ", ")
+}
+
+// escapeLikePattern escapes the LIKE wildcards in s so it matches literally.
+func escapeLikePattern(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+// parseListenAddr builds the server address from LISTEN_ADDR, or failing
+// that HOST and PORT, defaulting to ":8080". The port must be numeric so a
+// typo fails at startup rather than at the first connection.
+func parseListenAddr(listenAddr, host, port string) (string, error) {
+	if listenAddr == "" {
+		if port == "" {
+			port = "8080"
+		}
+		listenAddr = net.JoinHostPort(host, port)
+	}
+	_, port, err := net.SplitHostPort(listenAddr)
+	if err != nil {
+		return "", err
+	}
+	if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+		return "", fmt.Errorf("invalid port %q", port)
+	}
+	return listenAddr, nil
+}
+
+// parseTrustedProxies parses a comma-separated list of IPs and CIDR ranges,
+// e.g. "10.0.0.0/8, 127.0.0.1".
+func parseTrustedProxies(s string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if strings.Contains(entry, "/") {
+			prefix, err := netip.ParsePrefix(entry)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q: %w", entry, err)
+			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", entry, err)
+		}
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return prefixes, nil
+}
+
+func isTrustedProxy(addr netip.Addr, trustedProxies []netip.Prefix) bool {
+	for _, prefix := range trustedProxies {
+		if prefix.Contains(addr.Unmap()) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedClientIP returns the IP of the client that made r. When the
+// direct peer is a trusted proxy, X-Forwarded-For is walked from the right
+// and the first address not belonging to a trusted proxy is used, so
+// clients can't spoof their IP by sending the header themselves.
+func forwardedClientIP(r *http.Request, trustedProxies []netip.Prefix) string {
+	ip := clientIP(r)
+	addr, err := netip.ParseAddr(ip)
+	if err != nil || !isTrustedProxy(addr, trustedProxies) {
+		return ip
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		ip = hop.Unmap().String()
+		if !isTrustedProxy(hop, trustedProxies) {
+			break
+		}
+	}
+	return ip
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/WOsaka/chirpy-server/internal/database"
+)
+
+// fileserverHitsMetric is the metrics row fileserverHits is persisted under.
+const fileserverHitsMetric = "fileserver_hits"
+
+const defaultMetricsFlushInterval = 10 * time.Second
+
+// loadFileserverHits seeds the in-memory hit counter from the database so the
+// count survives restarts.
+func (cfg *apiConfig) loadFileserverHits(ctx context.Context) error {
+	hits, err := cfg.db.GetMetric(ctx, fileserverHitsMetric)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	cfg.hitsMu.Lock()
+	defer cfg.hitsMu.Unlock()
+	cfg.fileserverHits.Store(int32(hits))
+	cfg.flushedHits = int32(hits)
+	return nil
+}
+
+// flushFileserverHits adds the hits counted since the last flush to the
+// stored total. Adding a delta rather than overwriting keeps the total right
+// when several instances share a database.
+func (cfg *apiConfig) flushFileserverHits(ctx context.Context) error {
+	cfg.hitsMu.Lock()
+	defer cfg.hitsMu.Unlock()
+
+	hits := cfg.fileserverHits.Load()
+	if hits == cfg.flushedHits {
+		return nil
+	}
+	if err := cfg.db.IncrementMetric(ctx, database.IncrementMetricParams{
+		Name:  fileserverHitsMetric,
+		Value: int64(hits - cfg.flushedHits),
+	}); err != nil {
+		return err
+	}
+	cfg.flushedHits = hits
+	return nil
+}
+
+// flushFileserverHitsEvery flushes the hit counter each interval until ctx is
+// done. Hits since the last flush are lost if the process exits.
+func (cfg *apiConfig) flushFileserverHitsEvery(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := cfg.flushFileserverHits(ctx); err != nil {
+				slog.Error("Error flushing fileserver hits", "error", err)
+			}
+		}
+	}
+}
+
+// resetFileserverHits zeroes the hit counter in memory and in the database.
+func (cfg *apiConfig) resetFileserverHits(ctx context.Context) error {
+	cfg.hitsMu.Lock()
+	defer cfg.hitsMu.Unlock()
+
+	if err := cfg.db.DeleteAllMetrics(ctx); err != nil {
+		return err
+	}
+	cfg.fileserverHits.Store(0)
+	cfg.flushedHits = 0
+	return nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/WOsaka/chirpy-server/internal/database"
+)
+
+type metricsDB struct {
+	database.Querier
+	metrics map[string]int64
+}
+
+func (db *metricsDB) GetMetric(ctx context.Context, name string) (int64, error) {
+	value, ok := db.metrics[name]
+	if !ok {
+		return 0, sql.ErrNoRows
+	}
+	return value, nil
+}
+
+func (db *metricsDB) IncrementMetric(ctx context.Context, arg database.IncrementMetricParams) error {
+	db.metrics[arg.Name] += arg.Value
+	return nil
+}
+
+func (db *metricsDB) DeleteAllMetrics(ctx context.Context) error {
+	clear(db.metrics)
+	return nil
+}
+
+func TestFileserverHitsSurviveRestart(t *testing.T) {
+	db := &metricsDB{metrics: map[string]int64{}}
+	ctx := context.Background()
+
+	visit := func(cfg *apiConfig, n int) {
+		handler := cfg.middlewareMetricsInc(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		for i := 0; i < n; i++ {
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/app/", nil))
+		}
+	}
+
+	cfg := &apiConfig{db: db}
+	if err := cfg.loadFileserverHits(ctx); err != nil {
+		t.Fatalf("loadFileserverHits on an empty table failed: %v", err)
+	}
+	visit(cfg, 3)
+	if err := cfg.flushFileserverHits(ctx); err != nil {
+		t.Fatalf("flushFileserverHits failed: %v", err)
+	}
+	visit(cfg, 2)
+	if err := cfg.flushFileserverHits(ctx); err != nil {
+		t.Fatalf("flushFileserverHits failed: %v", err)
+	}
+	if got := db.metrics[fileserverHitsMetric]; got != 5 {
+		t.Fatalf("stored hits = %d; want 5", got)
+	}
+
+	restarted := &apiConfig{db: db}
+	if err := restarted.loadFileserverHits(ctx); err != nil {
+		t.Fatalf("loadFileserverHits failed: %v", err)
+	}
+	if got := restarted.fileserverHits.Load(); got != 5 {
+		t.Errorf("hits after restart = %d; want 5", got)
+	}
+
+	visit(restarted, 1)
+	if err := restarted.flushFileserverHits(ctx); err != nil {
+		t.Fatalf("flushFileserverHits failed: %v", err)
+	}
+	if got := db.metrics[fileserverHitsMetric]; got != 6 {
+		t.Errorf("stored hits after restart = %d; want 6", got)
+	}
+
+	if err := restarted.resetFileserverHits(ctx); err != nil {
+		t.Fatalf("resetFileserverHits failed: %v", err)
+	}
+	if got := restarted.fileserverHits.Load(); got != 0 {
+		t.Errorf("hits after reset = %d; want 0", got)
+	}
+	if _, ok := db.metrics[fileserverHitsMetric]; ok {
+		t.Error("reset left the stored hits in place")
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+
+	"github.com/WOsaka/chirpy-server/internal/database"
+)
+
+// expectedIndex is an index the hot queries rely on, identified by its table
+// and leading column rather than its name so renamed indexes still count.
+type expectedIndex struct {
+	table  string
+	column string
+}
+
+var expectedIndexes = []expectedIndex{
+	{"chirps", "user_id"},
+	{"chirps", "created_at"},
+	{"refresh_tokens", "token"},
+}
+
+// checkIndexes compares expectedIndexes against pg_indexes, logs a warning
+// for each one that is missing and returns them. It is only advisory: a
+// missing index usually means a migration hasn't been applied.
+func checkIndexes(ctx context.Context, db database.Querier) ([]expectedIndex, error) {
+	rows, err := db.ListIndexes(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	present := map[expectedIndex]bool{}
+	for _, row := range rows {
+		if column := leadingIndexColumn(row.Indexdef); column != "" {
+			present[expectedIndex{table: row.TableName, column: column}] = true
+		}
+	}
+
+	var missing []expectedIndex
+	for _, index := range expectedIndexes {
+		if !present[index] {
+			slog.Warn("Missing expected index", "table", index.table, "column", index.column)
+			missing = append(missing, index)
+		}
+	}
+	return missing, nil
+}
+
+// leadingIndexColumn returns the first column of an index definition such
+// as "CREATE INDEX i ON public.chirps USING btree (created_at DESC)".
+func leadingIndexColumn(indexdef string) string {
+	start := strings.Index(indexdef, "(")
+	end := strings.LastIndex(indexdef, ")")
+	if start < 0 || end < start {
+		return ""
+	}
+	columns := strings.Split(indexdef[start+1:end], ",")
+	fields := strings.Fields(columns[0])
+	if len(fields) == 0 {
+		return ""
+	}
+	return strings.Trim(fields[0], `"`)
+}
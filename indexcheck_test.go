@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/WOsaka/chirpy-server/internal/database"
+)
+
+type indexesDB struct {
+	database.Querier
+	indexes []database.ListIndexesRow
+}
+
+func (db *indexesDB) ListIndexes(ctx context.Context) ([]database.ListIndexesRow, error) {
+	return db.indexes, nil
+}
+
+func TestCheckIndexes(t *testing.T) {
+	db := &indexesDB{indexes: []database.ListIndexesRow{
+		{TableName: "chirps", IndexName: "chirps_pkey", Indexdef: "CREATE UNIQUE INDEX chirps_pkey ON public.chirps USING btree (id)"},
+		{TableName: "chirps", IndexName: "chirps_created_at_idx", Indexdef: "CREATE INDEX chirps_created_at_idx ON public.chirps USING btree (created_at DESC)"},
+		{TableName: "refresh_tokens", IndexName: "refresh_tokens_pkey", Indexdef: "CREATE UNIQUE INDEX refresh_tokens_pkey ON public.refresh_tokens USING btree (token)"},
+		// user_id is indexed here, but not as the leading column.
+		{TableName: "chirps", IndexName: "chirps_body_user_idx", Indexdef: "CREATE INDEX chirps_body_user_idx ON public.chirps USING btree (body, user_id)"},
+	}}
+
+	missing, err := checkIndexes(context.Background(), db)
+	if err != nil {
+		t.Fatalf("checkIndexes failed: %v", err)
+	}
+	expected := []expectedIndex{{table: "chirps", column: "user_id"}}
+	if !reflect.DeepEqual(missing, expected) {
+		t.Errorf("missing = %+v; want %+v", missing, expected)
+	}
+}
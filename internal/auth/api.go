@@ -1,6 +1,8 @@
 package auth
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"net/http"
 	"strings"
@@ -18,4 +20,12 @@ func GetAPIKey(headers http.Header) (string, error) {
 	}
 	apiKey := fields[len(fields)-1]
 	return apiKey, nil
-}
\ No newline at end of file
+}
+
+// HashAPIKey returns the hex SHA-256 digest under which an API key is
+// stored. Keys are long random strings, so unlike passwords they need no
+// slow hash, and a deterministic one lets them be looked up directly.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
@@ -1,11 +1,21 @@
 package auth
 
 import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
 	"net/http"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
 )
 
 func TestHashPassword(t *testing.T) {
@@ -37,6 +47,81 @@ func TestCheckPasswordHash(t *testing.T) {
 	if err == nil {
 		t.Error("CheckPasswordHash should have failed with an incorrect password")
 	}
+	if !errors.Is(err, ErrPasswordMismatch) {
+		t.Errorf("CheckPasswordHash with wrong password returned %v, want ErrPasswordMismatch", err)
+	}
+}
+
+func TestHashPasswordWithCost(t *testing.T) {
+	hashedPassword, err := HashPasswordWithCost("testPassword123", MinBcryptCost)
+	if err != nil {
+		t.Fatalf("HashPasswordWithCost failed: %v", err)
+	}
+	cost, err := bcrypt.Cost([]byte(hashedPassword))
+	if err != nil {
+		t.Fatalf("bcrypt.Cost failed: %v", err)
+	}
+	if cost != MinBcryptCost {
+		t.Errorf("hash cost = %d; want %d", cost, MinBcryptCost)
+	}
+	if err := CheckPasswordHash(hashedPassword, "testPassword123"); err != nil {
+		t.Errorf("CheckPasswordHash failed: %v", err)
+	}
+
+	for _, cost := range []int{0, MinBcryptCost - 1, MaxBcryptCost + 1} {
+		if _, err := HashPasswordWithCost("testPassword123", cost); err == nil {
+			t.Errorf("HashPasswordWithCost with cost %d should fail", cost)
+		}
+	}
+}
+
+func TestCheckPasswordHashCorruptHash(t *testing.T) {
+	for _, hash := range []string{"", "unset", "$2a$10$truncated"} {
+		err := CheckPasswordHash(hash, "testPassword123")
+		if err == nil {
+			t.Errorf("CheckPasswordHash(%q) should fail", hash)
+			continue
+		}
+		if errors.Is(err, ErrPasswordMismatch) {
+			t.Errorf("CheckPasswordHash(%q) reported a mismatch, want a corrupt-hash error", hash)
+		}
+	}
+}
+
+func TestMakeRefreshToken(t *testing.T) {
+	for _, n := range []int{MinRefreshTokenBytes, DefaultRefreshTokenBytes, 64} {
+		first, err := MakeRefreshTokenWithLength(n)
+		if err != nil {
+			t.Fatalf("MakeRefreshTokenWithLength(%d) failed: %v", n, err)
+		}
+		second, err := MakeRefreshTokenWithLength(n)
+		if err != nil {
+			t.Fatalf("MakeRefreshTokenWithLength(%d) failed: %v", n, err)
+		}
+		if len(first) != 2*n || len(second) != 2*n {
+			t.Errorf("MakeRefreshTokenWithLength(%d) lengths = %d, %d; want %d", n, len(first), len(second), 2*n)
+		}
+		if _, err := hex.DecodeString(first); err != nil {
+			t.Errorf("MakeRefreshTokenWithLength(%d) = %q is not hex: %v", n, first, err)
+		}
+		if first == second {
+			t.Errorf("MakeRefreshTokenWithLength(%d) returned the same token twice", n)
+		}
+	}
+
+	token, err := MakeRefreshToken()
+	if err != nil {
+		t.Fatalf("MakeRefreshToken failed: %v", err)
+	}
+	if len(token) != 2*DefaultRefreshTokenBytes {
+		t.Errorf("MakeRefreshToken length = %d; want %d", len(token), 2*DefaultRefreshTokenBytes)
+	}
+
+	for _, n := range []int{0, MinRefreshTokenBytes - 1} {
+		if _, err := MakeRefreshTokenWithLength(n); err == nil {
+			t.Errorf("MakeRefreshTokenWithLength(%d) should fail", n)
+		}
+	}
 }
 
 func TestMakeJWTAndValidateJWT(t *testing.T) {
@@ -69,6 +154,57 @@ func TestMakeJWTAndValidateJWT(t *testing.T) {
 	}
 }
 
+func TestJWTCustomClaims(t *testing.T) {
+	keys := NewHS256Keys("testsecret")
+	userID := uuid.New()
+
+	token, err := keys.MakeJWTWithClaims(userID, RoleAdmin, "admin@example.com", time.Hour)
+	if err != nil {
+		t.Fatalf("MakeJWTWithClaims failed: %v", err)
+	}
+	claims, err := keys.ParseJWT(token)
+	if err != nil {
+		t.Fatalf("ParseJWT failed: %v", err)
+	}
+	if claims.Role != RoleAdmin || claims.Email != "admin@example.com" {
+		t.Errorf("claims role = %q, email = %q; want %q, %q", claims.Role, claims.Email, RoleAdmin, "admin@example.com")
+	}
+	if parsedUserID, err := claims.UserID(); err != nil || parsedUserID != userID {
+		t.Errorf("claims UserID() = %v, %v; want %v", parsedUserID, err, userID)
+	}
+	if parsedUserID, err := keys.ValidateJWT(token); err != nil || parsedUserID != userID {
+		t.Errorf("ValidateJWT = %v, %v; want %v", parsedUserID, err, userID)
+	}
+
+	// Tokens issued before the custom claims existed still parse.
+	legacy, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
+		Issuer:    "chirpy",
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		Subject:   userID.String(),
+	}).SignedString(keys.Secret)
+	if err != nil {
+		t.Fatalf("signing legacy token: %v", err)
+	}
+	claims, err = keys.ParseJWT(legacy)
+	if err != nil {
+		t.Fatalf("ParseJWT of legacy token failed: %v", err)
+	}
+	if claims.Role != "" || claims.Email != "" || claims.Subject != userID.String() {
+		t.Errorf("legacy claims = %+v; want only subject %v", claims, userID)
+	}
+
+	badSubject, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		Subject:   "not-a-uuid",
+	}).SignedString(keys.Secret)
+	if err != nil {
+		t.Fatalf("signing token: %v", err)
+	}
+	if _, err := keys.ParseJWT(badSubject); err == nil {
+		t.Error("ParseJWT should reject a subject that is not a user ID")
+	}
+}
+
 func TestValidateJWTExpired(t *testing.T) {
 	userID := uuid.New()
 	secret := "testsecret"
@@ -172,5 +308,138 @@ func TestGetBearerToken(t *testing.T) {
 	if err == nil {
 		t.Error("GetBearerToken should fail when Authorization header is missing")
 	}
+	// Malformed headers must return an error rather than panic.
+	for _, value := range []string{"Bearer", "testtoken123", "Basic dXNlcjpwYXNz", "Bearer a b"} {
+		headers = http.Header{}
+		headers.Set("Authorization", value)
+		if _, err := GetBearerToken(headers); err == nil {
+			t.Errorf("GetBearerToken should fail for Authorization %q", value)
+		}
+	}
+}
+
+func newRS256Keys(t *testing.T) JWTKeys {
+	t.Helper()
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generating RSA key: %v", err)
+	}
+	return JWTKeys{Algorithm: AlgorithmRS256, PrivateKey: privateKey, PublicKey: &privateKey.PublicKey}
 }
 
+func TestJWTKeys_BothAlgorithms(t *testing.T) {
+	userID := uuid.New()
+	for _, keys := range []JWTKeys{NewHS256Keys("supersecret"), newRS256Keys(t)} {
+		token, err := keys.MakeJWT(userID, 10*time.Minute)
+		if err != nil {
+			t.Fatalf("%s MakeJWT failed: %v", keys.Algorithm, err)
+		}
+
+		parsedUserID, err := keys.ValidateJWT(token)
+		if err != nil {
+			t.Fatalf("%s ValidateJWT failed: %v", keys.Algorithm, err)
+		}
+		if parsedUserID != userID {
+			t.Errorf("%s ValidateJWT returned wrong userID: got %v, want %v", keys.Algorithm, parsedUserID, userID)
+		}
+	}
+}
+
+func TestJWTKeys_RejectsCrossAlgorithmTokens(t *testing.T) {
+	userID := uuid.New()
+	hsKeys := NewHS256Keys("supersecret")
+	rsKeys := newRS256Keys(t)
+
+	hsToken, err := hsKeys.MakeJWT(userID, 10*time.Minute)
+	if err != nil {
+		t.Fatalf("MakeJWT failed: %v", err)
+	}
+	if _, err := rsKeys.ValidateJWT(hsToken); err == nil {
+		t.Error("RS256 keys should reject an HS256 token")
+	}
+
+	rsToken, err := rsKeys.MakeJWT(userID, 10*time.Minute)
+	if err != nil {
+		t.Fatalf("MakeJWT failed: %v", err)
+	}
+	if _, err := hsKeys.ValidateJWT(rsToken); err == nil {
+		t.Error("HS256 keys should reject an RS256 token")
+	}
+}
+
+func TestLoadRS256Keys(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generating RSA key: %v", err)
+	}
+	publicDER, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	if err != nil {
+		t.Fatalf("marshaling public key: %v", err)
+	}
+
+	dir := t.TempDir()
+	privatePath := filepath.Join(dir, "jwt.key")
+	publicPath := filepath.Join(dir, "jwt.pub")
+	privatePEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(privateKey)})
+	publicPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER})
+	if err := os.WriteFile(privatePath, privatePEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(publicPath, publicPEM, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	signer, err := LoadRS256Keys(privatePath, "")
+	if err != nil {
+		t.Fatalf("LoadRS256Keys failed: %v", err)
+	}
+	verifier, err := LoadRS256Keys(privatePath, publicPath)
+	if err != nil {
+		t.Fatalf("LoadRS256Keys failed: %v", err)
+	}
+
+	userID := uuid.New()
+	token, err := signer.MakeJWT(userID, 10*time.Minute)
+	if err != nil {
+		t.Fatalf("MakeJWT failed: %v", err)
+	}
+	parsedUserID, err := verifier.ValidateJWT(token)
+	if err != nil {
+		t.Fatalf("ValidateJWT failed: %v", err)
+	}
+	if parsedUserID != userID {
+		t.Errorf("ValidateJWT returned wrong userID: got %v, want %v", parsedUserID, userID)
+	}
+}
+
+func TestVerifyWebhookSignature(t *testing.T) {
+	body := []byte(`{"event":"user.upgraded","data":{"user_id":"3311741c-680c-4546-99f3-fc9efac2036c"}}`)
+	signature := SignWebhook(body, "polka-key")
+
+	tests := []struct {
+		name      string
+		body      []byte
+		key       string
+		signature string
+		wantErr   bool
+	}{
+		{"valid", body, "polka-key", signature, false},
+		{"tampered body", []byte(`{"event":"user.upgraded","data":{"user_id":"00000000-0000-0000-0000-000000000000"}}`), "polka-key", signature, true},
+		{"wrong key", body, "other-key", signature, true},
+		{"missing signature", body, "polka-key", "", true},
+		{"not hex", body, "polka-key", "not-a-signature", true},
+		{"truncated", body, "polka-key", signature[:32], true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := VerifyWebhookSignature(tt.body, tt.key, tt.signature)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("VerifyWebhookSignature() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrInvalidSignature) {
+				t.Errorf("error = %v; want ErrInvalidSignature", err)
+			}
+		})
+	}
+}
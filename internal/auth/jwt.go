@@ -1,8 +1,11 @@
 package auth
 
 import (
+	"crypto/rsa"
 	"errors"
+	"fmt"
 	"net/http"
+	"os"
 	"strings"
 	"time"
 
@@ -10,41 +13,172 @@ import (
 	"github.com/google/uuid"
 )
 
-func MakeJWT(userID uuid.UUID, tokenSecret string, expiresIn time.Duration) (string, error) {
-	method := jwt.SigningMethodHS256
-	claims := jwt.RegisteredClaims{
-		Issuer:    "chirpy",
-		IssuedAt:  jwt.NewNumericDate(time.Now()),
-		ExpiresAt: jwt.NewNumericDate(time.Now().Add(expiresIn)),
-		Subject:   userID.String(),
+// Supported JWT signing algorithms.
+const (
+	AlgorithmHS256 = "HS256"
+	AlgorithmRS256 = "RS256"
+)
+
+// Roles carried in an access token's role claim.
+const (
+	RoleAdmin  = "admin"
+	RoleMember = "member"
+)
+
+// ChirpyClaims are the claims in a Chirpy access token. Role and Email are
+// optional: tokens issued without them still parse, leaving them empty. They
+// reflect the user when the token was issued, so anything that must see a
+// role change immediately should still check the database.
+type ChirpyClaims struct {
+	Role  string `json:"role,omitempty"`
+	Email string `json:"email,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// UserID returns the user ID in the token's subject.
+func (c *ChirpyClaims) UserID() (uuid.UUID, error) {
+	return uuid.Parse(c.Subject)
+}
+
+// JWTKeys holds the algorithm and key material used to sign and verify
+// access tokens. HS256 uses Secret for both; RS256 signs with PrivateKey and
+// verifies with PublicKey, so verifiers never need the signing key.
+type JWTKeys struct {
+	Algorithm  string
+	Secret     []byte
+	PrivateKey *rsa.PrivateKey
+	PublicKey  *rsa.PublicKey
+}
+
+// NewHS256Keys returns keys for symmetric HS256 signing with secret.
+func NewHS256Keys(secret string) JWTKeys {
+	return JWTKeys{Algorithm: AlgorithmHS256, Secret: []byte(secret)}
+}
+
+// LoadRS256Keys reads PEM-encoded RSA keys from disk. publicKeyPath may be
+// empty, in which case the public half of the private key is used.
+func LoadRS256Keys(privateKeyPath, publicKeyPath string) (JWTKeys, error) {
+	privatePEM, err := os.ReadFile(privateKeyPath)
+	if err != nil {
+		return JWTKeys{}, fmt.Errorf("reading private key: %w", err)
+	}
+	privateKey, err := jwt.ParseRSAPrivateKeyFromPEM(privatePEM)
+	if err != nil {
+		return JWTKeys{}, fmt.Errorf("parsing private key: %w", err)
+	}
+
+	publicKey := &privateKey.PublicKey
+	if publicKeyPath != "" {
+		publicPEM, err := os.ReadFile(publicKeyPath)
+		if err != nil {
+			return JWTKeys{}, fmt.Errorf("reading public key: %w", err)
+		}
+		publicKey, err = jwt.ParseRSAPublicKeyFromPEM(publicPEM)
+		if err != nil {
+			return JWTKeys{}, fmt.Errorf("parsing public key: %w", err)
+		}
+	}
+
+	return JWTKeys{Algorithm: AlgorithmRS256, PrivateKey: privateKey, PublicKey: publicKey}, nil
+}
+
+func (k JWTKeys) signingMethod() (jwt.SigningMethod, error) {
+	switch k.Algorithm {
+	case AlgorithmHS256:
+		return jwt.SigningMethodHS256, nil
+	case AlgorithmRS256:
+		return jwt.SigningMethodRS256, nil
+	default:
+		return nil, fmt.Errorf("unsupported JWT algorithm %q", k.Algorithm)
+	}
+}
+
+func (k JWTKeys) signingKey() interface{} {
+	if k.Algorithm == AlgorithmRS256 {
+		return k.PrivateKey
+	}
+	return k.Secret
+}
+
+func (k JWTKeys) verificationKey() interface{} {
+	if k.Algorithm == AlgorithmRS256 {
+		return k.PublicKey
+	}
+	return k.Secret
+}
+
+// MakeJWT issues an access token for userID using the configured algorithm.
+func (k JWTKeys) MakeJWT(userID uuid.UUID, expiresIn time.Duration) (string, error) {
+	return k.MakeJWTWithClaims(userID, "", "", expiresIn)
+}
+
+// MakeJWTWithClaims is MakeJWT with the user's role and email embedded as
+// well. Empty values are left out of the token.
+func (k JWTKeys) MakeJWTWithClaims(userID uuid.UUID, role, email string, expiresIn time.Duration) (string, error) {
+	method, err := k.signingMethod()
+	if err != nil {
+		return "", err
+	}
+	claims := ChirpyClaims{
+		Role:  role,
+		Email: email,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    "chirpy",
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(expiresIn)),
+			Subject:   userID.String(),
+		},
 	}
 	token := jwt.NewWithClaims(method, claims)
-	signedToken, err := token.SignedString([]byte(tokenSecret))
+	signedToken, err := token.SignedString(k.signingKey())
 	if err != nil {
 		return "", err
 	}
 	return signedToken, nil
 }
 
-func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error) {
-	token, err := jwt.ParseWithClaims(tokenString, &jwt.RegisteredClaims{}, func(token *jwt.Token) (interface{}, error) {
-		return []byte(tokenSecret), nil
-	})
-
+// ValidateJWT verifies tokenString and returns the user ID in its subject.
+// Tokens signed with any algorithm other than the configured one are
+// rejected.
+func (k JWTKeys) ValidateJWT(tokenString string) (uuid.UUID, error) {
+	claims, err := k.ParseJWT(tokenString)
 	if err != nil {
 		return uuid.Nil, err
 	}
+	return claims.UserID()
+}
 
-	userIDStr, err := token.Claims.GetSubject()
+// ParseJWT verifies tokenString like ValidateJWT but returns all of its
+// claims, with a subject that is guaranteed to be a valid user ID.
+func (k JWTKeys) ParseJWT(tokenString string) (*ChirpyClaims, error) {
+	method, err := k.signingMethod()
 	if err != nil {
-		return uuid.Nil, err
+		return nil, err
 	}
 
-	parsedUserID, err := uuid.Parse(userIDStr)
+	claims := &ChirpyClaims{}
+	_, err = jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		return k.verificationKey(), nil
+	}, jwt.WithValidMethods([]string{method.Alg()}))
+
 	if err != nil {
-		return uuid.Nil, err
+		return nil, err
 	}
-	return parsedUserID, nil
+
+	if _, err := claims.UserID(); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// MakeJWT issues an HS256 access token signed with tokenSecret.
+func MakeJWT(userID uuid.UUID, tokenSecret string, expiresIn time.Duration) (string, error) {
+	return NewHS256Keys(tokenSecret).MakeJWT(userID, expiresIn)
+}
+
+// ValidateJWT verifies an HS256 access token signed with tokenSecret.
+func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error) {
+	return NewHS256Keys(tokenSecret).ValidateJWT(tokenString)
 }
 
 func GetBearerToken(headers http.Header) (string, error) {
@@ -52,6 +186,9 @@ func GetBearerToken(headers http.Header) (string, error) {
 	if authHeader == "" {
 		return "", errors.New("authorization header doesn't exist")
 	}
-	tokenString := strings.Fields(authHeader)[1]
-	return tokenString, nil
-}
\ No newline at end of file
+	fields := strings.Fields(authHeader)
+	if len(fields) != 2 || !strings.EqualFold(fields[0], "Bearer") {
+		return "", errors.New("authorization header must look like: Bearer <token>")
+	}
+	return fields[1], nil
+}
@@ -1,11 +1,42 @@
 package auth
 
 import (
+	"errors"
+	"fmt"
+
 	"golang.org/x/crypto/bcrypt"
 )
 
+// ErrPasswordMismatch is returned by CheckPasswordHash when the password is
+// simply wrong. Any other error means the stored hash itself is unusable.
+var ErrPasswordMismatch = errors.New("password does not match hash")
+
+// Bounds and default for the bcrypt work factor.
+const (
+	MinBcryptCost     = bcrypt.MinCost
+	MaxBcryptCost     = bcrypt.MaxCost
+	DefaultBcryptCost = bcrypt.DefaultCost
+)
+
+// ValidateBcryptCost reports whether cost is within bcrypt's allowed range.
+func ValidateBcryptCost(cost int) error {
+	if cost < MinBcryptCost || cost > MaxBcryptCost {
+		return fmt.Errorf("bcrypt cost must be between %d and %d, got %d", MinBcryptCost, MaxBcryptCost, cost)
+	}
+	return nil
+}
+
 func HashPassword(password string) (string, error) {
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return HashPasswordWithCost(password, DefaultBcryptCost)
+}
+
+// HashPasswordWithCost is HashPassword with an explicit bcrypt cost. Existing
+// hashes keep their own cost, so CheckPasswordHash works whatever it was.
+func HashPasswordWithCost(password string, cost int) (string, error) {
+	if err := ValidateBcryptCost(cost); err != nil {
+		return "", err
+	}
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), cost)
 	if err != nil {
 		return "", err
 	}
@@ -14,8 +45,11 @@ func HashPassword(password string) (string, error) {
 
 func CheckPasswordHash(hash, password string) error {
 	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return ErrPasswordMismatch
+	}
 	if err != nil {
-		return err
+		return fmt.Errorf("invalid password hash: %w", err)
 	}
 	return nil
 }
@@ -3,14 +3,31 @@ package auth
 import (
 	"crypto/rand"
 	"encoding/hex"
+	"fmt"
+)
+
+// Refresh token lengths in random bytes; the hex-encoded token is twice as
+// long.
+const (
+	MinRefreshTokenBytes     = 16
+	DefaultRefreshTokenBytes = 32
 )
 
 func MakeRefreshToken() (string, error) {
-	data := make([]byte, 32)
+	return MakeRefreshTokenWithLength(DefaultRefreshTokenBytes)
+}
+
+// MakeRefreshTokenWithLength is MakeRefreshToken with n random bytes, which
+// must be at least MinRefreshTokenBytes.
+func MakeRefreshTokenWithLength(n int) (string, error) {
+	if n < MinRefreshTokenBytes {
+		return "", fmt.Errorf("refresh token length must be at least %d bytes, got %d", MinRefreshTokenBytes, n)
+	}
+	data := make([]byte, n)
 	_, err := rand.Read(data)
 	if err != nil {
 		return "", err
 	}
 	refreshToken := hex.EncodeToString(data)
 	return refreshToken, nil
-}
\ No newline at end of file
+}
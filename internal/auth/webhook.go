@@ -0,0 +1,34 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+)
+
+// ErrInvalidSignature is returned by VerifyWebhookSignature when the
+// signature is missing, malformed or doesn't match the body.
+var ErrInvalidSignature = errors.New("invalid webhook signature")
+
+// SignWebhook returns the hex-encoded HMAC-SHA256 of body keyed with key.
+func SignWebhook(body []byte, key string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhookSignature checks a hex-encoded HMAC-SHA256 signature of body,
+// comparing in constant time.
+func VerifyWebhookSignature(body []byte, key, signature string) error {
+	got, err := hex.DecodeString(signature)
+	if err != nil || len(got) != sha256.Size {
+		return ErrInvalidSignature
+	}
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write(body)
+	if !hmac.Equal(got, mac.Sum(nil)) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
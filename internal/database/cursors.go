@@ -0,0 +1,105 @@
+package database
+
+import "context"
+
+// The methods in this file aren't generated: sqlc's :many queries collect
+// every row into a slice, while these hand each row to fn as it is scanned
+// so callers can stream large results. They reuse the generated queries and
+// must be kept in step with their scans.
+
+// EachListChirps runs ListChirps, calling fn for each row in order. It stops
+// at the first error fn returns and returns it.
+func (q *Queries) EachListChirps(ctx context.Context, arg ListChirpsParams, fn func(ListChirpsRow) error) error {
+	rows, err := q.db.QueryContext(ctx, listChirps,
+		arg.IncludeDeleted,
+		arg.AuthorID,
+		arg.Query,
+		arg.Hashtag,
+		arg.CreatedAfter,
+		arg.CreatedBefore,
+		arg.Clean,
+		arg.MinLength,
+		arg.NewestFirst,
+		arg.Limit,
+		arg.Offset,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var i ListChirpsRow
+		if err := rows.Scan(
+			&i.Chirp.ID,
+			&i.Chirp.CreatedAt,
+			&i.Chirp.UpdatedAt,
+			&i.Chirp.Body,
+			&i.Chirp.UserID,
+			&i.Chirp.ParentChirpID,
+			&i.Chirp.CreatorIp,
+			&i.Chirp.DeletedAt,
+			&i.Chirp.RawBody,
+			&i.Chirp.MediaUrl,
+			&i.LikeCount,
+		); err != nil {
+			return err
+		}
+		if err := fn(i); err != nil {
+			return err
+		}
+	}
+	if err := rows.Close(); err != nil {
+		return err
+	}
+	return rows.Err()
+}
+
+// EachListChirpsWithAuthors is EachListChirps for ListChirpsWithAuthors.
+func (q *Queries) EachListChirpsWithAuthors(ctx context.Context, arg ListChirpsWithAuthorsParams, fn func(ListChirpsWithAuthorsRow) error) error {
+	rows, err := q.db.QueryContext(ctx, listChirpsWithAuthors,
+		arg.IncludeDeleted,
+		arg.AuthorID,
+		arg.Query,
+		arg.Hashtag,
+		arg.CreatedAfter,
+		arg.CreatedBefore,
+		arg.Clean,
+		arg.MinLength,
+		arg.NewestFirst,
+		arg.Limit,
+		arg.Offset,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var i ListChirpsWithAuthorsRow
+		if err := rows.Scan(
+			&i.Chirp.ID,
+			&i.Chirp.CreatedAt,
+			&i.Chirp.UpdatedAt,
+			&i.Chirp.Body,
+			&i.Chirp.UserID,
+			&i.Chirp.ParentChirpID,
+			&i.Chirp.CreatorIp,
+			&i.Chirp.DeletedAt,
+			&i.Chirp.RawBody,
+			&i.Chirp.MediaUrl,
+			&i.Email,
+			&i.IsChirpyRed,
+			&i.Username,
+			&i.DisplayName,
+			&i.LikeCount,
+		); err != nil {
+			return err
+		}
+		if err := fn(i); err != nil {
+			return err
+		}
+	}
+	if err := rows.Close(); err != nil {
+		return err
+	}
+	return rows.Err()
+}
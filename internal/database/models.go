@@ -11,21 +11,105 @@ import (
 	"github.com/google/uuid"
 )
 
-type Chirp struct {
+type ApiKey struct {
 	ID        uuid.UUID
 	CreatedAt time.Time
-	UpdatedAt time.Time
-	Body      string
 	UserID    uuid.UUID
+	Name      string
+	KeyHash   string
+	RevokedAt sql.NullTime
 }
 
-type RefreshToken struct {
+type Chirp struct {
+	ID            uuid.UUID
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+	Body          string
+	UserID        uuid.UUID
+	ParentChirpID uuid.NullUUID
+	CreatorIp     sql.NullString
+	DeletedAt     sql.NullTime
+	RawBody       sql.NullString
+	MediaUrl      sql.NullString
+}
+
+type ChirpHashtag struct {
+	ChirpID   uuid.UUID
+	Hashtag   string
+	CreatedAt time.Time
+}
+
+type ChirpIdempotencyKey struct {
+	UserID         uuid.UUID
+	IdempotencyKey string
+	ChirpID        uuid.UUID
+	CreatedAt      time.Time
+}
+
+type ChirpLike struct {
+	UserID    uuid.UUID
+	ChirpID   uuid.UUID
+	CreatedAt time.Time
+}
+
+type ChirpMention struct {
+	ChirpID   uuid.UUID
+	UserID    uuid.UUID
+	CreatedAt time.Time
+}
+
+type DeletedChirpID struct {
+	ChirpID   uuid.UUID
+	DeletedAt time.Time
+}
+
+type EmailChangeToken struct {
 	Token     string
 	CreatedAt time.Time
+	UserID    uuid.UUID
+	NewEmail  string
+	ExpiresAt time.Time
+	UsedAt    sql.NullTime
+}
+
+type EmailVerificationToken struct {
+	Token     string
+	CreatedAt time.Time
+	UserID    uuid.UUID
+	ExpiresAt time.Time
+	UsedAt    sql.NullTime
+}
+
+type Follow struct {
+	FollowerID uuid.UUID
+	FolloweeID uuid.UUID
+	CreatedAt  time.Time
+}
+
+type Metric struct {
+	Name      string
+	Value     int64
 	UpdatedAt time.Time
+}
+
+type PasswordResetToken struct {
+	Token     string
+	CreatedAt time.Time
 	UserID    uuid.UUID
 	ExpiresAt time.Time
-	RevokedAt sql.NullTime
+	UsedAt    sql.NullTime
+}
+
+type RefreshToken struct {
+	Token      string
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	UserID     uuid.UUID
+	ExpiresAt  time.Time
+	RevokedAt  sql.NullTime
+	FamilyID   uuid.UUID
+	ReplacedBy sql.NullString
+	ID         uuid.UUID
 }
 
 type User struct {
@@ -35,4 +119,19 @@ type User struct {
 	Email          string
 	HashedPassword string
 	IsChirpyRed    bool
+	EmailVerified  bool
+	Username       sql.NullString
+	DisplayName    sql.NullString
+	IsAdmin        bool
+	LastLoginAt    sql.NullTime
+}
+
+type WebhookEvent struct {
+	ID          string
+	CreatedAt   time.Time
+	EventType   string
+	Payload     string
+	ProcessedAt sql.NullTime
+	Attempts    int32
+	LastError   sql.NullString
 }
@@ -7,30 +7,131 @@ package database
 
 import (
 	"context"
+	"database/sql"
 	"time"
 
 	"github.com/google/uuid"
 )
 
+const countChirps = `-- name: CountChirps :one
+SELECT COUNT(*) FROM chirps
+WHERE deleted_at IS NULL
+`
+
+func (q *Queries) CountChirps(ctx context.Context) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countChirps)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const countChirpsByAuthor = `-- name: CountChirpsByAuthor :one
+SELECT COUNT(*) FROM chirps
+WHERE deleted_at IS NULL
+  AND ($1::uuid IS NULL OR user_id = $1)
+`
+
+func (q *Queries) CountChirpsByAuthor(ctx context.Context, authorID uuid.NullUUID) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countChirpsByAuthor, authorID)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const countListChirps = `-- name: CountListChirps :one
+SELECT COUNT(*) AS count,
+    COALESCE(MAX(updated_at), 'epoch')::timestamp AS last_updated_at
+FROM chirps
+WHERE ($1::boolean OR deleted_at IS NULL)
+  AND ($2::uuid IS NULL OR user_id = $2)
+  AND ($3::text IS NULL OR body ILIKE '%' || $3 || '%')
+  AND ($4::text IS NULL OR id IN (
+    SELECT chirp_id FROM chirp_hashtags
+    WHERE hashtag = $4
+  ))
+  AND created_at BETWEEN COALESCE($5::timestamp, '-infinity')
+                     AND COALESCE($6::timestamp, 'infinity')
+  AND (NOT $7::boolean OR raw_body IS NULL)
+  AND char_length(body) >= $8::int
+`
+
+type CountListChirpsParams struct {
+	IncludeDeleted bool
+	AuthorID       uuid.NullUUID
+	Query          sql.NullString
+	Hashtag        sql.NullString
+	CreatedAfter   sql.NullTime
+	CreatedBefore  sql.NullTime
+	Clean          bool
+	MinLength      int32
+}
+
+type CountListChirpsRow struct {
+	Count         int64
+	LastUpdatedAt time.Time
+}
+
+func (q *Queries) CountListChirps(ctx context.Context, arg CountListChirpsParams) (CountListChirpsRow, error) {
+	row := q.db.QueryRowContext(ctx, countListChirps,
+		arg.IncludeDeleted,
+		arg.AuthorID,
+		arg.Query,
+		arg.Hashtag,
+		arg.CreatedAfter,
+		arg.CreatedBefore,
+		arg.Clean,
+		arg.MinLength,
+	)
+	var i CountListChirpsRow
+	err := row.Scan(&i.Count, &i.LastUpdatedAt)
+	return i, err
+}
+
+const countUsers = `-- name: CountUsers :one
+SELECT COUNT(*) FROM users
+`
+
+func (q *Queries) CountUsers(ctx context.Context) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countUsers)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
 const createChirp = `-- name: CreateChirp :one
-INSERT INTO chirps (id, created_at, updated_at, body, user_id)
+INSERT INTO chirps (id, created_at, updated_at, body, user_id, parent_chirp_id, creator_ip, raw_body, media_url)
 VALUES(
     gen_random_uuid(),
     NOW(), 
     NOW(), 
     $1,
-    $2
+    $2,
+    $3,
+    $4,
+    $5,
+    $6
 )
-RETURNING id, created_at, updated_at, body, user_id
+RETURNING id, created_at, updated_at, body, user_id, parent_chirp_id, creator_ip, deleted_at, raw_body, media_url
 `
 
 type CreateChirpParams struct {
-	Body   string
-	UserID uuid.UUID
+	Body          string
+	UserID        uuid.UUID
+	ParentChirpID uuid.NullUUID
+	CreatorIp     sql.NullString
+	RawBody       sql.NullString
+	MediaUrl      sql.NullString
 }
 
 func (q *Queries) CreateChirp(ctx context.Context, arg CreateChirpParams) (Chirp, error) {
-	row := q.db.QueryRowContext(ctx, createChirp, arg.Body, arg.UserID)
+	row := q.db.QueryRowContext(ctx, createChirp,
+		arg.Body,
+		arg.UserID,
+		arg.ParentChirpID,
+		arg.CreatorIp,
+		arg.RawBody,
+		arg.MediaUrl,
+	)
 	var i Chirp
 	err := row.Scan(
 		&i.ID,
@@ -38,31 +139,43 @@ func (q *Queries) CreateChirp(ctx context.Context, arg CreateChirpParams) (Chirp
 		&i.UpdatedAt,
 		&i.Body,
 		&i.UserID,
+		&i.ParentChirpID,
+		&i.CreatorIp,
+		&i.DeletedAt,
+		&i.RawBody,
+		&i.MediaUrl,
 	)
 	return i, err
 }
 
 const createRefreshToken = `-- name: CreateRefreshToken :one
-INSERT INTO refresh_tokens (token, created_at, updated_at, user_id, expires_at, revoked_at) 
+INSERT INTO refresh_tokens (token, created_at, updated_at, user_id, expires_at, revoked_at, family_id) 
 VALUES (
     $1,
     NOW(),
     NOW(),
     $2,
     $3,
-    NULL
+    NULL,
+    $4
 )
-RETURNING token, created_at, updated_at, user_id, expires_at, revoked_at
+RETURNING token, created_at, updated_at, user_id, expires_at, revoked_at, family_id, replaced_by, id
 `
 
 type CreateRefreshTokenParams struct {
 	Token     string
 	UserID    uuid.UUID
 	ExpiresAt time.Time
+	FamilyID  uuid.UUID
 }
 
 func (q *Queries) CreateRefreshToken(ctx context.Context, arg CreateRefreshTokenParams) (RefreshToken, error) {
-	row := q.db.QueryRowContext(ctx, createRefreshToken, arg.Token, arg.UserID, arg.ExpiresAt)
+	row := q.db.QueryRowContext(ctx, createRefreshToken,
+		arg.Token,
+		arg.UserID,
+		arg.ExpiresAt,
+		arg.FamilyID,
+	)
 	var i RefreshToken
 	err := row.Scan(
 		&i.Token,
@@ -71,23 +184,34 @@ func (q *Queries) CreateRefreshToken(ctx context.Context, arg CreateRefreshToken
 		&i.UserID,
 		&i.ExpiresAt,
 		&i.RevokedAt,
+		&i.FamilyID,
+		&i.ReplacedBy,
+		&i.ID,
 	)
 	return i, err
 }
 
 const createUser = `-- name: CreateUser :one
-INSERT INTO users (id, created_at, updated_at, email)
+INSERT INTO users (id, created_at, updated_at, email, username, display_name)
 VALUES (
     gen_random_uuid(),
     NOW(),
     NOW(),
-    $1
+    $1,
+    $2,
+    $3
 )
-RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red
+RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, email_verified, username, display_name, is_admin, last_login_at
 `
 
-func (q *Queries) CreateUser(ctx context.Context, email string) (User, error) {
-	row := q.db.QueryRowContext(ctx, createUser, email)
+type CreateUserParams struct {
+	Email       string
+	Username    sql.NullString
+	DisplayName sql.NullString
+}
+
+func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, createUser, arg.Email, arg.Username, arg.DisplayName)
 	var i User
 	err := row.Scan(
 		&i.ID,
@@ -96,10 +220,87 @@ func (q *Queries) CreateUser(ctx context.Context, email string) (User, error) {
 		&i.Email,
 		&i.HashedPassword,
 		&i.IsChirpyRed,
+		&i.EmailVerified,
+		&i.Username,
+		&i.DisplayName,
+		&i.IsAdmin,
+		&i.LastLoginAt,
 	)
 	return i, err
 }
 
+const createUserWithOptions = `-- name: CreateUserWithOptions :one
+INSERT INTO users (id, created_at, updated_at, email, hashed_password, is_chirpy_red, email_verified, username, display_name, is_admin)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    NOW(),
+    $1,
+    $2,
+    $3,
+    $4,
+    $5,
+    $6,
+    $7
+)
+RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, email_verified, username, display_name, is_admin, last_login_at
+`
+
+type CreateUserWithOptionsParams struct {
+	Email          string
+	HashedPassword string
+	IsChirpyRed    bool
+	EmailVerified  bool
+	Username       sql.NullString
+	DisplayName    sql.NullString
+	IsAdmin        bool
+}
+
+func (q *Queries) CreateUserWithOptions(ctx context.Context, arg CreateUserWithOptionsParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, createUserWithOptions,
+		arg.Email,
+		arg.HashedPassword,
+		arg.IsChirpyRed,
+		arg.EmailVerified,
+		arg.Username,
+		arg.DisplayName,
+		arg.IsAdmin,
+	)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.IsChirpyRed,
+		&i.EmailVerified,
+		&i.Username,
+		&i.DisplayName,
+		&i.IsAdmin,
+		&i.LastLoginAt,
+	)
+	return i, err
+}
+
+const deleteAllChirps = `-- name: DeleteAllChirps :exec
+DELETE FROM chirps
+`
+
+func (q *Queries) DeleteAllChirps(ctx context.Context) error {
+	_, err := q.db.ExecContext(ctx, deleteAllChirps)
+	return err
+}
+
+const deleteAllRefreshTokens = `-- name: DeleteAllRefreshTokens :exec
+DELETE FROM refresh_tokens
+`
+
+func (q *Queries) DeleteAllRefreshTokens(ctx context.Context) error {
+	_, err := q.db.ExecContext(ctx, deleteAllRefreshTokens)
+	return err
+}
+
 const deleteAllUsers = `-- name: DeleteAllUsers :exec
 DELETE FROM users
 `
@@ -109,18 +310,48 @@ func (q *Queries) DeleteAllUsers(ctx context.Context) error {
 	return err
 }
 
-const deleteChirpByID = `-- name: DeleteChirpByID :exec
-DELETE FROM chirps
+const deleteChirpByID = `-- name: DeleteChirpByID :one
+UPDATE chirps
+SET deleted_at = NOW(),
+    updated_at = NOW()
+WHERE id = $1 AND deleted_at IS NULL
+RETURNING id, created_at, updated_at, body, user_id, parent_chirp_id, creator_ip, deleted_at, raw_body, media_url
+`
+
+func (q *Queries) DeleteChirpByID(ctx context.Context, id uuid.UUID) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, deleteChirpByID, id)
+	var i Chirp
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Body,
+		&i.UserID,
+		&i.ParentChirpID,
+		&i.CreatorIp,
+		&i.DeletedAt,
+		&i.RawBody,
+		&i.MediaUrl,
+	)
+	return i, err
+}
+
+const deleteUserByID = `-- name: DeleteUserByID :execrows
+DELETE FROM users
 WHERE id = $1
 `
 
-func (q *Queries) DeleteChirpByID(ctx context.Context, id uuid.UUID) error {
-	_, err := q.db.ExecContext(ctx, deleteChirpByID, id)
-	return err
+func (q *Queries) DeleteUserByID(ctx context.Context, id uuid.UUID) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteUserByID, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
 }
 
 const getAllChirps = `-- name: GetAllChirps :many
-SELECT id, created_at, updated_at, body, user_id FROM chirps
+SELECT id, created_at, updated_at, body, user_id, parent_chirp_id, creator_ip, deleted_at, raw_body, media_url FROM chirps
+WHERE deleted_at IS NULL
 ORDER BY created_at ASC
 `
 
@@ -139,6 +370,11 @@ func (q *Queries) GetAllChirps(ctx context.Context) ([]Chirp, error) {
 			&i.UpdatedAt,
 			&i.Body,
 			&i.UserID,
+			&i.ParentChirpID,
+			&i.CreatorIp,
+			&i.DeletedAt,
+			&i.RawBody,
+			&i.MediaUrl,
 		); err != nil {
 			return nil, err
 		}
@@ -154,8 +390,8 @@ func (q *Queries) GetAllChirps(ctx context.Context) ([]Chirp, error) {
 }
 
 const getChirpByID = `-- name: GetChirpByID :one
-SELECT id, created_at, updated_at, body, user_id FROM chirps
-WHERE id = $1
+SELECT id, created_at, updated_at, body, user_id, parent_chirp_id, creator_ip, deleted_at, raw_body, media_url FROM chirps
+WHERE id = $1 AND deleted_at IS NULL
 `
 
 func (q *Queries) GetChirpByID(ctx context.Context, id uuid.UUID) (Chirp, error) {
@@ -167,13 +403,94 @@ func (q *Queries) GetChirpByID(ctx context.Context, id uuid.UUID) (Chirp, error)
 		&i.UpdatedAt,
 		&i.Body,
 		&i.UserID,
+		&i.ParentChirpID,
+		&i.CreatorIp,
+		&i.DeletedAt,
+		&i.RawBody,
+		&i.MediaUrl,
+	)
+	return i, err
+}
+
+const getChirpReplies = `-- name: GetChirpReplies :many
+SELECT id, created_at, updated_at, body, user_id, parent_chirp_id, creator_ip, deleted_at, raw_body, media_url FROM chirps
+WHERE parent_chirp_id = $1 AND deleted_at IS NULL
+ORDER BY created_at ASC
+`
+
+func (q *Queries) GetChirpReplies(ctx context.Context, parentChirpID uuid.NullUUID) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, getChirpReplies, parentChirpID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Chirp
+	for rows.Next() {
+		var i Chirp
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.UserID,
+			&i.ParentChirpID,
+			&i.CreatorIp,
+			&i.DeletedAt,
+			&i.RawBody,
+			&i.MediaUrl,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getChirpWithCounts = `-- name: GetChirpWithCounts :one
+SELECT chirps.id, chirps.created_at, chirps.updated_at, chirps.body, chirps.user_id, chirps.parent_chirp_id, chirps.creator_ip, chirps.deleted_at, chirps.raw_body, chirps.media_url,
+    (SELECT COUNT(*) FROM chirp_likes
+     WHERE chirp_likes.chirp_id = chirps.id) AS like_count,
+    (SELECT COUNT(*) FROM chirps AS replies
+     WHERE replies.parent_chirp_id = chirps.id AND replies.deleted_at IS NULL) AS reply_count
+FROM chirps
+WHERE chirps.id = $1 AND chirps.deleted_at IS NULL
+`
+
+type GetChirpWithCountsRow struct {
+	Chirp      Chirp
+	LikeCount  int64
+	ReplyCount int64
+}
+
+func (q *Queries) GetChirpWithCounts(ctx context.Context, id uuid.UUID) (GetChirpWithCountsRow, error) {
+	row := q.db.QueryRowContext(ctx, getChirpWithCounts, id)
+	var i GetChirpWithCountsRow
+	err := row.Scan(
+		&i.Chirp.ID,
+		&i.Chirp.CreatedAt,
+		&i.Chirp.UpdatedAt,
+		&i.Chirp.Body,
+		&i.Chirp.UserID,
+		&i.Chirp.ParentChirpID,
+		&i.Chirp.CreatorIp,
+		&i.Chirp.DeletedAt,
+		&i.Chirp.RawBody,
+		&i.Chirp.MediaUrl,
+		&i.LikeCount,
+		&i.ReplyCount,
 	)
 	return i, err
 }
 
 const getChirpsByUserID = `-- name: GetChirpsByUserID :many
-SELECT id, created_at, updated_at, body, user_id FROM chirps
-WHERE user_id = $1
+SELECT id, created_at, updated_at, body, user_id, parent_chirp_id, creator_ip, deleted_at, raw_body, media_url FROM chirps
+WHERE user_id = $1 AND deleted_at IS NULL
 ORDER BY created_at ASC
 `
 
@@ -192,6 +509,169 @@ func (q *Queries) GetChirpsByUserID(ctx context.Context, userID uuid.UUID) ([]Ch
 			&i.UpdatedAt,
 			&i.Body,
 			&i.UserID,
+			&i.ParentChirpID,
+			&i.CreatorIp,
+			&i.DeletedAt,
+			&i.RawBody,
+			&i.MediaUrl,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getDailyChirpCounts = `-- name: GetDailyChirpCounts :many
+SELECT date_trunc('day', created_at)::date AS day, COUNT(*) AS count
+FROM chirps
+WHERE user_id = $1 AND deleted_at IS NULL AND created_at >= $2
+GROUP BY day
+ORDER BY day
+`
+
+type GetDailyChirpCountsParams struct {
+	UserID uuid.UUID
+	Since  time.Time
+}
+
+type GetDailyChirpCountsRow struct {
+	Day   time.Time
+	Count int64
+}
+
+func (q *Queries) GetDailyChirpCounts(ctx context.Context, arg GetDailyChirpCountsParams) ([]GetDailyChirpCountsRow, error) {
+	rows, err := q.db.QueryContext(ctx, getDailyChirpCounts, arg.UserID, arg.Since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetDailyChirpCountsRow
+	for rows.Next() {
+		var i GetDailyChirpCountsRow
+		if err := rows.Scan(&i.Day, &i.Count); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getRandomChirp = `-- name: GetRandomChirp :one
+SELECT id, created_at, updated_at, body, user_id, parent_chirp_id, creator_ip, deleted_at, raw_body, media_url FROM chirps
+WHERE deleted_at IS NULL
+  AND ($1::uuid IS NULL OR user_id = $1)
+ORDER BY RANDOM()
+LIMIT 1
+`
+
+func (q *Queries) GetRandomChirp(ctx context.Context, authorID uuid.NullUUID) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, getRandomChirp, authorID)
+	var i Chirp
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Body,
+		&i.UserID,
+		&i.ParentChirpID,
+		&i.CreatorIp,
+		&i.DeletedAt,
+		&i.RawBody,
+		&i.MediaUrl,
+	)
+	return i, err
+}
+
+const getRecentChirps = `-- name: GetRecentChirps :many
+SELECT id, created_at, updated_at, body, user_id, parent_chirp_id, creator_ip, deleted_at, raw_body, media_url FROM chirps
+WHERE deleted_at IS NULL
+ORDER BY created_at DESC
+LIMIT $1
+`
+
+func (q *Queries) GetRecentChirps(ctx context.Context, limit int32) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, getRecentChirps, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Chirp
+	for rows.Next() {
+		var i Chirp
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.UserID,
+			&i.ParentChirpID,
+			&i.CreatorIp,
+			&i.DeletedAt,
+			&i.RawBody,
+			&i.MediaUrl,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getRecentChirpsWithAuthors = `-- name: GetRecentChirpsWithAuthors :many
+SELECT chirps.id, chirps.created_at, chirps.updated_at, chirps.body, chirps.user_id, chirps.parent_chirp_id, chirps.creator_ip, chirps.deleted_at, chirps.raw_body, chirps.media_url, users.username, users.display_name FROM chirps
+JOIN users ON users.id = chirps.user_id
+WHERE chirps.deleted_at IS NULL
+ORDER BY chirps.created_at DESC
+LIMIT $1
+`
+
+type GetRecentChirpsWithAuthorsRow struct {
+	Chirp       Chirp
+	Username    sql.NullString
+	DisplayName sql.NullString
+}
+
+func (q *Queries) GetRecentChirpsWithAuthors(ctx context.Context, limit int32) ([]GetRecentChirpsWithAuthorsRow, error) {
+	rows, err := q.db.QueryContext(ctx, getRecentChirpsWithAuthors, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetRecentChirpsWithAuthorsRow
+	for rows.Next() {
+		var i GetRecentChirpsWithAuthorsRow
+		if err := rows.Scan(
+			&i.Chirp.ID,
+			&i.Chirp.CreatedAt,
+			&i.Chirp.UpdatedAt,
+			&i.Chirp.Body,
+			&i.Chirp.UserID,
+			&i.Chirp.ParentChirpID,
+			&i.Chirp.CreatorIp,
+			&i.Chirp.DeletedAt,
+			&i.Chirp.RawBody,
+			&i.Chirp.MediaUrl,
+			&i.Username,
+			&i.DisplayName,
 		); err != nil {
 			return nil, err
 		}
@@ -207,7 +687,7 @@ func (q *Queries) GetChirpsByUserID(ctx context.Context, userID uuid.UUID) ([]Ch
 }
 
 const getRefreshTokenByToken = `-- name: GetRefreshTokenByToken :one
-SELECT token, created_at, updated_at, user_id, expires_at, revoked_at FROM refresh_tokens
+SELECT token, created_at, updated_at, user_id, expires_at, revoked_at, family_id, replaced_by, id FROM refresh_tokens
 WHERE token = $1
 `
 
@@ -221,13 +701,86 @@ func (q *Queries) GetRefreshTokenByToken(ctx context.Context, token string) (Ref
 		&i.UserID,
 		&i.ExpiresAt,
 		&i.RevokedAt,
+		&i.FamilyID,
+		&i.ReplacedBy,
+		&i.ID,
+	)
+	return i, err
+}
+
+const getRefreshTokenSuccessor = `-- name: GetRefreshTokenSuccessor :one
+SELECT successor.token, successor.created_at, successor.updated_at, successor.user_id, successor.expires_at, successor.revoked_at, successor.family_id, successor.replaced_by, successor.id FROM refresh_tokens AS rotated
+JOIN refresh_tokens AS successor ON successor.token = rotated.replaced_by
+WHERE rotated.token = $1
+  AND rotated.revoked_at > NOW() - make_interval(secs => $2::float8)
+  AND successor.revoked_at IS NULL
+  AND successor.expires_at > NOW()
+`
+
+type GetRefreshTokenSuccessorParams struct {
+	Token        string
+	GraceSeconds float64
+}
+
+func (q *Queries) GetRefreshTokenSuccessor(ctx context.Context, arg GetRefreshTokenSuccessorParams) (RefreshToken, error) {
+	row := q.db.QueryRowContext(ctx, getRefreshTokenSuccessor, arg.Token, arg.GraceSeconds)
+	var i RefreshToken
+	err := row.Scan(
+		&i.Token,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.UserID,
+		&i.ExpiresAt,
+		&i.RevokedAt,
+		&i.FamilyID,
+		&i.ReplacedBy,
+		&i.ID,
+	)
+	return i, err
+}
+
+const getRefreshTokenWithUser = `-- name: GetRefreshTokenWithUser :one
+SELECT refresh_tokens.token, refresh_tokens.created_at, refresh_tokens.updated_at, refresh_tokens.user_id, refresh_tokens.expires_at, refresh_tokens.revoked_at, refresh_tokens.family_id, refresh_tokens.replaced_by, refresh_tokens.id, users.id, users.created_at, users.updated_at, users.email, users.hashed_password, users.is_chirpy_red, users.email_verified, users.username, users.display_name, users.is_admin, users.last_login_at FROM refresh_tokens
+JOIN users ON users.id = refresh_tokens.user_id
+WHERE refresh_tokens.token = $1
+`
+
+type GetRefreshTokenWithUserRow struct {
+	RefreshToken RefreshToken
+	User         User
+}
+
+func (q *Queries) GetRefreshTokenWithUser(ctx context.Context, token string) (GetRefreshTokenWithUserRow, error) {
+	row := q.db.QueryRowContext(ctx, getRefreshTokenWithUser, token)
+	var i GetRefreshTokenWithUserRow
+	err := row.Scan(
+		&i.RefreshToken.Token,
+		&i.RefreshToken.CreatedAt,
+		&i.RefreshToken.UpdatedAt,
+		&i.RefreshToken.UserID,
+		&i.RefreshToken.ExpiresAt,
+		&i.RefreshToken.RevokedAt,
+		&i.RefreshToken.FamilyID,
+		&i.RefreshToken.ReplacedBy,
+		&i.RefreshToken.ID,
+		&i.User.ID,
+		&i.User.CreatedAt,
+		&i.User.UpdatedAt,
+		&i.User.Email,
+		&i.User.HashedPassword,
+		&i.User.IsChirpyRed,
+		&i.User.EmailVerified,
+		&i.User.Username,
+		&i.User.DisplayName,
+		&i.User.IsAdmin,
+		&i.User.LastLoginAt,
 	)
 	return i, err
 }
 
 const getUserByEmail = `-- name: GetUserByEmail :one
-SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red FROM users
-WHERE email = $1
+SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red, email_verified, username, display_name, is_admin, last_login_at FROM users
+WHERE LOWER(email) = LOWER($1)
 `
 
 func (q *Queries) GetUserByEmail(ctx context.Context, email string) (User, error) {
@@ -240,22 +793,457 @@ func (q *Queries) GetUserByEmail(ctx context.Context, email string) (User, error
 		&i.Email,
 		&i.HashedPassword,
 		&i.IsChirpyRed,
+		&i.EmailVerified,
+		&i.Username,
+		&i.DisplayName,
+		&i.IsAdmin,
+		&i.LastLoginAt,
+	)
+	return i, err
+}
+
+const getUserByID = `-- name: GetUserByID :one
+SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red, email_verified, username, display_name, is_admin, last_login_at FROM users
+WHERE id = $1
+`
+
+func (q *Queries) GetUserByID(ctx context.Context, id uuid.UUID) (User, error) {
+	row := q.db.QueryRowContext(ctx, getUserByID, id)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.IsChirpyRed,
+		&i.EmailVerified,
+		&i.Username,
+		&i.DisplayName,
+		&i.IsAdmin,
+		&i.LastLoginAt,
+	)
+	return i, err
+}
+
+const getUserByUsername = `-- name: GetUserByUsername :one
+SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red, email_verified, username, display_name, is_admin, last_login_at FROM users
+WHERE LOWER(username) = LOWER($1)
+`
+
+func (q *Queries) GetUserByUsername(ctx context.Context, username string) (User, error) {
+	row := q.db.QueryRowContext(ctx, getUserByUsername, username)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.IsChirpyRed,
+		&i.EmailVerified,
+		&i.Username,
+		&i.DisplayName,
+		&i.IsAdmin,
+		&i.LastLoginAt,
 	)
 	return i, err
 }
 
-const revokeRefreshToken = `-- name: RevokeRefreshToken :exec
+const getUserChirpStats = `-- name: GetUserChirpStats :one
+SELECT COUNT(*) AS chirp_count,
+    (SELECT COUNT(*) FROM chirp_likes
+     JOIN chirps AS liked ON liked.id = chirp_likes.chirp_id
+     WHERE liked.user_id = $1 AND liked.deleted_at IS NULL) AS total_likes_received,
+    MIN(created_at) AS first_chirp_at,
+    MAX(created_at) AS last_chirp_at
+FROM chirps
+WHERE user_id = $1 AND deleted_at IS NULL
+`
+
+type GetUserChirpStatsRow struct {
+	ChirpCount         int64
+	TotalLikesReceived int64
+	FirstChirpAt       sql.NullTime
+	LastChirpAt        sql.NullTime
+}
+
+func (q *Queries) GetUserChirpStats(ctx context.Context, userID uuid.UUID) (GetUserChirpStatsRow, error) {
+	row := q.db.QueryRowContext(ctx, getUserChirpStats, userID)
+	var i GetUserChirpStatsRow
+	err := row.Scan(
+		&i.ChirpCount,
+		&i.TotalLikesReceived,
+		&i.FirstChirpAt,
+		&i.LastChirpAt,
+	)
+	return i, err
+}
+
+const listActiveRefreshTokensByUserID = `-- name: ListActiveRefreshTokensByUserID :many
+SELECT token, created_at, updated_at, user_id, expires_at, revoked_at, family_id, replaced_by, id FROM refresh_tokens
+WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > NOW()
+ORDER BY created_at DESC
+`
+
+func (q *Queries) ListActiveRefreshTokensByUserID(ctx context.Context, userID uuid.UUID) ([]RefreshToken, error) {
+	rows, err := q.db.QueryContext(ctx, listActiveRefreshTokensByUserID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []RefreshToken
+	for rows.Next() {
+		var i RefreshToken
+		if err := rows.Scan(
+			&i.Token,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.UserID,
+			&i.ExpiresAt,
+			&i.RevokedAt,
+			&i.FamilyID,
+			&i.ReplacedBy,
+			&i.ID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listChirps = `-- name: ListChirps :many
+SELECT chirps.id, chirps.created_at, chirps.updated_at, chirps.body, chirps.user_id, chirps.parent_chirp_id, chirps.creator_ip, chirps.deleted_at, chirps.raw_body, chirps.media_url,
+    (SELECT COUNT(*) FROM chirp_likes
+     WHERE chirp_likes.chirp_id = chirps.id) AS like_count
+FROM chirps
+WHERE ($1::boolean OR deleted_at IS NULL)
+  AND ($2::uuid IS NULL OR user_id = $2)
+  AND ($3::text IS NULL OR body ILIKE '%' || $3 || '%')
+  AND ($4::text IS NULL OR id IN (
+    SELECT chirp_id FROM chirp_hashtags
+    WHERE hashtag = $4
+  ))
+  AND created_at BETWEEN COALESCE($5::timestamp, '-infinity')
+                     AND COALESCE($6::timestamp, 'infinity')
+  AND (NOT $7::boolean OR raw_body IS NULL)
+  AND char_length(body) >= $8::int
+ORDER BY CASE WHEN $9::boolean THEN created_at END DESC,
+    created_at ASC, id ASC
+LIMIT $10 OFFSET $11
+`
+
+type ListChirpsParams struct {
+	IncludeDeleted bool
+	AuthorID       uuid.NullUUID
+	Query          sql.NullString
+	Hashtag        sql.NullString
+	CreatedAfter   sql.NullTime
+	CreatedBefore  sql.NullTime
+	Clean          bool
+	MinLength      int32
+	NewestFirst    bool
+	Limit          sql.NullInt32
+	Offset         int32
+}
+
+type ListChirpsRow struct {
+	Chirp     Chirp
+	LikeCount int64
+}
+
+func (q *Queries) ListChirps(ctx context.Context, arg ListChirpsParams) ([]ListChirpsRow, error) {
+	rows, err := q.db.QueryContext(ctx, listChirps,
+		arg.IncludeDeleted,
+		arg.AuthorID,
+		arg.Query,
+		arg.Hashtag,
+		arg.CreatedAfter,
+		arg.CreatedBefore,
+		arg.Clean,
+		arg.MinLength,
+		arg.NewestFirst,
+		arg.Limit,
+		arg.Offset,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListChirpsRow
+	for rows.Next() {
+		var i ListChirpsRow
+		if err := rows.Scan(
+			&i.Chirp.ID,
+			&i.Chirp.CreatedAt,
+			&i.Chirp.UpdatedAt,
+			&i.Chirp.Body,
+			&i.Chirp.UserID,
+			&i.Chirp.ParentChirpID,
+			&i.Chirp.CreatorIp,
+			&i.Chirp.DeletedAt,
+			&i.Chirp.RawBody,
+			&i.Chirp.MediaUrl,
+			&i.LikeCount,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listChirpsAfter = `-- name: ListChirpsAfter :many
+SELECT id, created_at, updated_at, body, user_id, parent_chirp_id, creator_ip, deleted_at, raw_body, media_url FROM chirps
+WHERE deleted_at IS NULL
+  AND ($1::uuid IS NULL OR user_id = $1)
+  AND (created_at, id) > ($2::timestamp, $3::uuid)
+ORDER BY created_at ASC, id ASC
+LIMIT $4
+`
+
+type ListChirpsAfterParams struct {
+	AuthorID       uuid.NullUUID
+	AfterCreatedAt time.Time
+	AfterID        uuid.UUID
+	Limit          int32
+}
+
+func (q *Queries) ListChirpsAfter(ctx context.Context, arg ListChirpsAfterParams) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, listChirpsAfter,
+		arg.AuthorID,
+		arg.AfterCreatedAt,
+		arg.AfterID,
+		arg.Limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Chirp
+	for rows.Next() {
+		var i Chirp
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.UserID,
+			&i.ParentChirpID,
+			&i.CreatorIp,
+			&i.DeletedAt,
+			&i.RawBody,
+			&i.MediaUrl,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listChirpsWithAuthors = `-- name: ListChirpsWithAuthors :many
+SELECT chirps.id, chirps.created_at, chirps.updated_at, chirps.body, chirps.user_id, chirps.parent_chirp_id, chirps.creator_ip, chirps.deleted_at, chirps.raw_body, chirps.media_url, users.email, users.is_chirpy_red, users.username, users.display_name,
+    (SELECT COUNT(*) FROM chirp_likes
+     WHERE chirp_likes.chirp_id = chirps.id) AS like_count
+FROM chirps
+JOIN users ON users.id = chirps.user_id
+WHERE ($1::boolean OR chirps.deleted_at IS NULL)
+  AND ($2::uuid IS NULL OR chirps.user_id = $2)
+  AND ($3::text IS NULL OR chirps.body ILIKE '%' || $3 || '%')
+  AND ($4::text IS NULL OR chirps.id IN (
+    SELECT chirp_id FROM chirp_hashtags
+    WHERE hashtag = $4
+  ))
+  AND chirps.created_at BETWEEN COALESCE($5::timestamp, '-infinity')
+                            AND COALESCE($6::timestamp, 'infinity')
+  AND (NOT $7::boolean OR chirps.raw_body IS NULL)
+  AND char_length(chirps.body) >= $8::int
+ORDER BY CASE WHEN $9::boolean THEN chirps.created_at END DESC,
+    chirps.created_at ASC, chirps.id ASC
+LIMIT $10 OFFSET $11
+`
+
+type ListChirpsWithAuthorsParams struct {
+	IncludeDeleted bool
+	AuthorID       uuid.NullUUID
+	Query          sql.NullString
+	Hashtag        sql.NullString
+	CreatedAfter   sql.NullTime
+	CreatedBefore  sql.NullTime
+	Clean          bool
+	MinLength      int32
+	NewestFirst    bool
+	Limit          sql.NullInt32
+	Offset         int32
+}
+
+type ListChirpsWithAuthorsRow struct {
+	Chirp       Chirp
+	Email       string
+	IsChirpyRed bool
+	Username    sql.NullString
+	DisplayName sql.NullString
+	LikeCount   int64
+}
+
+func (q *Queries) ListChirpsWithAuthors(ctx context.Context, arg ListChirpsWithAuthorsParams) ([]ListChirpsWithAuthorsRow, error) {
+	rows, err := q.db.QueryContext(ctx, listChirpsWithAuthors,
+		arg.IncludeDeleted,
+		arg.AuthorID,
+		arg.Query,
+		arg.Hashtag,
+		arg.CreatedAfter,
+		arg.CreatedBefore,
+		arg.Clean,
+		arg.MinLength,
+		arg.NewestFirst,
+		arg.Limit,
+		arg.Offset,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListChirpsWithAuthorsRow
+	for rows.Next() {
+		var i ListChirpsWithAuthorsRow
+		if err := rows.Scan(
+			&i.Chirp.ID,
+			&i.Chirp.CreatedAt,
+			&i.Chirp.UpdatedAt,
+			&i.Chirp.Body,
+			&i.Chirp.UserID,
+			&i.Chirp.ParentChirpID,
+			&i.Chirp.CreatorIp,
+			&i.Chirp.DeletedAt,
+			&i.Chirp.RawBody,
+			&i.Chirp.MediaUrl,
+			&i.Email,
+			&i.IsChirpyRed,
+			&i.Username,
+			&i.DisplayName,
+			&i.LikeCount,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const revokeAllUserRefreshTokens = `-- name: RevokeAllUserRefreshTokens :exec
 UPDATE refresh_tokens
 SET revoked_at = NOW(),
     updated_at = NOW()
+WHERE user_id = $1 AND revoked_at IS NULL
+`
+
+func (q *Queries) RevokeAllUserRefreshTokens(ctx context.Context, userID uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, revokeAllUserRefreshTokens, userID)
+	return err
+}
+
+const revokeRefreshToken = `-- name: RevokeRefreshToken :execrows
+UPDATE refresh_tokens
+SET revoked_at = COALESCE(revoked_at, NOW()),
+    updated_at = NOW()
 WHERE token = $1
 `
 
-func (q *Queries) RevokeRefreshToken(ctx context.Context, token string) error {
-	_, err := q.db.ExecContext(ctx, revokeRefreshToken, token)
+func (q *Queries) RevokeRefreshToken(ctx context.Context, token string) (int64, error) {
+	result, err := q.db.ExecContext(ctx, revokeRefreshToken, token)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const revokeRefreshTokenByID = `-- name: RevokeRefreshTokenByID :execrows
+UPDATE refresh_tokens
+SET revoked_at = NOW(),
+    updated_at = NOW()
+WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL
+`
+
+type RevokeRefreshTokenByIDParams struct {
+	ID     uuid.UUID
+	UserID uuid.UUID
+}
+
+func (q *Queries) RevokeRefreshTokenByID(ctx context.Context, arg RevokeRefreshTokenByIDParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, revokeRefreshTokenByID, arg.ID, arg.UserID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const revokeRefreshTokenFamily = `-- name: RevokeRefreshTokenFamily :exec
+UPDATE refresh_tokens
+SET revoked_at = NOW(),
+    updated_at = NOW()
+WHERE family_id = $1 AND revoked_at IS NULL
+`
+
+func (q *Queries) RevokeRefreshTokenFamily(ctx context.Context, familyID uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, revokeRefreshTokenFamily, familyID)
 	return err
 }
 
+const rotateRefreshToken = `-- name: RotateRefreshToken :execrows
+WITH rotated AS (
+    UPDATE refresh_tokens
+    SET revoked_at = NOW(),
+        replaced_by = $1::text,
+        updated_at = NOW()
+    WHERE token = $2 AND revoked_at IS NULL
+    RETURNING user_id, family_id
+)
+INSERT INTO refresh_tokens (token, created_at, updated_at, user_id, expires_at, revoked_at, family_id)
+SELECT $1::text, NOW(), NOW(), user_id, $3::timestamp, NULL, family_id
+FROM rotated
+`
+
+type RotateRefreshTokenParams struct {
+	NewToken  string
+	Token     string
+	ExpiresAt time.Time
+}
+
+func (q *Queries) RotateRefreshToken(ctx context.Context, arg RotateRefreshTokenParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, rotateRefreshToken, arg.NewToken, arg.Token, arg.ExpiresAt)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
 const setChirpyRedByID = `-- name: SetChirpyRedByID :exec
 UPDATE users 
 SET is_chirpy_red = TRUE,
@@ -268,6 +1256,33 @@ func (q *Queries) SetChirpyRedByID(ctx context.Context, id uuid.UUID) error {
 	return err
 }
 
+const setEmailVerifiedByUserID = `-- name: SetEmailVerifiedByUserID :one
+UPDATE users
+SET email_verified = TRUE,
+    updated_at = NOW()
+WHERE id = $1
+RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, email_verified, username, display_name, is_admin, last_login_at
+`
+
+func (q *Queries) SetEmailVerifiedByUserID(ctx context.Context, id uuid.UUID) (User, error) {
+	row := q.db.QueryRowContext(ctx, setEmailVerifiedByUserID, id)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.IsChirpyRed,
+		&i.EmailVerified,
+		&i.Username,
+		&i.DisplayName,
+		&i.IsAdmin,
+		&i.LastLoginAt,
+	)
+	return i, err
+}
+
 const setPassword = `-- name: SetPassword :exec
 UPDATE users
 SET hashed_password = $1
@@ -284,18 +1299,62 @@ func (q *Queries) SetPassword(ctx context.Context, arg SetPasswordParams) error
 	return err
 }
 
+const setPasswordByUserID = `-- name: SetPasswordByUserID :exec
+UPDATE users
+SET hashed_password = $1,
+    updated_at = NOW()
+WHERE id = $2
+`
+
+type SetPasswordByUserIDParams struct {
+	HashedPassword string
+	ID             uuid.UUID
+}
+
+func (q *Queries) SetPasswordByUserID(ctx context.Context, arg SetPasswordByUserIDParams) error {
+	_, err := q.db.ExecContext(ctx, setPasswordByUserID, arg.HashedPassword, arg.ID)
+	return err
+}
+
+const unsetChirpyRedByID = `-- name: UnsetChirpyRedByID :exec
+UPDATE users
+SET is_chirpy_red = FALSE,
+    updated_at = NOW()
+WHERE id = $1
+`
+
+func (q *Queries) UnsetChirpyRedByID(ctx context.Context, id uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, unsetChirpyRedByID, id)
+	return err
+}
+
+const updateLastLogin = `-- name: UpdateLastLogin :exec
+UPDATE users
+SET last_login_at = NOW()
+WHERE id = $1
+`
+
+func (q *Queries) UpdateLastLogin(ctx context.Context, id uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, updateLastLogin, id)
+	return err
+}
+
 const updateUserCredentials = `-- name: UpdateUserCredentials :one
 UPDATE users
-SET email = $1,
-    hashed_password = $2,
+SET email = COALESCE($1, email),
+    email_verified = CASE
+        WHEN $1 IS NULL OR $1 = email THEN email_verified
+        ELSE false
+    END,
+    hashed_password = COALESCE($2, hashed_password),
     updated_at = NOW()
 WHERE id = $3
-RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red
+RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, email_verified, username, display_name, is_admin, last_login_at
 `
 
 type UpdateUserCredentialsParams struct {
-	Email          string
-	HashedPassword string
+	Email          sql.NullString
+	HashedPassword sql.NullString
 	ID             uuid.UUID
 }
 
@@ -309,6 +1368,45 @@ func (q *Queries) UpdateUserCredentials(ctx context.Context, arg UpdateUserCrede
 		&i.Email,
 		&i.HashedPassword,
 		&i.IsChirpyRed,
+		&i.EmailVerified,
+		&i.Username,
+		&i.DisplayName,
+		&i.IsAdmin,
+		&i.LastLoginAt,
+	)
+	return i, err
+}
+
+const updateUserProfile = `-- name: UpdateUserProfile :one
+UPDATE users
+SET username = COALESCE($1, username),
+    display_name = COALESCE($2, display_name),
+    updated_at = NOW()
+WHERE id = $3
+RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, email_verified, username, display_name, is_admin, last_login_at
+`
+
+type UpdateUserProfileParams struct {
+	Username    sql.NullString
+	DisplayName sql.NullString
+	ID          uuid.UUID
+}
+
+func (q *Queries) UpdateUserProfile(ctx context.Context, arg UpdateUserProfileParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, updateUserProfile, arg.Username, arg.DisplayName, arg.ID)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.IsChirpyRed,
+		&i.EmailVerified,
+		&i.Username,
+		&i.DisplayName,
+		&i.IsAdmin,
+		&i.LastLoginAt,
 	)
 	return i, err
 }
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"github.com/WOsaka/chirpy-server/internal/database"
+	"github.com/google/uuid"
+)
+
+// attachLikeCounts fills in LikeCount for each chirp with a single aggregate
+// query.
+func (cfg *apiConfig) attachLikeCounts(ctx context.Context, chirps []Chirp) error {
+	if len(chirps) == 0 {
+		return nil
+	}
+
+	ids := make([]uuid.UUID, len(chirps))
+	for i, chirp := range chirps {
+		ids[i] = chirp.ID
+	}
+
+	rows, err := cfg.db.GetLikeCountsForChirps(ctx, ids)
+	if err != nil {
+		return err
+	}
+
+	counts := make(map[uuid.UUID]int64, len(rows))
+	for _, row := range rows {
+		counts[row.ChirpID] = row.LikeCount
+	}
+	for i := range chirps {
+		chirps[i].LikeCount = counts[chirps[i].ID]
+	}
+	return nil
+}
+
+func (cfg *apiConfig) likeChirpHandler(w http.ResponseWriter, r *http.Request) {
+	cfg.setChirpLike(w, r, true)
+}
+
+func (cfg *apiConfig) unlikeChirpHandler(w http.ResponseWriter, r *http.Request) {
+	cfg.setChirpLike(w, r, false)
+}
+
+// setChirpLike likes or unlikes the chirp in the path for the authenticated
+// user. Both directions are idempotent.
+func (cfg *apiConfig) setChirpLike(w http.ResponseWriter, r *http.Request, liked bool) {
+	userID := userIDFromContext(r)
+
+	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, r, http.StatusBadRequest, codeValidationError, "Invalid chirp ID")
+		return
+	}
+
+	if liked {
+		if _, err := cfg.db.GetChirpByID(r.Context(), chirpID); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				respondWithError(w, r, http.StatusNotFound, codeNotFound, "Chirp not found")
+				return
+			}
+			requestLogger(r).Error("Error fetching chirp", "user_id", userID, "error", err)
+			respondWithError(w, r, http.StatusInternalServerError, codeInternal, "Failed to fetch chirp")
+			return
+		}
+
+		if err := cfg.db.LikeChirp(r.Context(), database.LikeChirpParams{
+			UserID:  userID,
+			ChirpID: chirpID,
+		}); err != nil {
+			requestLogger(r).Error("Error liking chirp", "user_id", userID, "error", err)
+			respondWithError(w, r, http.StatusInternalServerError, codeInternal, "Failed to like chirp")
+			return
+		}
+	} else {
+		if err := cfg.db.UnlikeChirp(r.Context(), database.UnlikeChirpParams{
+			UserID:  userID,
+			ChirpID: chirpID,
+		}); err != nil {
+			requestLogger(r).Error("Error unliking chirp", "user_id", userID, "error", err)
+			respondWithError(w, r, http.StatusInternalServerError, codeInternal, "Failed to unlike chirp")
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/WOsaka/chirpy-server/internal/auth"
+	"github.com/WOsaka/chirpy-server/internal/database"
+	"github.com/google/uuid"
+)
+
+func (db *chirpsDB) LikeChirp(ctx context.Context, arg database.LikeChirpParams) error {
+	if db.likes == nil {
+		db.likes = map[uuid.UUID]map[uuid.UUID]bool{}
+	}
+	if db.likes[arg.ChirpID] == nil {
+		db.likes[arg.ChirpID] = map[uuid.UUID]bool{}
+	}
+	db.likes[arg.ChirpID][arg.UserID] = true
+	return nil
+}
+
+func (db *chirpsDB) UnlikeChirp(ctx context.Context, arg database.UnlikeChirpParams) error {
+	delete(db.likes[arg.ChirpID], arg.UserID)
+	return nil
+}
+
+func newTestJWT(t *testing.T, cfg *apiConfig, userID uuid.UUID) string {
+	t.Helper()
+	token, err := cfg.jwtKeys.MakeJWT(userID, time.Hour)
+	if err != nil {
+		t.Fatalf("MakeJWT failed: %v", err)
+	}
+	return token
+}
+
+func TestLikeChirp(t *testing.T) {
+	chirpID := uuid.New()
+	db := &chirpsDB{chirps: map[uuid.UUID]database.Chirp{chirpID: {ID: chirpID, Body: "like me"}}}
+	cfg := &apiConfig{db: db, jwtKeys: auth.NewHS256Keys("secret")}
+	token := newTestJWT(t, cfg, uuid.New())
+
+	setLike := func(method string, id uuid.UUID) int {
+		req := httptest.NewRequest(method, "/api/chirps/"+id.String()+"/like", nil)
+		req.SetPathValue("chirpID", id.String())
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		if method == http.MethodPost {
+			cfg.authMiddleware(cfg.likeChirpHandler).ServeHTTP(rec, req)
+		} else {
+			cfg.authMiddleware(cfg.unlikeChirpHandler).ServeHTTP(rec, req)
+		}
+		return rec.Code
+	}
+	likeCount := func() int64 {
+		rec := getChirp(cfg, chirpID)
+		var chirp Chirp
+		if err := json.NewDecoder(rec.Body).Decode(&chirp); err != nil {
+			t.Fatalf("decoding response: %v", err)
+		}
+		return chirp.LikeCount
+	}
+
+	if code := setLike(http.MethodPost, chirpID); code != http.StatusNoContent {
+		t.Fatalf("like: got status %d, want %d", code, http.StatusNoContent)
+	}
+	if got := likeCount(); got != 1 {
+		t.Errorf("like_count after like = %d; want 1", got)
+	}
+
+	if code := setLike(http.MethodPost, chirpID); code != http.StatusNoContent {
+		t.Fatalf("double like: got status %d, want %d", code, http.StatusNoContent)
+	}
+	if got := likeCount(); got != 1 {
+		t.Errorf("like_count after double like = %d; want 1", got)
+	}
+
+	if code := setLike(http.MethodDelete, chirpID); code != http.StatusNoContent {
+		t.Fatalf("unlike: got status %d, want %d", code, http.StatusNoContent)
+	}
+	if got := likeCount(); got != 0 {
+		t.Errorf("like_count after unlike = %d; want 0", got)
+	}
+
+	if code := setLike(http.MethodPost, uuid.New()); code != http.StatusNotFound {
+		t.Errorf("like unknown chirp: got status %d, want %d", code, http.StatusNotFound)
+	}
+
+	// Service clients like with an API key instead.
+	keyUserID := uuid.New()
+	cfg.db = apiKeyChirpsDB{chirpsDB: db, keys: &apiKeysDB{keys: map[uuid.UUID]database.ApiKey{
+		uuid.New(): {UserID: keyUserID, KeyHash: auth.HashAPIKey("service-key")},
+	}}}
+	req := httptest.NewRequest(http.MethodPost, "/api/chirps/"+chirpID.String()+"/like", nil)
+	req.SetPathValue("chirpID", chirpID.String())
+	req.Header.Set("Authorization", "ApiKey service-key")
+	rec := httptest.NewRecorder()
+	cfg.authMiddleware(cfg.likeChirpHandler).ServeHTTP(rec, req)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("like with API key: got status %d, want %d", rec.Code, http.StatusNoContent)
+	}
+	if !db.likes[chirpID][keyUserID] {
+		t.Error("like with API key was not recorded for the key's user")
+	}
+}
+
+// apiKeyChirpsDB lets chirpsDB tests authenticate with API keys.
+type apiKeyChirpsDB struct {
+	*chirpsDB
+	keys *apiKeysDB
+}
+
+func (db apiKeyChirpsDB) GetAPIKeyByHash(ctx context.Context, keyHash string) (database.ApiKey, error) {
+	return db.keys.GetAPIKeyByHash(ctx, keyHash)
+}
@@ -0,0 +1,17 @@
+package main
+
+import "log/slog"
+
+// mailer delivers transactional emails such as password reset links.
+type mailer interface {
+	Send(to, subject, body string) error
+}
+
+// logMailer is the default mailer. It writes messages to the server log
+// instead of delivering them, which is enough for local development.
+type logMailer struct{}
+
+func (logMailer) Send(to, subject, body string) error {
+	slog.Info("Email not delivered, logging instead", "to", to, "subject", subject, "body", body)
+	return nil
+}
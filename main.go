@@ -1,58 +1,398 @@
 package main
 
 import (
+	"context"
 	"database/sql"
-	"fmt"
+	"log/slog"
 	"net/http"
 	"os"
+	"path/filepath"
+	"strconv"
+	"time"
 
+	"github.com/WOsaka/chirpy-server/internal/auth"
 	"github.com/WOsaka/chirpy-server/internal/database"
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
 )
 
+// version identifies the build and is set at link time, e.g.
+// go build -ldflags "-X main.version=$(git rev-parse --short HEAD)".
+var version = "dev"
+
 func main() {
+	startedAt := time.Now()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stdout, nil)))
+
 	godotenv.Load()
 	dbURL := os.Getenv("DB_URL")
 	db, err := sql.Open("postgres", dbURL)
 	if err != nil {
-		fmt.Println("Error connecting to the database:", err)
+		slog.Error("Error connecting to the database", "error", err)
+		return
+	}
+
+	maxOpenConns := 25
+	if v := os.Getenv("DB_MAX_OPEN_CONNS"); v != "" {
+		maxOpenConns, err = strconv.Atoi(v)
+		if err != nil || maxOpenConns < 1 {
+			slog.Error("Invalid DB_MAX_OPEN_CONNS value", "value", v)
+			return
+		}
+	}
+
+	maxIdleConns := 10
+	if v := os.Getenv("DB_MAX_IDLE_CONNS"); v != "" {
+		maxIdleConns, err = strconv.Atoi(v)
+		if err != nil || maxIdleConns < 0 {
+			slog.Error("Invalid DB_MAX_IDLE_CONNS value", "value", v)
+			return
+		}
+	}
+	// database/sql caps idle connections at the open limit; clamp here so
+	// the log below shows the effective value.
+	maxIdleConns = min(maxIdleConns, maxOpenConns)
+
+	connMaxLifetime := 30 * time.Minute
+	if v := os.Getenv("DB_CONN_MAX_LIFETIME"); v != "" {
+		connMaxLifetime, err = time.ParseDuration(v)
+		if err != nil || connMaxLifetime < 0 {
+			slog.Error("Invalid DB_CONN_MAX_LIFETIME value", "value", v)
+			return
+		}
+	}
+
+	db.SetMaxOpenConns(maxOpenConns)
+	db.SetMaxIdleConns(maxIdleConns)
+	db.SetConnMaxLifetime(connMaxLifetime)
+	slog.Info("Database pool configured",
+		"max_open_conns", maxOpenConns,
+		"max_idle_conns", maxIdleConns,
+		"conn_max_lifetime", connMaxLifetime.String())
+
+	// sql.Open doesn't connect, so check DB_URL now rather than on the first
+	// request.
+	pingCtx, cancelPing := context.WithTimeout(context.Background(), 5*time.Second)
+	err = db.PingContext(pingCtx)
+	cancelPing()
+	if err != nil {
+		slog.Error("Error connecting to the database", "error", err)
+		return
+	}
+
+	registrationOpen := true
+	if v := os.Getenv("REGISTRATION_OPEN"); v != "" {
+		registrationOpen, err = strconv.ParseBool(v)
+		if err != nil {
+			slog.Error("Invalid REGISTRATION_OPEN value", "error", err)
+			return
+		}
+	}
+
+	passwordMinLength := 8
+	if v := os.Getenv("PASSWORD_MIN_LENGTH"); v != "" {
+		passwordMinLength, err = strconv.Atoi(v)
+		if err != nil || passwordMinLength < 1 {
+			slog.Error("Invalid PASSWORD_MIN_LENGTH value", "value", v)
+			return
+		}
+	}
+
+	maxChirpLength := 140
+	if v := os.Getenv("MAX_CHIRP_LENGTH"); v != "" {
+		maxChirpLength, err = strconv.Atoi(v)
+		if err != nil || maxChirpLength < 1 {
+			slog.Error("Invalid MAX_CHIRP_LENGTH value", "value", v)
+			return
+		}
+	}
+
+	storeChirpIPs := false
+	if v := os.Getenv("STORE_CHIRP_IPS"); v != "" {
+		storeChirpIPs, err = strconv.ParseBool(v)
+		if err != nil {
+			slog.Error("Invalid STORE_CHIRP_IPS value", "error", err)
+			return
+		}
+	}
+
+	trustedProxies, err := parseTrustedProxies(os.Getenv("TRUSTED_PROXIES"))
+	if err != nil {
+		slog.Error("Invalid TRUSTED_PROXIES value", "error", err)
+		return
+	}
+
+	var maxBodyBytes int64 = defaultMaxBodyBytes
+	if v := os.Getenv("MAX_BODY_BYTES"); v != "" {
+		maxBodyBytes, err = strconv.ParseInt(v, 10, 64)
+		if err != nil || maxBodyBytes < 1 {
+			slog.Error("Invalid MAX_BODY_BYTES value", "value", v)
+			return
+		}
+	}
+
+	requireVerifiedEmail := false
+	if v := os.Getenv("REQUIRE_VERIFIED_EMAIL"); v != "" {
+		requireVerifiedEmail, err = strconv.ParseBool(v)
+		if err != nil {
+			slog.Error("Invalid REQUIRE_VERIFIED_EMAIL value", "error", err)
+			return
+		}
+	}
+
+	verifyPolkaSignature := false
+	if v := os.Getenv("POLKA_VERIFY_SIGNATURE"); v != "" {
+		verifyPolkaSignature, err = strconv.ParseBool(v)
+		if err != nil {
+			slog.Error("Invalid POLKA_VERIFY_SIGNATURE value", "error", err)
+			return
+		}
+	}
+
+	bcryptCost := auth.DefaultBcryptCost
+	if v := os.Getenv("BCRYPT_COST"); v != "" {
+		bcryptCost, err = strconv.Atoi(v)
+		if err != nil {
+			slog.Error("Invalid BCRYPT_COST value", "value", v)
+			return
+		}
+		if err := auth.ValidateBcryptCost(bcryptCost); err != nil {
+			slog.Error("Invalid BCRYPT_COST value", "error", err)
+			return
+		}
+	}
+
+	profaneWords, err := loadProfaneWords(os.Getenv("PROFANE_WORDS"), os.Getenv("PROFANE_WORDS_FILE"), os.Getenv("PROFANE_WORDS_MODE"))
+	if err != nil {
+		slog.Error("Invalid profane word configuration", "error", err)
+		return
+	}
+
+	// Only this directory is served under /app/, so it must not be the
+	// working directory holding the source and .env.
+	fileserverRoot := "public"
+	if v := os.Getenv("FILESERVER_ROOT"); v != "" {
+		fileserverRoot = v
+	}
+	fileserverRoot, err = filepath.Abs(fileserverRoot)
+	if err != nil {
+		slog.Error("Invalid FILESERVER_ROOT value", "error", err)
+		return
+	}
+	if info, err := os.Stat(fileserverRoot); err != nil || !info.IsDir() {
+		slog.Error("FILESERVER_ROOT is not a directory", "path", fileserverRoot, "error", err)
+		return
+	}
+	slog.Info("Serving static files", "path", fileserverRoot)
+
+	listenAddr, err := parseListenAddr(os.Getenv("LISTEN_ADDR"), os.Getenv("HOST"), os.Getenv("PORT"))
+	if err != nil {
+		slog.Error("Invalid listen address", "error", err)
+		return
+	}
+
+	requestTimeout := defaultRequestTimeout
+	if v := os.Getenv("REQUEST_TIMEOUT"); v != "" {
+		requestTimeout, err = time.ParseDuration(v)
+		if err != nil || requestTimeout <= 0 {
+			slog.Error("Invalid REQUEST_TIMEOUT value", "value", v)
+			return
+		}
+	}
+
+	metricsFlushInterval := defaultMetricsFlushInterval
+	if v := os.Getenv("METRICS_FLUSH_INTERVAL"); v != "" {
+		metricsFlushInterval, err = time.ParseDuration(v)
+		if err != nil || metricsFlushInterval <= 0 {
+			slog.Error("Invalid METRICS_FLUSH_INTERVAL value", "value", v)
+			return
+		}
+	}
+
+	webhookRetryInterval := defaultWebhookRetryInterval
+	if v := os.Getenv("WEBHOOK_RETRY_INTERVAL"); v != "" {
+		webhookRetryInterval, err = time.ParseDuration(v)
+		if err != nil || webhookRetryInterval <= 0 {
+			slog.Error("Invalid WEBHOOK_RETRY_INTERVAL value", "value", v)
+			return
+		}
+	}
+
+	refreshTokenTTL := defaultRefreshTokenTTL
+	if v := os.Getenv("REFRESH_TOKEN_TTL"); v != "" {
+		refreshTokenTTL, err = time.ParseDuration(v)
+		if err != nil || refreshTokenTTL <= 0 {
+			slog.Error("Invalid REFRESH_TOKEN_TTL value", "value", v)
+			return
+		}
+	}
+
+	refreshTokenGrace := defaultRefreshTokenGrace
+	if v := os.Getenv("REFRESH_TOKEN_GRACE"); v != "" {
+		refreshTokenGrace, err = time.ParseDuration(v)
+		if err != nil || refreshTokenGrace < 0 {
+			slog.Error("Invalid REFRESH_TOKEN_GRACE value", "value", v)
+			return
+		}
+	}
+
+	var jwtKeys auth.JWTKeys
+	switch alg := os.Getenv("JWT_ALGORITHM"); alg {
+	case "", auth.AlgorithmHS256:
+		jwtKeys = auth.NewHS256Keys(os.Getenv("JWT_SECRET"))
+	case auth.AlgorithmRS256:
+		jwtKeys, err = auth.LoadRS256Keys(os.Getenv("JWT_PRIVATE_KEY_PATH"), os.Getenv("JWT_PUBLIC_KEY_PATH"))
+		if err != nil {
+			slog.Error("Error loading RS256 keys", "error", err)
+			return
+		}
+	default:
+		slog.Error("Unsupported JWT_ALGORITHM", "value", alg)
 		return
 	}
 
+	defaultRateLimit := rateLimit{requests: 120, window: time.Minute}
+	if v := os.Getenv("RATE_LIMIT_DEFAULT"); v != "" {
+		defaultRateLimit, err = parseRateLimit(v)
+		if err != nil {
+			slog.Error("Invalid RATE_LIMIT_DEFAULT value", "error", err)
+			return
+		}
+	}
+	routeRateLimits := map[string]rateLimit{
+		"POST /api/login": {requests: 10, window: time.Minute},
+	}
+	if v := os.Getenv("RATE_LIMITS"); v != "" {
+		overrides, err := parseRouteRateLimits(v)
+		if err != nil {
+			slog.Error("Invalid RATE_LIMITS value", "error", err)
+			return
+		}
+		for route, limit := range overrides {
+			routeRateLimits[route] = limit
+		}
+	}
+	limiter := newRateLimiter(defaultRateLimit, routeRateLimits)
+	limiter.trustedProxies = trustedProxies
+
+	chirpRateLimit := rateLimit{requests: 10, window: time.Minute}
+	if v := os.Getenv("CHIRP_RATE_LIMIT"); v != "" {
+		chirpRateLimit, err = parseRateLimit(v)
+		if err != nil {
+			slog.Error("Invalid CHIRP_RATE_LIMIT value", "error", err)
+			return
+		}
+	}
+
 	cfg := &apiConfig{
 		db: database.New(db),
+		sqlDB: db,
 		platform: os.Getenv("PLATFORM"),
-		jwtSecret: os.Getenv("JWT_SECRET"),
+		jwtKeys: jwtKeys,
 		polkaKey: os.Getenv("POLKA_KEY"),
+		mailer: logMailer{},
+		maxChirpLength: maxChirpLength,
+		registrationOpen: registrationOpen,
+		passwordMinLength: passwordMinLength,
+		storeChirpIPs: storeChirpIPs,
+		trustedProxies: trustedProxies,
+		maxBodyBytes: maxBodyBytes,
+		verifyPolkaSignature: verifyPolkaSignature,
+		bcryptCost: bcryptCost,
+		profaneWords: profaneWords,
+		profaneMask: os.Getenv("PROFANE_MASK"),
+		requireVerifiedEmail: requireVerifiedEmail,
+		chirpLimiter: newSlidingWindowLimiter(chirpRateLimit),
+		startedAt: startedAt,
+		refreshTokenTTL: refreshTokenTTL,
+		refreshTokenGrace: refreshTokenGrace,
+		authCookieName: os.Getenv("AUTH_COOKIE_NAME"),
 	}
 
+	if cfg.platform == "dev" {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if _, err := checkIndexes(ctx, cfg.db); err != nil {
+			slog.Warn("Error checking database indexes", "error", err)
+		}
+		cancel()
+	}
+
+	// A failed load only loses the old total; flushes still add new hits.
+	if err := cfg.loadFileserverHits(context.Background()); err != nil {
+		slog.Warn("Error loading fileserver hits", "error", err)
+	}
+	go cfg.flushFileserverHitsEvery(context.Background(), metricsFlushInterval)
+	go cfg.retryWebhookEventsEvery(context.Background(), webhookRetryInterval)
+
+	metrics := newHTTPMetrics()
+
 	mux := http.NewServeMux()
 	mux.Handle(
 		"/app/",
 		http.StripPrefix("/app",
-			cfg.middlewareMetricsInc(http.FileServer(http.Dir(".")))))
+			cfg.middlewareMetricsInc(http.FileServer(http.Dir(fileserverRoot)))))
 	mux.HandleFunc("GET /api/healthz", healthCheckHandler)
-	mux.HandleFunc("GET /admin/metrics", cfg.metricsHandler)
-	mux.HandleFunc("POST /admin/reset", cfg.resetHandler)
-	mux.HandleFunc("POST /api/chirps", cfg.createChirpHandler)
+	mux.HandleFunc("GET /api/readyz", cfg.readinessHandler)
+	mux.HandleFunc("GET /api/version", cfg.versionHandler)
+	mux.HandleFunc("GET /api/config", cfg.publicConfigHandler)
+	mux.Handle("GET /admin/metrics", cfg.adminMiddleware(cfg.metricsHandler))
+	mux.Handle("GET /metrics", metrics.handler())
+	mux.Handle("POST /admin/reset", cfg.adminMiddleware(cfg.resetHandler))
+	mux.Handle("POST /admin/users", cfg.adminMiddleware(cfg.adminCreateUserHandler))
+	mux.Handle("POST /admin/chirps/purge", cfg.adminMiddleware(cfg.purgeDeletedChirpsHandler))
+	mux.Handle("POST /api/chirps", cfg.authMiddleware(cfg.createChirpHandler))
 	mux.HandleFunc("POST /api/users", cfg.createUserHandler)
+	mux.Handle("POST /api/users/verify/request", cfg.authMiddleware(cfg.requestEmailVerificationHandler))
+	mux.Handle("POST /api/users/verify/confirm", cfg.authMiddleware(cfg.confirmEmailVerificationHandler))
 	mux.HandleFunc("GET /api/chirps", cfg.getChirpsHandler)
+	mux.HandleFunc("GET /api/chirps.csv", cfg.exportChirpsCSVHandler)
+	mux.Handle("GET /api/chirps/mine", cfg.authMiddleware(cfg.getMyChirpsHandler))
+	mux.HandleFunc("GET /api/chirps/recent", cfg.getRecentChirpsHandler)
+	mux.HandleFunc("GET /api/chirps/count", cfg.getChirpCountHandler)
+	mux.HandleFunc("GET /api/chirps/random", cfg.getRandomChirpHandler)
+	mux.HandleFunc("GET /api/trending", cfg.getTrendingHashtagsHandler)
 	mux.HandleFunc("GET /api/chirps/{chirpID}", cfg.getChirpHandler)
+	mux.HandleFunc("GET /api/chirps/{chirpID}/replies", cfg.getChirpRepliesHandler)
+	mux.Handle("POST /api/chirps/{chirpID}/like", cfg.authMiddleware(cfg.likeChirpHandler))
+	mux.Handle("DELETE /api/chirps/{chirpID}/like", cfg.authMiddleware(cfg.unlikeChirpHandler))
 	mux.HandleFunc("POST /api/login", cfg.loginHandler)
 	mux.HandleFunc("POST /api/refresh", cfg.refreshTokenHandler)
 	mux.HandleFunc("POST /api/revoke", cfg.revokeRefreshTokenHandler)
-	mux.HandleFunc("PUT /api/users", cfg.updateCredentialsHandler)
-	mux.HandleFunc("DELETE /api/chirps/{chirpID}", cfg.deleteChirpHandler)
+	mux.Handle("POST /api/logout-all", cfg.jwtAuthMiddleware(cfg.logoutAllHandler))
+	mux.Handle("POST /api/apikeys", cfg.jwtAuthMiddleware(cfg.createAPIKeyHandler))
+	mux.Handle("GET /api/apikeys", cfg.jwtAuthMiddleware(cfg.getAPIKeysHandler))
+	mux.Handle("DELETE /api/apikeys/{keyID}", cfg.jwtAuthMiddleware(cfg.revokeAPIKeyHandler))
+	mux.Handle("GET /api/sessions", cfg.authMiddleware(cfg.getSessionsHandler))
+	mux.Handle("DELETE /api/sessions/{tokenID}", cfg.authMiddleware(cfg.revokeSessionHandler))
+	mux.Handle("GET /api/users/me", cfg.authMiddleware(cfg.getCurrentUserHandler))
+	mux.Handle("PUT /api/users", cfg.jwtAuthMiddleware(cfg.updateCredentialsHandler))
+	mux.Handle("DELETE /api/users", cfg.jwtAuthMiddleware(cfg.deleteUserHandler))
+	mux.Handle("POST /api/me/email", cfg.jwtAuthMiddleware(cfg.requestEmailChangeHandler))
+	mux.Handle("POST /api/me/email/confirm", cfg.jwtAuthMiddleware(cfg.confirmEmailChangeHandler))
+	mux.Handle("PUT /api/me/profile", cfg.authMiddleware(cfg.updateProfileHandler))
+	mux.HandleFunc("GET /api/users/{userID}/activity", cfg.getUserActivityHandler)
+	mux.HandleFunc("GET /api/users/{userID}/stats", cfg.getUserStatsHandler)
+	mux.Handle("POST /api/users/{userID}/follow", cfg.authMiddleware(cfg.followUserHandler))
+	mux.Handle("DELETE /api/users/{userID}/follow", cfg.authMiddleware(cfg.unfollowUserHandler))
+	mux.Handle("GET /api/feed", cfg.authMiddleware(cfg.getFeedHandler))
+	mux.HandleFunc("GET /api/feed.json", cfg.getJSONFeedHandler)
+	mux.HandleFunc("GET /api/feed.rss", cfg.getRSSFeedHandler)
+	mux.Handle("GET /api/mentions", cfg.authMiddleware(cfg.getMentionsHandler))
+	mux.Handle("GET /api/me/mentions", cfg.authMiddleware(cfg.getMentionsHandler))
+	mux.Handle("DELETE /api/chirps/{chirpID}", cfg.authMiddleware(cfg.deleteChirpHandler))
 	mux.HandleFunc("POST /api/polka/webhooks", cfg.setChirpyRedHandler)
+	mux.Handle("POST /api/admin/users/{userID}/chirpy-red", cfg.adminMiddleware(cfg.adminSetChirpyRedHandler))
+	mux.Handle("DELETE /api/admin/users/{userID}/chirpy-red", cfg.adminMiddleware(cfg.adminUnsetChirpyRedHandler))
+	mux.HandleFunc("POST /api/password-reset", cfg.requestPasswordResetHandler)
+	mux.HandleFunc("POST /api/password-reset/confirm", cfg.confirmPasswordResetHandler)
 
 	server := &http.Server{
-		Handler: mux,
-		Addr:    ":8080",
+		Handler: serverHandler(mux, metrics, limiter, requestTimeout),
+		Addr:    listenAddr,
 	}
 
-	fmt.Println("Server listening on http://localhost:8080")
+	slog.Info("Server listening", "addr", server.Addr)
 	if err := server.ListenAndServe(); err != nil {
-		fmt.Println("Server error:", err)
+		slog.Error("Server error", "error", err)
 	}
 }
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/WOsaka/chirpy-server/internal/database"
+)
+
+const (
+	defaultMentionsLimit = 20
+	maxMentionsLimit     = 100
+)
+
+// mentionPattern matches an '@' that doesn't follow a letter, digit,
+// underscore or another '@', so email addresses aren't mentions, followed
+// by a run of handle characters. Runs too long to be a username are dropped
+// by extractMentions rather than truncated.
+var mentionPattern = regexp.MustCompile(`(?:^|[^\p{L}\p{N}_@])@([A-Za-z0-9_]+)`)
+
+// extractMentions returns the distinct usernames mentioned in body,
+// lowercased and without the leading '@', in order of first appearance.
+func extractMentions(body string) []string {
+	seen := map[string]bool{}
+	var mentions []string
+	for _, match := range mentionPattern.FindAllStringSubmatch(body, -1) {
+		username := strings.ToLower(match[1])
+		if seen[username] || !usernamePattern.MatchString(username) {
+			continue
+		}
+		seen[username] = true
+		mentions = append(mentions, username)
+	}
+	return mentions
+}
+
+// saveMentions records a mention for every user @-mentioned in chirp.
+// Handles that don't belong to anyone are ignored. Failures are logged
+// rather than returned, as for hashtags: the chirp itself is already saved.
+func (cfg *apiConfig) saveMentions(r *http.Request, chirp database.Chirp) {
+	for _, username := range extractMentions(chirp.Body) {
+		mentioned, err := cfg.db.GetUserByUsername(r.Context(), username)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			requestLogger(r).Error("Error resolving mention", "user_id", chirp.UserID, "username", username, "error", err)
+			continue
+		}
+		if err := cfg.db.AddChirpMention(r.Context(), database.AddChirpMentionParams{
+			ChirpID: chirp.ID,
+			UserID:  mentioned.ID,
+		}); err != nil {
+			requestLogger(r).Error("Error saving mention", "user_id", chirp.UserID, "mentioned_user_id", mentioned.ID, "error", err)
+		}
+	}
+}
+
+// getMentionsHandler lists chirps mentioning the authenticated user, newest
+// first. limit defaults to defaultMentionsLimit and is capped at
+// maxMentionsLimit; offset skips that many chirps for paging. since, an
+// RFC3339 timestamp, keeps only mentions made after it, so clients can
+// fetch just what's new since they last looked.
+func (cfg *apiConfig) getMentionsHandler(w http.ResponseWriter, r *http.Request) {
+	userID := userIDFromContext(r)
+
+	var since sql.NullTime
+	if v := r.URL.Query().Get("since"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			respondWithError(w, r, http.StatusBadRequest, codeValidationError, "Invalid since, expected an RFC3339 timestamp")
+			return
+		}
+		since = sql.NullTime{Time: t.UTC(), Valid: true}
+	}
+
+	limit := defaultMentionsLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		parsed, err := strconv.Atoi(v)
+		if err != nil || parsed < 1 {
+			respondWithError(w, r, http.StatusBadRequest, codeValidationError, "Invalid limit")
+			return
+		}
+		limit = min(parsed, maxMentionsLimit)
+	}
+
+	offset := 0
+	if v := r.URL.Query().Get("offset"); v != "" {
+		parsed, err := strconv.Atoi(v)
+		if err != nil || parsed < 0 {
+			respondWithError(w, r, http.StatusBadRequest, codeValidationError, "Invalid offset")
+			return
+		}
+		offset = parsed
+	}
+
+	dbChirps, err := cfg.db.GetMentionChirps(r.Context(), database.GetMentionChirpsParams{
+		UserID: userID,
+		Since:  since,
+		Limit:  int32(limit),
+		Offset: int32(offset),
+	})
+	if err != nil {
+		requestLogger(r).Error("Error fetching mentions", "user_id", userID, "error", err)
+		respondWithError(w, r, http.StatusInternalServerError, codeInternal, "Failed to fetch mentions")
+		return
+	}
+
+	chirps := []Chirp{}
+	for _, dbChirp := range dbChirps {
+		chirps = append(chirps, newChirp(dbChirp))
+	}
+
+	if err := cfg.attachLikeCounts(r.Context(), chirps); err != nil {
+		requestLogger(r).Error("Error fetching like counts", "user_id", userID, "error", err)
+		respondWithError(w, r, http.StatusInternalServerError, codeInternal, "Failed to fetch mentions")
+		return
+	}
+
+	if err := respondWithJSON(w, http.StatusOK, chirps); err != nil {
+		requestLogger(r).Error("Error responding with JSON", "error", err)
+		return
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/WOsaka/chirpy-server/internal/auth"
+	"github.com/WOsaka/chirpy-server/internal/database"
+	"github.com/google/uuid"
+)
+
+// GetUserByUsername resolves handles against the stub's authors.
+func (db *chirpsDB) GetUserByUsername(ctx context.Context, username string) (database.User, error) {
+	for _, user := range db.authors {
+		if user.Username.Valid && strings.EqualFold(user.Username.String, username) {
+			return user, nil
+		}
+	}
+	return database.User{}, sql.ErrNoRows
+}
+
+func (db *chirpsDB) AddChirpMention(ctx context.Context, arg database.AddChirpMentionParams) error {
+	for _, m := range db.mentions {
+		if m.ChirpID == arg.ChirpID && m.UserID == arg.UserID {
+			return nil
+		}
+	}
+	db.mentions = append(db.mentions, database.ChirpMention{
+		ChirpID:   arg.ChirpID,
+		UserID:    arg.UserID,
+		CreatedAt: time.Now(),
+	})
+	return nil
+}
+
+// GetMentionChirps mimics the JOIN on chirp_mentions, keeping mentions made
+// after Since, newest first.
+func (db *chirpsDB) GetMentionChirps(ctx context.Context, arg database.GetMentionChirpsParams) ([]database.Chirp, error) {
+	var chirps []database.Chirp
+	for _, m := range db.mentions {
+		if arg.Since.Valid && !m.CreatedAt.After(arg.Since.Time) {
+			continue
+		}
+		chirp, ok := db.chirps[m.ChirpID]
+		if m.UserID == arg.UserID && ok && !chirp.DeletedAt.Valid {
+			chirps = append(chirps, chirp)
+		}
+	}
+	sort.Slice(chirps, func(i, j int) bool {
+		return chirps[i].CreatedAt.After(chirps[j].CreatedAt)
+	})
+	start := min(int(arg.Offset), len(chirps))
+	end := min(start+int(arg.Limit), len(chirps))
+	return chirps[start:end], nil
+}
+
+func TestExtractMentions(t *testing.T) {
+	tests := []struct {
+		body     string
+		expected []string
+	}{
+		{"no mentions here", nil},
+		{"hi @alice", []string{"alice"}},
+		{"@Alice and @alice and @bob_99!", []string{"alice", "bob_99"}},
+		{"(@carol), @dave.", []string{"carol", "dave"}},
+		{"mail bob@example.com or @@eve", nil},
+		{"@" + strings.Repeat("a", 31), nil},
+		{"@ spaced @-dash", nil},
+	}
+
+	for _, test := range tests {
+		result := extractMentions(test.body)
+		if !reflect.DeepEqual(result, test.expected) {
+			t.Errorf("extractMentions(%q) = %q; want %q", test.body, result, test.expected)
+		}
+	}
+}
+
+func TestMentions(t *testing.T) {
+	alice := database.User{ID: uuid.New(), Username: sql.NullString{String: "Alice", Valid: true}}
+	bob := database.User{ID: uuid.New(), Username: sql.NullString{String: "bob", Valid: true}}
+	db := &chirpsDB{
+		chirps:  map[uuid.UUID]database.Chirp{},
+		authors: map[uuid.UUID]database.User{alice.ID: alice, bob.ID: bob},
+	}
+	cfg := &apiConfig{db: db, jwtKeys: auth.NewHS256Keys("secret"), maxChirpLength: 140}
+
+	for _, body := range []string{
+		`{"body": "hello @alice and @nobody"}`,
+		`{"body": "@bob only"}`,
+		`{"body": "@ALICE @alice twice"}`,
+	} {
+		if rec := postChirp(t, cfg, bob.ID, body); rec.Code != http.StatusCreated {
+			t.Fatalf("creating chirp %s: got status %d", body, rec.Code)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	mentioned := map[uuid.UUID]int{}
+	for _, m := range db.mentions {
+		mentioned[m.UserID]++
+	}
+	if len(mentioned) != 2 || mentioned[alice.ID] != 2 || mentioned[bob.ID] != 1 {
+		t.Errorf("mentions per user = %v; want alice twice and bob once, unknown handles ignored", mentioned)
+	}
+
+	getMentions := func(userID uuid.UUID, query string) []Chirp {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/api/mentions"+query, nil)
+		req.Header.Set("Authorization", "Bearer "+newTestJWT(t, cfg, userID))
+		rec := httptest.NewRecorder()
+		cfg.authMiddleware(cfg.getMentionsHandler).ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET /api/mentions%s: got status %d, want %d", query, rec.Code, http.StatusOK)
+		}
+		var chirps []Chirp
+		if err := json.NewDecoder(rec.Body).Decode(&chirps); err != nil {
+			t.Fatalf("decoding response: %v", err)
+		}
+		return chirps
+	}
+
+	chirps := getMentions(alice.ID, "")
+	if len(chirps) != 2 || chirps[0].Body != "@ALICE @alice twice" || chirps[1].Body != "hello @alice and @nobody" {
+		t.Errorf("alice's mentions = %+v; want both chirps, newest first", chirps)
+	}
+	if chirps := getMentions(alice.ID, "?limit=1&offset=1"); len(chirps) != 1 || chirps[0].Body != "hello @alice and @nobody" {
+		t.Errorf("alice's second page = %+v; want the older chirp", chirps)
+	}
+	if chirps := getMentions(uuid.New(), ""); len(chirps) != 0 {
+		t.Errorf("unmentioned user got %d chirps; want none", len(chirps))
+	}
+
+	for _, query := range []string{"?limit=0", "?since=yesterday"} {
+		req := httptest.NewRequest(http.MethodGet, "/api/mentions"+query, nil)
+		req.Header.Set("Authorization", "Bearer "+newTestJWT(t, cfg, alice.ID))
+		rec := httptest.NewRecorder()
+		cfg.authMiddleware(cfg.getMentionsHandler).ServeHTTP(rec, req)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: got status %d, want %d", query, rec.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestMentionsSince(t *testing.T) {
+	alice := database.User{ID: uuid.New(), Username: sql.NullString{String: "alice", Valid: true}}
+	db := &chirpsDB{
+		chirps:  map[uuid.UUID]database.Chirp{},
+		authors: map[uuid.UUID]database.User{alice.ID: alice},
+	}
+	cfg := &apiConfig{db: db, jwtKeys: auth.NewHS256Keys("secret"), maxChirpLength: 140}
+	checkpoint := time.Date(2024, time.June, 1, 12, 0, 0, 0, time.UTC)
+	for i, mentionedAt := range []time.Time{
+		checkpoint.Add(-time.Hour),
+		checkpoint,
+		checkpoint.Add(time.Minute),
+		checkpoint.Add(time.Hour),
+	} {
+		id := uuid.New()
+		db.chirps[id] = database.Chirp{ID: id, Body: fmt.Sprintf("@alice %d", i), CreatedAt: mentionedAt}
+		db.mentions = append(db.mentions, database.ChirpMention{ChirpID: id, UserID: alice.ID, CreatedAt: mentionedAt})
+	}
+
+	get := func(query string) []Chirp {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/api/me/mentions?"+query, nil)
+		req.Header.Set("Authorization", "Bearer "+newTestJWT(t, cfg, alice.ID))
+		rec := httptest.NewRecorder()
+		cfg.authMiddleware(cfg.getMentionsHandler).ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: got status %d, want %d", query, rec.Code, http.StatusOK)
+		}
+		var chirps []Chirp
+		if err := json.NewDecoder(rec.Body).Decode(&chirps); err != nil {
+			t.Fatalf("decoding response: %v", err)
+		}
+		return chirps
+	}
+
+	since := "since=" + url.QueryEscape(checkpoint.Format(time.RFC3339))
+	chirps := get(since)
+	if len(chirps) != 2 || chirps[0].Body != "@alice 3" || chirps[1].Body != "@alice 2" {
+		t.Errorf("mentions since the checkpoint = %+v; want only the two later ones, newest first", chirps)
+	}
+	if chirps := get(since + "&limit=1&offset=1"); len(chirps) != 1 || chirps[0].Body != "@alice 2" {
+		t.Errorf("second page since the checkpoint = %+v; want the older new mention", chirps)
+	}
+	if chirps := get("since=" + url.QueryEscape(checkpoint.Add(2*time.Hour).Format(time.RFC3339))); len(chirps) != 0 {
+		t.Errorf("mentions since after the newest = %+v; want none", chirps)
+	}
+	if chirps := get(""); len(chirps) != 4 {
+		t.Errorf("mentions without since = %d; want all 4", len(chirps))
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/WOsaka/chirpy-server/internal/auth"
+	"github.com/WOsaka/chirpy-server/internal/database"
+)
+
+const passwordResetTokenTTL = time.Hour
+
+func (cfg *apiConfig) requestPasswordResetHandler(w http.ResponseWriter, r *http.Request) {
+	var params struct {
+		Email string `json:"email"`
+	}
+
+	if err := cfg.decodeJSON(w, r, &params); err != nil {
+		requestLogger(r).Warn("Error decoding parameters", "error", err)
+		respondWithError(w, r, http.StatusBadRequest, codeBadRequest, err.Error())
+		return
+	}
+
+	if params.Email == "" {
+		respondWithError(w, r, http.StatusBadRequest, codeValidationError, "Email is required")
+		return
+	}
+
+	// The response is identical whether or not the email is registered so
+	// that this endpoint can't be used to enumerate accounts.
+	var payload struct {
+		Message string `json:"message"`
+	}
+	payload.Message = "If the email is registered, a password reset link has been sent"
+
+	dbUser, err := cfg.db.GetUserByEmail(r.Context(), normalizeEmail(params.Email))
+	if errors.Is(err, sql.ErrNoRows) {
+		if err := respondWithJSON(w, http.StatusOK, payload); err != nil {
+			requestLogger(r).Error("Error responding with JSON", "error", err)
+		}
+		return
+	}
+	if err != nil {
+		requestLogger(r).Error("Error fetching user", "error", err)
+		respondWithError(w, r, http.StatusInternalServerError, codeInternal, "Failed to request password reset")
+		return
+	}
+
+	resetToken, err := auth.MakeRefreshToken()
+	if err != nil {
+		requestLogger(r).Error("Error creating reset token", "error", err)
+		respondWithError(w, r, http.StatusInternalServerError, codeInternal, "Failed to create reset token")
+		return
+	}
+
+	_, err = cfg.db.CreatePasswordResetToken(r.Context(), database.CreatePasswordResetTokenParams{
+		Token:     resetToken,
+		UserID:    dbUser.ID,
+		ExpiresAt: time.Now().Add(passwordResetTokenTTL),
+	})
+	if err != nil {
+		requestLogger(r).Error("Error creating reset token in database", "error", err)
+		respondWithError(w, r, http.StatusInternalServerError, codeInternal, "Failed to create reset token")
+		return
+	}
+
+	body := fmt.Sprintf("Use this token to reset your Chirpy password: %s\nIt expires in %s.", resetToken, passwordResetTokenTTL)
+	if err := cfg.mailer.Send(dbUser.Email, "Reset your Chirpy password", body); err != nil {
+		requestLogger(r).Error("Error sending password reset email", "error", err)
+		respondWithError(w, r, http.StatusInternalServerError, codeInternal, "Failed to send password reset email")
+		return
+	}
+
+	if err := respondWithJSON(w, http.StatusOK, payload); err != nil {
+		requestLogger(r).Error("Error responding with JSON", "error", err)
+		return
+	}
+}
+
+func (cfg *apiConfig) confirmPasswordResetHandler(w http.ResponseWriter, r *http.Request) {
+	var params struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+
+	if err := cfg.decodeJSON(w, r, &params); err != nil {
+		requestLogger(r).Warn("Error decoding parameters", "error", err)
+		respondWithError(w, r, http.StatusBadRequest, codeBadRequest, err.Error())
+		return
+	}
+
+	if params.Token == "" || params.Password == "" {
+		respondWithError(w, r, http.StatusBadRequest, codeValidationError, "Token and password are required")
+		return
+	}
+
+	if err := validatePassword(params.Password, cfg.passwordMinLength); err != nil {
+		respondWithError(w, r, http.StatusBadRequest, codeValidationError, err.Error())
+		return
+	}
+
+	dbToken, err := cfg.db.GetPasswordResetToken(r.Context(), params.Token)
+	if err != nil {
+		requestLogger(r).Warn("Error fetching reset token", "error", err)
+		respondWithError(w, r, http.StatusUnauthorized, codeInvalidToken, "Invalid reset token")
+		return
+	}
+
+	if dbToken.UsedAt.Valid {
+		requestLogger(r).Warn("Reset token already used", "user_id", dbToken.UserID)
+		respondWithError(w, r, http.StatusUnauthorized, codeInvalidToken, "Reset token already used")
+		return
+	}
+
+	if dbToken.ExpiresAt.Before(time.Now()) {
+		requestLogger(r).Warn("Reset token expired", "user_id", dbToken.UserID)
+		respondWithError(w, r, http.StatusUnauthorized, codeTokenExpired, "Reset token expired")
+		return
+	}
+
+	hashedPassword, err := cfg.hashPassword(params.Password)
+	if err != nil {
+		requestLogger(r).Error("Error hashing password", "error", err)
+		respondWithError(w, r, http.StatusInternalServerError, codeInternal, "Failed to hash password")
+		return
+	}
+
+	// Claim the token before touching the password so two concurrent
+	// confirmations can't both succeed.
+	claimed, err := cfg.db.MarkPasswordResetTokenUsed(r.Context(), dbToken.Token)
+	if err != nil {
+		requestLogger(r).Error("Error marking reset token used", "error", err)
+		respondWithError(w, r, http.StatusInternalServerError, codeInternal, "Failed to reset password")
+		return
+	}
+	if claimed == 0 {
+		respondWithError(w, r, http.StatusUnauthorized, codeInvalidToken, "Reset token already used")
+		return
+	}
+
+	if err := cfg.db.SetPasswordByUserID(r.Context(), database.SetPasswordByUserIDParams{
+		HashedPassword: hashedPassword,
+		ID:             dbToken.UserID,
+	}); err != nil {
+		requestLogger(r).Error("Error setting password", "error", err)
+		respondWithError(w, r, http.StatusInternalServerError, codeInternal, "Failed to set password")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/WOsaka/chirpy-server/internal/database"
+	"github.com/google/uuid"
+)
+
+type passwordResetDB struct {
+	database.Querier
+	tokens    map[string]database.PasswordResetToken
+	passwords map[uuid.UUID]string
+}
+
+func (db *passwordResetDB) GetPasswordResetToken(ctx context.Context, token string) (database.PasswordResetToken, error) {
+	t, ok := db.tokens[token]
+	if !ok {
+		return database.PasswordResetToken{}, sql.ErrNoRows
+	}
+	return t, nil
+}
+
+func (db *passwordResetDB) MarkPasswordResetTokenUsed(ctx context.Context, token string) (int64, error) {
+	t, ok := db.tokens[token]
+	if !ok || t.UsedAt.Valid {
+		return 0, nil
+	}
+	t.UsedAt = sql.NullTime{Time: time.Now(), Valid: true}
+	db.tokens[token] = t
+	return 1, nil
+}
+
+func (db *passwordResetDB) SetPasswordByUserID(ctx context.Context, arg database.SetPasswordByUserIDParams) error {
+	db.passwords[arg.ID] = arg.HashedPassword
+	return nil
+}
+
+func (db *passwordResetDB) GetUserByEmail(ctx context.Context, email string) (database.User, error) {
+	return database.User{}, sql.ErrNoRows
+}
+
+func confirmPasswordReset(cfg *apiConfig, token string) *httptest.ResponseRecorder {
+	body := `{"token": "` + token + `", "password": "newPassword123"}`
+	req := newJSONRequest(http.MethodPost, "/api/password-reset/confirm", body)
+	rec := httptest.NewRecorder()
+	cfg.confirmPasswordResetHandler(rec, req)
+	return rec
+}
+
+func TestConfirmPasswordReset(t *testing.T) {
+	userID := uuid.New()
+	db := &passwordResetDB{
+		tokens: map[string]database.PasswordResetToken{
+			"valid":   {Token: "valid", UserID: userID, ExpiresAt: time.Now().Add(time.Hour)},
+			"expired": {Token: "expired", UserID: userID, ExpiresAt: time.Now().Add(-time.Minute)},
+			"used": {
+				Token:     "used",
+				UserID:    userID,
+				ExpiresAt: time.Now().Add(time.Hour),
+				UsedAt:    sql.NullTime{Time: time.Now(), Valid: true},
+			},
+		},
+		passwords: map[uuid.UUID]string{},
+	}
+	cfg := &apiConfig{db: db}
+
+	tests := []struct {
+		token    string
+		expected int
+	}{
+		{"expired", http.StatusUnauthorized},
+		{"used", http.StatusUnauthorized},
+		{"unknown", http.StatusUnauthorized},
+		{"valid", http.StatusNoContent},
+		// The token is single-use, so replaying it must fail.
+		{"valid", http.StatusUnauthorized},
+	}
+
+	for _, test := range tests {
+		rec := confirmPasswordReset(cfg, test.token)
+		if rec.Code != test.expected {
+			t.Errorf("confirm with %q token: got status %d, want %d", test.token, rec.Code, test.expected)
+		}
+	}
+
+	if len(db.passwords) != 1 {
+		t.Errorf("password updated %d times, want 1", len(db.passwords))
+	}
+}
+
+func TestRequestPasswordResetUnknownEmail(t *testing.T) {
+	cfg := &apiConfig{db: &passwordResetDB{}}
+	req := newJSONRequest(http.MethodPost, "/api/password-reset", `{"email": "nobody@example.com"}`)
+	rec := httptest.NewRecorder()
+	cfg.requestPasswordResetHandler(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("got status %d, want %d", rec.Code, http.StatusOK)
+	}
+}
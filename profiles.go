@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/WOsaka/chirpy-server/internal/database"
+	"github.com/google/uuid"
+)
+
+// usernamePattern mirrors the CHECK constraint on users.username.
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9_]{1,30}$`)
+
+const maxDisplayNameLength = 50
+
+// profileFields validates the optional username and display_name request
+// fields and converts them to column values; empty strings are left unset.
+// Errors are safe to show to clients.
+func profileFields(username, displayName string) (sql.NullString, sql.NullString, error) {
+	var usernameField, displayNameField sql.NullString
+	if username != "" {
+		if !usernamePattern.MatchString(username) {
+			return usernameField, displayNameField, errors.New("Username must be 1-30 letters, digits or underscores")
+		}
+		usernameField = sql.NullString{String: username, Valid: true}
+	}
+	if displayName = strings.TrimSpace(displayName); displayName != "" {
+		if len([]rune(displayName)) > maxDisplayNameLength {
+			return usernameField, displayNameField, fmt.Errorf("Display name must be at most %d characters long", maxDisplayNameLength)
+		}
+		displayNameField = sql.NullString{String: displayName, Valid: true}
+	}
+	return usernameField, displayNameField, nil
+}
+
+// usernameAvailable reports whether username, compared case-insensitively,
+// is free or already belongs to userID.
+func (cfg *apiConfig) usernameAvailable(ctx context.Context, username string, userID uuid.UUID) (bool, error) {
+	dbUser, err := cfg.db.GetUserByUsername(ctx, username)
+	if errors.Is(err, sql.ErrNoRows) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return dbUser.ID == userID, nil
+}
+
+// updateProfileHandler sets the authenticated user's username and/or display
+// name. Fields left out of the request are unchanged.
+func (cfg *apiConfig) updateProfileHandler(w http.ResponseWriter, r *http.Request) {
+	userID := userIDFromContext(r)
+
+	var params struct {
+		Username    string `json:"username"`
+		DisplayName string `json:"display_name"`
+	}
+
+	if err := cfg.decodeJSON(w, r, &params); err != nil {
+		requestLogger(r).Warn("Error decoding parameters", "error", err)
+		respondWithError(w, r, http.StatusBadRequest, codeBadRequest, err.Error())
+		return
+	}
+
+	username, displayName, err := profileFields(params.Username, params.DisplayName)
+	if err != nil {
+		respondWithError(w, r, http.StatusBadRequest, codeValidationError, err.Error())
+		return
+	}
+	if !username.Valid && !displayName.Valid {
+		respondWithError(w, r, http.StatusBadRequest, codeValidationError, "Username or display name is required")
+		return
+	}
+
+	if username.Valid {
+		if ok, err := cfg.usernameAvailable(r.Context(), username.String, userID); err != nil {
+			requestLogger(r).Error("Error checking username", "user_id", userID, "error", err)
+			respondWithError(w, r, http.StatusInternalServerError, codeInternal, "Failed to update profile")
+			return
+		} else if !ok {
+			respondWithError(w, r, http.StatusConflict, codeConflict, "Username already taken")
+			return
+		}
+	}
+
+	dbUser, err := cfg.db.UpdateUserProfile(r.Context(), database.UpdateUserProfileParams{
+		Username:    username,
+		DisplayName: displayName,
+		ID:          userID,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, r, http.StatusNotFound, codeNotFound, "User not found")
+		return
+	}
+	if err != nil {
+		requestLogger(r).Error("Error updating profile", "user_id", userID, "error", err)
+		respondWithError(w, r, http.StatusInternalServerError, codeInternal, "Failed to update profile")
+		return
+	}
+
+	user := newUser(dbUser)
+
+	if err := respondWithJSON(w, http.StatusOK, user); err != nil {
+		requestLogger(r).Error("Error responding with JSON", "error", err)
+		return
+	}
+}
+
+// getCurrentUserHandler returns the authenticated user's own profile,
+// including when they last logged in.
+func (cfg *apiConfig) getCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	userID := userIDFromContext(r)
+
+	dbUser, err := cfg.db.GetUserByID(r.Context(), userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, r, http.StatusNotFound, codeNotFound, "User not found")
+		return
+	}
+	if err != nil {
+		requestLogger(r).Error("Error fetching user", "user_id", userID, "error", err)
+		respondWithError(w, r, http.StatusInternalServerError, codeInternal, "Failed to fetch user")
+		return
+	}
+
+	user := newUser(dbUser)
+
+	if err := respondWithJSON(w, http.StatusOK, user); err != nil {
+		requestLogger(r).Error("Error responding with JSON", "error", err)
+		return
+	}
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/WOsaka/chirpy-server/internal/auth"
+	"github.com/WOsaka/chirpy-server/internal/database"
+	"github.com/google/uuid"
+)
+
+// GetUserByUsername mimics the case-insensitive lookup.
+func (db *usersDB) GetUserByUsername(ctx context.Context, username string) (database.User, error) {
+	for _, user := range db.users {
+		if user.Username.Valid && strings.EqualFold(user.Username.String, username) {
+			return user, nil
+		}
+	}
+	return database.User{}, sql.ErrNoRows
+}
+
+func (db *usersDB) UpdateUserProfile(ctx context.Context, arg database.UpdateUserProfileParams) (database.User, error) {
+	for email, user := range db.users {
+		if user.ID != arg.ID {
+			continue
+		}
+		if arg.Username.Valid {
+			user.Username = arg.Username
+		}
+		if arg.DisplayName.Valid {
+			user.DisplayName = arg.DisplayName
+		}
+		db.users[email] = user
+		return user, nil
+	}
+	return database.User{}, sql.ErrNoRows
+}
+
+func (db *usersDB) GetUserByEmail(ctx context.Context, email string) (database.User, error) {
+	user, ok := db.users[email]
+	if !ok {
+		return database.User{}, sql.ErrNoRows
+	}
+	return user, nil
+}
+
+func (db *usersDB) GetUserByID(ctx context.Context, id uuid.UUID) (database.User, error) {
+	for _, user := range db.users {
+		if user.ID == id {
+			return user, nil
+		}
+	}
+	return database.User{}, sql.ErrNoRows
+}
+
+func (db *usersDB) UpdateLastLogin(ctx context.Context, id uuid.UUID) error {
+	for email, user := range db.users {
+		if user.ID == id {
+			user.LastLoginAt = sql.NullTime{Time: time.Now(), Valid: true}
+			db.users[email] = user
+		}
+	}
+	return nil
+}
+
+func (db *usersDB) CreateRefreshToken(ctx context.Context, arg database.CreateRefreshTokenParams) (database.RefreshToken, error) {
+	return database.RefreshToken{Token: arg.Token, UserID: arg.UserID, ExpiresAt: arg.ExpiresAt, FamilyID: arg.FamilyID}, nil
+}
+
+func TestProfileFields(t *testing.T) {
+	tests := []struct {
+		username    string
+		displayName string
+		wantErr     bool
+	}{
+		{"", "", false},
+		{"alice", "", false},
+		{"Alice_99", "  Alice Liddell  ", false},
+		{strings.Repeat("a", 30), "", false},
+		{strings.Repeat("a", 31), "", true},
+		{"alice!", "", true},
+		{"al ice", "", true},
+		{"ålice", "", true},
+		{"@alice", "", true},
+		{"", strings.Repeat("é", maxDisplayNameLength), false},
+		{"", strings.Repeat("é", maxDisplayNameLength+1), true},
+	}
+
+	for _, test := range tests {
+		username, displayName, err := profileFields(test.username, test.displayName)
+		if (err != nil) != test.wantErr {
+			t.Errorf("profileFields(%q, %q) error = %v, wantErr %v", test.username, test.displayName, err, test.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if username.Valid != (test.username != "") || username.String != test.username {
+			t.Errorf("profileFields(%q, ...) username = %+v", test.username, username)
+		}
+		if expected := strings.TrimSpace(test.displayName); displayName.String != expected || displayName.Valid != (expected != "") {
+			t.Errorf("profileFields(..., %q) display name = %+v; want %q", test.displayName, displayName, expected)
+		}
+	}
+}
+
+func TestCreateUserUsername(t *testing.T) {
+	db := &usersDB{users: map[string]database.User{}}
+	cfg := &apiConfig{db: db, registrationOpen: true}
+
+	rec := createUser(cfg, `{"email": "alice@example.com", "password": "hunter22", "username": "Alice", "display_name": "Alice L."}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("got status %d, want %d", rec.Code, http.StatusCreated)
+	}
+	var user User
+	if err := json.NewDecoder(rec.Body).Decode(&user); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+	if user.Username != "Alice" || user.DisplayName != "Alice L." {
+		t.Errorf("user = %+v; want username and display name in the response", user)
+	}
+
+	tests := []struct {
+		name     string
+		body     string
+		expected int
+	}{
+		{"taken", `{"email": "bob@example.com", "password": "hunter22", "username": "Alice"}`, http.StatusConflict},
+		{"taken in another case", `{"email": "bob@example.com", "password": "hunter22", "username": "aLiCe"}`, http.StatusConflict},
+		{"invalid charset", `{"email": "bob@example.com", "password": "hunter22", "username": "bob-smith"}`, http.StatusBadRequest},
+		{"free", `{"email": "bob@example.com", "password": "hunter22", "username": "bob_smith"}`, http.StatusCreated},
+	}
+	for _, test := range tests {
+		if rec := createUser(cfg, test.body); rec.Code != test.expected {
+			t.Errorf("%s: got status %d, want %d", test.name, rec.Code, test.expected)
+		}
+	}
+}
+
+func TestUpdateProfile(t *testing.T) {
+	alice := database.User{ID: uuid.New(), Email: "alice@example.com", Username: sql.NullString{String: "alice", Valid: true}}
+	bob := database.User{ID: uuid.New(), Email: "bob@example.com"}
+	db := &usersDB{users: map[string]database.User{alice.Email: alice, bob.Email: bob}}
+	cfg := &apiConfig{db: db, jwtKeys: auth.NewHS256Keys("secret")}
+
+	updateProfile := func(userID uuid.UUID, body string) *httptest.ResponseRecorder {
+		req := newJSONRequest(http.MethodPut, "/api/me/profile", body)
+		req.Header.Set("Authorization", "Bearer "+newTestJWT(t, cfg, userID))
+		rec := httptest.NewRecorder()
+		cfg.authMiddleware(cfg.updateProfileHandler).ServeHTTP(rec, req)
+		return rec
+	}
+
+	tests := []struct {
+		name     string
+		userID   uuid.UUID
+		body     string
+		expected int
+	}{
+		{"taken by another user", bob.ID, `{"username": "ALICE"}`, http.StatusConflict},
+		{"invalid charset", bob.ID, `{"username": "bob.smith"}`, http.StatusBadRequest},
+		{"nothing to change", bob.ID, `{}`, http.StatusBadRequest},
+		{"new username", bob.ID, `{"username": "bob", "display_name": "Bob"}`, http.StatusOK},
+		{"own username in another case", alice.ID, `{"username": "Alice"}`, http.StatusOK},
+		{"display name only", alice.ID, `{"display_name": "Alice L."}`, http.StatusOK},
+	}
+	for _, test := range tests {
+		if rec := updateProfile(test.userID, test.body); rec.Code != test.expected {
+			t.Errorf("%s: got status %d, want %d", test.name, rec.Code, test.expected)
+		}
+	}
+
+	if got := db.users[bob.Email]; got.Username.String != "bob" || got.DisplayName.String != "Bob" {
+		t.Errorf("bob = %+v; want username bob and display name Bob", got)
+	}
+	if got := db.users[alice.Email]; got.Username.String != "Alice" || got.DisplayName.String != "Alice L." {
+		t.Errorf("alice = %+v; want username Alice and display name Alice L.", got)
+	}
+}
+
+func TestLastLogin(t *testing.T) {
+	hash, err := auth.HashPassword("hunter22")
+	if err != nil {
+		t.Fatalf("HashPassword: %v", err)
+	}
+	user := database.User{ID: uuid.New(), Email: "user@example.com", HashedPassword: hash}
+	db := &usersDB{users: map[string]database.User{user.Email: user}}
+	cfg := &apiConfig{db: db, jwtKeys: auth.NewHS256Keys("secret")}
+
+	login := func() {
+		t.Helper()
+		req := newJSONRequest(http.MethodPost, "/api/login", `{"email": "user@example.com", "password": "hunter22"}`)
+		rec := httptest.NewRecorder()
+		cfg.loginHandler(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("login: got status %d, want %d", rec.Code, http.StatusOK)
+		}
+	}
+	me := func() User {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/api/users/me", nil)
+		req.Header.Set("Authorization", "Bearer "+newTestJWT(t, cfg, user.ID))
+		rec := httptest.NewRecorder()
+		cfg.authMiddleware(cfg.getCurrentUserHandler).ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET /api/users/me: got status %d, want %d", rec.Code, http.StatusOK)
+		}
+		var got User
+		if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+			t.Fatalf("decoding response: %v", err)
+		}
+		return got
+	}
+
+	if got := me(); got.LastLoginAt != nil {
+		t.Fatalf("last_login_at = %v before any login; want unset", got.LastLoginAt)
+	}
+
+	login()
+	first := me().LastLoginAt
+	if first == nil {
+		t.Fatal("last_login_at unset after login")
+	}
+
+	// Backdate the first login so the second is measurably later.
+	stored := db.users[user.Email]
+	stored.LastLoginAt.Time = stored.LastLoginAt.Time.Add(-time.Hour)
+	db.users[user.Email] = stored
+
+	login()
+	if second := me().LastLoginAt; second == nil || !second.After(stored.LastLoginAt.Time) {
+		t.Errorf("last_login_at = %v after second login; want later than %v", second, stored.LastLoginAt.Time)
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rateLimit allows up to requests per window for a single client.
+type rateLimit struct {
+	requests int
+	window   time.Duration
+}
+
+// rateWindow tracks how many requests a client made in the current window.
+type rateWindow struct {
+	start time.Time
+	count int
+}
+
+// rateLimiter is a fixed-window limiter keyed by route pattern and client IP.
+// Routes without an entry in routeLimits fall back to defaultLimit. Client
+// IPs are taken from X-Forwarded-For when the peer is in trustedProxies.
+type rateLimiter struct {
+	mu             sync.Mutex
+	defaultLimit   rateLimit
+	routeLimits    map[string]rateLimit
+	trustedProxies []netip.Prefix
+	windows        map[string]*rateWindow
+	// order lists windows oldest first, so expired ones can be evicted
+	// without scanning the map. Entries for windows that have since been
+	// replaced are skipped when they reach the front.
+	order []rateWindowRef
+	now   func() time.Time
+}
+
+// rateWindowRef is a window's entry in rateLimiter.order.
+type rateWindowRef struct {
+	route, key string
+	start      time.Time
+}
+
+// maxTrackedWindows bounds memory use. Once reached, the oldest windows are
+// evicted even if they haven't expired, which resets those clients' counts.
+const maxTrackedWindows = 10000
+
+func newRateLimiter(defaultLimit rateLimit, routeLimits map[string]rateLimit) *rateLimiter {
+	return &rateLimiter{
+		defaultLimit: defaultLimit,
+		routeLimits:  routeLimits,
+		windows:      map[string]*rateWindow{},
+		now:          time.Now,
+	}
+}
+
+func (rl *rateLimiter) limitFor(route string) rateLimit {
+	if limit, ok := rl.routeLimits[route]; ok {
+		return limit
+	}
+	return rl.defaultLimit
+}
+
+// allow records a request from client on route. When the limit has been
+// reached it returns false along with how long until the window resets.
+func (rl *rateLimiter) allow(route, client string) (bool, time.Duration) {
+	limit := rl.limitFor(route)
+	if limit.requests <= 0 {
+		return true, 0
+	}
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := rl.now()
+	rl.evict(now)
+
+	key := route + "|" + client
+	w, ok := rl.windows[key]
+	if !ok || now.Sub(w.start) >= limit.window {
+		w = &rateWindow{start: now}
+		rl.windows[key] = w
+		rl.order = append(rl.order, rateWindowRef{route: route, key: key, start: now})
+	}
+
+	if w.count >= limit.requests {
+		return false, w.start.Add(limit.window).Sub(now)
+	}
+	w.count++
+	return true, 0
+}
+
+// evict drops windows from the front of rl.order while they have expired or
+// there are too many, so each request does a constant amount of work on
+// average. A window with a longer limit than those behind it holds them
+// back until it expires, but maxTrackedWindows still applies.
+func (rl *rateLimiter) evict(now time.Time) {
+	for len(rl.order) > 0 {
+		ref := rl.order[0]
+		if w, ok := rl.windows[ref.key]; ok && w.start.Equal(ref.start) {
+			if now.Sub(w.start) < rl.limitFor(ref.route).window && len(rl.windows) < maxTrackedWindows {
+				break
+			}
+			delete(rl.windows, ref.key)
+		}
+		rl.order = rl.order[1:]
+	}
+
+	// Replaced windows leave stale entries behind the front; compact them
+	// away once they outnumber the live ones.
+	if len(rl.order) > 2*maxTrackedWindows {
+		live := make([]rateWindowRef, 0, len(rl.windows))
+		for _, ref := range rl.order {
+			if w, ok := rl.windows[ref.key]; ok && w.start.Equal(ref.start) {
+				live = append(live, ref)
+			}
+		}
+		rl.order = live
+	}
+}
+
+// slidingWindowLimiter allows up to limit.requests per client in any span
+// of limit.window, by remembering when each client's recent requests were
+// made. Unlike rateLimiter it can't be beaten by bursting either side of a
+// window boundary, at the cost of a timestamp per request.
+type slidingWindowLimiter struct {
+	mu     sync.Mutex
+	limit  rateLimit
+	recent map[string][]time.Time
+	// sweepAt is how many clients may be tracked before those with no
+	// recent requests are swept, doubling with the live ones so sweeping
+	// costs a constant amount per request on average.
+	sweepAt int
+	now     func() time.Time
+}
+
+func newSlidingWindowLimiter(limit rateLimit) *slidingWindowLimiter {
+	return &slidingWindowLimiter{
+		limit:   limit,
+		recent:  map[string][]time.Time{},
+		sweepAt: maxTrackedWindows,
+		now:     time.Now,
+	}
+}
+
+// allow records a request from client. When the limit has been reached it
+// returns false along with how long until the oldest counted request falls
+// out of the window.
+func (l *slidingWindowLimiter) allow(client string) (bool, time.Duration) {
+	if l.limit.requests <= 0 {
+		return true, 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	if len(l.recent) >= l.sweepAt {
+		for key, times := range l.recent {
+			if now.Sub(times[len(times)-1]) >= l.limit.window {
+				delete(l.recent, key)
+			}
+		}
+		l.sweepAt = max(maxTrackedWindows, 2*len(l.recent))
+	}
+
+	// times is oldest first and never longer than limit.requests.
+	times := l.recent[client]
+	for len(times) > 0 && now.Sub(times[0]) >= l.limit.window {
+		times = times[1:]
+	}
+	if len(times) >= l.limit.requests {
+		l.recent[client] = times
+		return false, times[0].Add(l.limit.window).Sub(now)
+	}
+	l.recent[client] = append(times, now)
+	return true, 0
+}
+
+// middleware applies the limiter to every request served by mux, using the
+// mux's matched pattern (e.g. "POST /api/login") as the route key.
+func (rl *rateLimiter) middleware(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, route := mux.Handler(r)
+		allowed, retryAfter := rl.allow(route, forwardedClientIP(r, rl.trustedProxies))
+		if !allowed {
+			respondWithRateLimit(w, r, "Too many requests", retryAfter)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// parseRateLimit parses a limit written as "<requests>/<window>", e.g.
+// "10/1m".
+func parseRateLimit(s string) (rateLimit, error) {
+	requests, window, ok := strings.Cut(strings.TrimSpace(s), "/")
+	if !ok {
+		return rateLimit{}, fmt.Errorf("rate limit %q must look like <requests>/<window>", s)
+	}
+	n, err := strconv.Atoi(requests)
+	if err != nil || n < 0 {
+		return rateLimit{}, fmt.Errorf("invalid request count in rate limit %q", s)
+	}
+	d, err := time.ParseDuration(window)
+	if err != nil || d <= 0 {
+		return rateLimit{}, fmt.Errorf("invalid window in rate limit %q", s)
+	}
+	return rateLimit{requests: n, window: d}, nil
+}
+
+// parseRouteRateLimits parses semicolon-separated "<pattern>=<limit>" pairs,
+// e.g. "POST /api/login=5/1m; GET /api/chirps=300/1m".
+func parseRouteRateLimits(s string) (map[string]rateLimit, error) {
+	limits := map[string]rateLimit{}
+	for _, entry := range strings.Split(s, ";") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		pattern, value, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("rate limit entry %q must look like <pattern>=<limit>", entry)
+		}
+		limit, err := parseRateLimit(value)
+		if err != nil {
+			return nil, err
+		}
+		limits[strings.TrimSpace(pattern)] = limit
+	}
+	return limits, nil
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/WOsaka/chirpy-server/internal/auth"
+	"github.com/WOsaka/chirpy-server/internal/database"
+	"github.com/google/uuid"
+)
+
+func TestRateLimiterPerRoute(t *testing.T) {
+	mux := http.NewServeMux()
+	ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }
+	mux.HandleFunc("POST /api/login", ok)
+	mux.HandleFunc("GET /api/chirps", ok)
+
+	limiter := newRateLimiter(
+		rateLimit{requests: 5, window: time.Minute},
+		map[string]rateLimit{"POST /api/login": {requests: 2, window: time.Minute}},
+	)
+	handler := limiter.middleware(mux)
+
+	do := func(method, path string) int {
+		req := httptest.NewRequest(method, path, nil)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	for i := 0; i < 2; i++ {
+		if code := do(http.MethodPost, "/api/login"); code != http.StatusOK {
+			t.Fatalf("login %d: got status %d, want %d", i+1, code, http.StatusOK)
+		}
+	}
+	if code := do(http.MethodPost, "/api/login"); code != http.StatusTooManyRequests {
+		t.Errorf("login over limit: got status %d, want %d", code, http.StatusTooManyRequests)
+	}
+
+	for i := 0; i < 5; i++ {
+		if code := do(http.MethodGet, "/api/chirps"); code != http.StatusOK {
+			t.Fatalf("chirps %d: got status %d, want %d", i+1, code, http.StatusOK)
+		}
+	}
+	if code := do(http.MethodGet, "/api/chirps"); code != http.StatusTooManyRequests {
+		t.Errorf("chirps over default limit: got status %d, want %d", code, http.StatusTooManyRequests)
+	}
+}
+
+func TestRateLimiterWindowResets(t *testing.T) {
+	now := time.Now()
+	limiter := newRateLimiter(rateLimit{requests: 1, window: time.Minute}, nil)
+	limiter.now = func() time.Time { return now }
+
+	if allowed, _ := limiter.allow("GET /api/chirps", "1.2.3.4"); !allowed {
+		t.Fatal("first request should be allowed")
+	}
+	allowed, retryAfter := limiter.allow("GET /api/chirps", "1.2.3.4")
+	if allowed {
+		t.Fatal("second request should be limited")
+	}
+	if retryAfter != time.Minute {
+		t.Errorf("retryAfter = %s; want %s", retryAfter, time.Minute)
+	}
+	if allowed, _ := limiter.allow("GET /api/chirps", "5.6.7.8"); !allowed {
+		t.Error("other clients should have their own window")
+	}
+
+	now = now.Add(time.Minute)
+	if allowed, _ := limiter.allow("GET /api/chirps", "1.2.3.4"); !allowed {
+		t.Error("request after the window should be allowed")
+	}
+}
+
+func TestRateLimiterEviction(t *testing.T) {
+	now := time.Now()
+	limiter := newRateLimiter(rateLimit{requests: 1, window: time.Minute}, nil)
+	limiter.now = func() time.Time { return now }
+
+	for i := range maxTrackedWindows + 10 {
+		limiter.allow("GET /api/chirps", strconv.Itoa(i))
+	}
+	if n := len(limiter.windows); n > maxTrackedWindows {
+		t.Errorf("tracking %d windows; want at most %d", n, maxTrackedWindows)
+	}
+	// The oldest clients were evicted to make room; the newest weren't.
+	if _, ok := limiter.windows["GET /api/chirps|0"]; ok {
+		t.Error("oldest window was not evicted")
+	}
+	if allowed, _ := limiter.allow("GET /api/chirps", strconv.Itoa(maxTrackedWindows+9)); allowed {
+		t.Error("newest client's window was lost")
+	}
+
+	now = now.Add(time.Minute)
+	limiter.allow("GET /api/chirps", "fresh")
+	if n := len(limiter.windows); n != 1 {
+		t.Errorf("tracking %d windows after they expired; want 1", n)
+	}
+	if n := len(limiter.order); n != 1 {
+		t.Errorf("order holds %d entries after the windows expired; want 1", n)
+	}
+}
+
+func TestRateLimiterTrustedProxies(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/chirps", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+
+	limiter := newRateLimiter(rateLimit{requests: 1, window: time.Minute}, nil)
+	limiter.trustedProxies = []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+	handler := limiter.middleware(mux)
+
+	do := func(forwardedFor string) int {
+		req := httptest.NewRequest(http.MethodGet, "/api/chirps", nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// Clients behind the proxy get a bucket each rather than sharing its.
+	if code := do("203.0.113.1"); code != http.StatusOK {
+		t.Errorf("first client: got status %d, want %d", code, http.StatusOK)
+	}
+	if code := do("203.0.113.2"); code != http.StatusOK {
+		t.Errorf("second client: got status %d, want %d", code, http.StatusOK)
+	}
+	if code := do("203.0.113.1"); code != http.StatusTooManyRequests {
+		t.Errorf("first client again: got status %d, want %d", code, http.StatusTooManyRequests)
+	}
+}
+
+func TestChirpRateLimit(t *testing.T) {
+	userID, otherID := uuid.New(), uuid.New()
+	now := time.Now()
+	limiter := newSlidingWindowLimiter(rateLimit{requests: 2, window: time.Minute})
+	limiter.now = func() time.Time { return now }
+	db := &chirpsDB{chirps: map[uuid.UUID]database.Chirp{}}
+	cfg := &apiConfig{
+		db:             db,
+		jwtKeys:        auth.NewHS256Keys("secret"),
+		maxChirpLength: 140,
+		chirpLimiter:   limiter,
+	}
+
+	for i := 0; i < 2; i++ {
+		if rec := postChirp(t, cfg, userID, `{"body": "hello"}`); rec.Code != http.StatusCreated {
+			t.Fatalf("chirp %d: got status %d, want %d", i+1, rec.Code, http.StatusCreated)
+		}
+	}
+
+	now = now.Add(15 * time.Second)
+	rec := postChirp(t, cfg, userID, `{"body": "hello"}`)
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("chirp over limit: got status %d, want %d", rec.Code, http.StatusTooManyRequests)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "45" {
+		t.Errorf("Retry-After = %q; want %q", got, "45")
+	}
+	if len(db.chirps) != 2 {
+		t.Errorf("%d chirps created; want 2", len(db.chirps))
+	}
+
+	if rec := postChirp(t, cfg, otherID, `{"body": "hello"}`); rec.Code != http.StatusCreated {
+		t.Errorf("other user: got status %d, want %d", rec.Code, http.StatusCreated)
+	}
+
+	now = now.Add(45 * time.Second)
+	if rec := postChirp(t, cfg, userID, `{"body": "hello"}`); rec.Code != http.StatusCreated {
+		t.Errorf("chirp after window: got status %d, want %d", rec.Code, http.StatusCreated)
+	}
+}
+
+func TestSlidingWindowLimiter(t *testing.T) {
+	start := time.Now()
+	now := start
+	limiter := newSlidingWindowLimiter(rateLimit{requests: 2, window: time.Minute})
+	limiter.now = func() time.Time { return now }
+
+	at := func(offset time.Duration) (bool, time.Duration) {
+		now = start.Add(offset)
+		return limiter.allow("user")
+	}
+
+	// A fixed window starting with the first request would allow two more
+	// at 61s, three within two seconds; here the one at 59s still counts.
+	for _, offset := range []time.Duration{0, 59 * time.Second, 61 * time.Second} {
+		if allowed, _ := at(offset); !allowed {
+			t.Fatalf("request at %s should be allowed", offset)
+		}
+	}
+	allowed, retryAfter := at(61 * time.Second)
+	if allowed {
+		t.Fatal("third request within a minute should be limited")
+	}
+	if retryAfter != 58*time.Second {
+		t.Errorf("retryAfter = %s; want %s", retryAfter, 58*time.Second)
+	}
+
+	if allowed, _ := at(119 * time.Second); !allowed {
+		t.Error("request once the one at 59s has expired should be allowed")
+	}
+}
+
+func TestParseRouteRateLimits(t *testing.T) {
+	limits, err := parseRouteRateLimits("POST /api/login=5/1m; GET /api/chirps=300/30s")
+	if err != nil {
+		t.Fatalf("parseRouteRateLimits failed: %v", err)
+	}
+	if got := limits["POST /api/login"]; got != (rateLimit{requests: 5, window: time.Minute}) {
+		t.Errorf("login limit = %+v", got)
+	}
+	if got := limits["GET /api/chirps"]; got != (rateLimit{requests: 300, window: 30 * time.Second}) {
+		t.Errorf("chirps limit = %+v", got)
+	}
+
+	for _, invalid := range []string{"POST /api/login", "POST /api/login=5", "POST /api/login=x/1m", "POST /api/login=5/soon"} {
+		if _, err := parseRouteRateLimits(invalid); err == nil {
+			t.Errorf("parseRouteRateLimits(%q) should fail", invalid)
+		}
+	}
+}
+
+func TestRateLimitResponse(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/chirps", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+
+	now := time.Now()
+	limiter := newRateLimiter(rateLimit{requests: 1, window: time.Minute}, nil)
+	limiter.now = func() time.Time { return now }
+	handler := limiter.middleware(mux)
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/chirps", nil))
+	now = now.Add(20*time.Second + time.Millisecond)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/chirps", nil))
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("got status %d, want %d", rec.Code, http.StatusTooManyRequests)
+	}
+
+	var body struct {
+		Error             errorBody `json:"error"`
+		RetryAfterSeconds int       `json:"retry_after_seconds"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+	if body.Error.Message == "" || body.Error.Code != codeRateLimited {
+		t.Errorf("error = %+v; want a message with code %q", body.Error, codeRateLimited)
+	}
+	if body.RetryAfterSeconds != 40 {
+		t.Errorf("retry_after_seconds = %d; want 40", body.RetryAfterSeconds)
+	}
+	if header := rec.Header().Get("Retry-After"); header != strconv.Itoa(body.RetryAfterSeconds) {
+		t.Errorf("Retry-After = %q; want it to match retry_after_seconds %d", header, body.RetryAfterSeconds)
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+// getChirpRepliesHandler returns the direct replies to a chirp, oldest first.
+func (cfg *apiConfig) getChirpRepliesHandler(w http.ResponseWriter, r *http.Request) {
+	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, r, http.StatusBadRequest, codeValidationError, "Invalid chirp ID")
+		return
+	}
+
+	if _, err := cfg.db.GetChirpByID(r.Context(), chirpID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondWithError(w, r, http.StatusNotFound, codeNotFound, "Chirp not found")
+			return
+		}
+		requestLogger(r).Error("Error fetching chirp", "error", err)
+		respondWithError(w, r, http.StatusInternalServerError, codeInternal, "Failed to fetch chirp")
+		return
+	}
+
+	dbReplies, err := cfg.db.GetChirpReplies(r.Context(), uuid.NullUUID{UUID: chirpID, Valid: true})
+	if err != nil {
+		requestLogger(r).Error("Error fetching replies", "error", err)
+		respondWithError(w, r, http.StatusInternalServerError, codeInternal, "Failed to fetch replies")
+		return
+	}
+
+	replies := []Chirp{}
+	for _, dbReply := range dbReplies {
+		replies = append(replies, newChirp(dbReply))
+	}
+
+	if err := cfg.attachLikeCounts(r.Context(), replies); err != nil {
+		requestLogger(r).Error("Error fetching like counts", "error", err)
+		respondWithError(w, r, http.StatusInternalServerError, codeInternal, "Failed to fetch replies")
+		return
+	}
+
+	if err := respondWithJSON(w, http.StatusOK, replies); err != nil {
+		requestLogger(r).Error("Error responding with JSON", "error", err)
+		return
+	}
+}
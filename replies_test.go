@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/WOsaka/chirpy-server/internal/auth"
+	"github.com/WOsaka/chirpy-server/internal/database"
+	"github.com/google/uuid"
+)
+
+func (db *chirpsDB) CreateChirp(ctx context.Context, arg database.CreateChirpParams) (database.Chirp, error) {
+	chirp := database.Chirp{
+		ID:            uuid.New(),
+		CreatedAt:     time.Now(),
+		UpdatedAt:     time.Now(),
+		Body:          arg.Body,
+		UserID:        arg.UserID,
+		ParentChirpID: arg.ParentChirpID,
+		CreatorIp:     arg.CreatorIp,
+		RawBody:       arg.RawBody,
+		MediaUrl:      arg.MediaUrl,
+	}
+	db.chirps[chirp.ID] = chirp
+	return chirp, nil
+}
+
+func (db *chirpsDB) GetChirpReplies(ctx context.Context, parentChirpID uuid.NullUUID) ([]database.Chirp, error) {
+	var replies []database.Chirp
+	for _, chirp := range db.chirps {
+		if chirp.ParentChirpID == parentChirpID && !chirp.DeletedAt.Valid {
+			replies = append(replies, chirp)
+		}
+	}
+	return replies, nil
+}
+
+func postChirp(t *testing.T, cfg *apiConfig, userID uuid.UUID, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := newJSONRequest(http.MethodPost, "/api/chirps", body)
+	req.Header.Set("Authorization", "Bearer "+newTestJWT(t, cfg, userID))
+	rec := httptest.NewRecorder()
+	cfg.authMiddleware(cfg.createChirpHandler).ServeHTTP(rec, req)
+	return rec
+}
+
+func TestChirpReplies(t *testing.T) {
+	parentID := uuid.New()
+	db := &chirpsDB{chirps: map[uuid.UUID]database.Chirp{parentID: {ID: parentID, Body: "parent"}}}
+	cfg := &apiConfig{db: db, jwtKeys: auth.NewHS256Keys("secret"), maxChirpLength: 140}
+	userID := uuid.New()
+
+	rec := postChirp(t, cfg, userID, `{"body": "orphan", "parent_id": "`+uuid.New().String()+`"}`)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("reply to unknown parent: got status %d, want %d", rec.Code, http.StatusNotFound)
+	}
+
+	rec = postChirp(t, cfg, userID, `{"body": "a reply", "parent_id": "`+parentID.String()+`"}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("reply: got status %d, want %d", rec.Code, http.StatusCreated)
+	}
+	var reply Chirp
+	if err := json.NewDecoder(rec.Body).Decode(&reply); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+	if reply.ParentID == nil || *reply.ParentID != parentID {
+		t.Errorf("reply parent_id = %v; want %v", reply.ParentID, parentID)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/chirps/"+parentID.String()+"/replies", nil)
+	req.SetPathValue("chirpID", parentID.String())
+	rec = httptest.NewRecorder()
+	cfg.getChirpRepliesHandler(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("replies: got status %d, want %d", rec.Code, http.StatusOK)
+	}
+	var replies []Chirp
+	if err := json.NewDecoder(rec.Body).Decode(&replies); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+	if len(replies) != 1 || replies[0].ID != reply.ID {
+		t.Errorf("replies = %v; want just %v", replies, reply.ID)
+	}
+}
+
+func TestGetChirpEngagementCounts(t *testing.T) {
+	db := &chirpsDB{chirps: map[uuid.UUID]database.Chirp{}}
+	cfg := &apiConfig{db: db, jwtKeys: auth.NewHS256Keys("secret"), maxChirpLength: 140}
+	userID := uuid.New()
+
+	counts := func(chirpID uuid.UUID) (int64, *int64) {
+		t.Helper()
+		rec := getChirp(cfg, chirpID)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET chirp: got status %d, want %d", rec.Code, http.StatusOK)
+		}
+		var chirp Chirp
+		if err := json.NewDecoder(rec.Body).Decode(&chirp); err != nil {
+			t.Fatalf("decoding response: %v", err)
+		}
+		return chirp.LikeCount, chirp.ReplyCount
+	}
+
+	rec := postChirp(t, cfg, userID, `{"body": "engage with me"}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create: got status %d, want %d", rec.Code, http.StatusCreated)
+	}
+	var parent Chirp
+	if err := json.NewDecoder(rec.Body).Decode(&parent); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+
+	if likes, replies := counts(parent.ID); likes != 0 || replies == nil || *replies != 0 {
+		t.Errorf("new chirp: like_count = %d, reply_count = %v; want 0 and 0", likes, replies)
+	}
+
+	if rec := postChirp(t, cfg, userID, `{"body": "a reply", "parent_id": "`+parent.ID.String()+`"}`); rec.Code != http.StatusCreated {
+		t.Fatalf("reply: got status %d, want %d", rec.Code, http.StatusCreated)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/api/chirps/"+parent.ID.String()+"/like", nil)
+	req.SetPathValue("chirpID", parent.ID.String())
+	req.Header.Set("Authorization", "Bearer "+newTestJWT(t, cfg, uuid.New()))
+	rec = httptest.NewRecorder()
+	cfg.authMiddleware(cfg.likeChirpHandler).ServeHTTP(rec, req)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("like: got status %d, want %d", rec.Code, http.StatusNoContent)
+	}
+
+	if likes, replies := counts(parent.ID); likes != 1 || replies == nil || *replies != 1 {
+		t.Errorf("after reply and like: like_count = %d, reply_count = %v; want 1 and 1", likes, replies)
+	}
+}
+
+func TestCreateChirpLocation(t *testing.T) {
+	db := &chirpsDB{chirps: map[uuid.UUID]database.Chirp{}}
+	cfg := &apiConfig{db: db, jwtKeys: auth.NewHS256Keys("secret"), maxChirpLength: 140}
+
+	rec := postChirp(t, cfg, uuid.New(), `{"body": "hello"}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("got status %d, want %d", rec.Code, http.StatusCreated)
+	}
+	var chirp Chirp
+	if err := json.NewDecoder(rec.Body).Decode(&chirp); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+	if got, want := rec.Header().Get("Location"), "/api/chirps/"+chirp.ID.String(); got != want {
+		t.Errorf("Location = %q; want %q", got, want)
+	}
+}
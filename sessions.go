@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/WOsaka/chirpy-server/internal/database"
+	"github.com/google/uuid"
+)
+
+// sessionTokenSuffixLength is how much of a refresh token a session listing
+// shows, enough for users to tell sessions apart without exposing the token.
+const sessionTokenSuffixLength = 4
+
+// Session is an active refresh token as shown on the account-security page.
+type Session struct {
+	ID          uuid.UUID `json:"id"`
+	CreatedAt   time.Time `json:"created_at"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	TokenSuffix string    `json:"token_suffix"`
+}
+
+func newSession(dbToken database.RefreshToken) Session {
+	suffix := dbToken.Token
+	if len(suffix) > sessionTokenSuffixLength {
+		suffix = suffix[len(suffix)-sessionTokenSuffixLength:]
+	}
+	return Session{
+		ID:          dbToken.ID,
+		CreatedAt:   dbToken.CreatedAt,
+		ExpiresAt:   dbToken.ExpiresAt,
+		TokenSuffix: suffix,
+	}
+}
+
+// getSessionsHandler lists the authenticated user's unrevoked, unexpired
+// refresh tokens, newest first.
+func (cfg *apiConfig) getSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	userID := userIDFromContext(r)
+
+	dbTokens, err := cfg.db.ListActiveRefreshTokensByUserID(r.Context(), userID)
+	if err != nil {
+		requestLogger(r).Error("Error fetching sessions", "user_id", userID, "error", err)
+		respondWithError(w, r, http.StatusInternalServerError, codeInternal, "Failed to fetch sessions")
+		return
+	}
+
+	sessions := []Session{}
+	for _, dbToken := range dbTokens {
+		sessions = append(sessions, newSession(dbToken))
+	}
+
+	if err := respondWithJSON(w, http.StatusOK, sessions); err != nil {
+		requestLogger(r).Error("Error responding with JSON", "error", err)
+		return
+	}
+}
+
+// revokeSessionHandler revokes one of the authenticated user's refresh
+// tokens. Other users' sessions and already revoked ones are reported as not
+// found.
+func (cfg *apiConfig) revokeSessionHandler(w http.ResponseWriter, r *http.Request) {
+	userID := userIDFromContext(r)
+
+	sessionID, err := uuid.Parse(r.PathValue("tokenID"))
+	if err != nil {
+		respondWithError(w, r, http.StatusBadRequest, codeValidationError, "Invalid session ID")
+		return
+	}
+
+	revoked, err := cfg.db.RevokeRefreshTokenByID(r.Context(), database.RevokeRefreshTokenByIDParams{
+		ID:     sessionID,
+		UserID: userID,
+	})
+	if err != nil {
+		requestLogger(r).Error("Error revoking session", "user_id", userID, "session_id", sessionID, "error", err)
+		respondWithError(w, r, http.StatusInternalServerError, codeInternal, "Failed to revoke session")
+		return
+	}
+	if revoked == 0 {
+		respondWithError(w, r, http.StatusNotFound, codeNotFound, "Session not found")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
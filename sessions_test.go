@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/WOsaka/chirpy-server/internal/auth"
+	"github.com/WOsaka/chirpy-server/internal/database"
+	"github.com/google/uuid"
+)
+
+type sessionsDB struct {
+	database.Querier
+	tokens []database.RefreshToken
+}
+
+func (db *sessionsDB) ListActiveRefreshTokensByUserID(ctx context.Context, userID uuid.UUID) ([]database.RefreshToken, error) {
+	var active []database.RefreshToken
+	for _, token := range db.tokens {
+		if token.UserID == userID && !token.RevokedAt.Valid && token.ExpiresAt.After(time.Now()) {
+			active = append(active, token)
+		}
+	}
+	sort.Slice(active, func(i, j int) bool { return active[i].CreatedAt.After(active[j].CreatedAt) })
+	return active, nil
+}
+
+func (db *sessionsDB) RevokeRefreshTokenByID(ctx context.Context, arg database.RevokeRefreshTokenByIDParams) (int64, error) {
+	for i, token := range db.tokens {
+		if token.ID == arg.ID && token.UserID == arg.UserID && !token.RevokedAt.Valid {
+			db.tokens[i].RevokedAt.Time, db.tokens[i].RevokedAt.Valid = time.Now(), true
+			return 1, nil
+		}
+	}
+	return 0, nil
+}
+
+func TestSessions(t *testing.T) {
+	userID, otherID := uuid.New(), uuid.New()
+	now := time.Now()
+	older, newer, otherSession := uuid.New(), uuid.New(), uuid.New()
+	db := &sessionsDB{tokens: []database.RefreshToken{
+		{ID: older, Token: "aaaa1111", UserID: userID, CreatedAt: now.Add(-2 * time.Hour), ExpiresAt: now.Add(time.Hour)},
+		{ID: newer, Token: "bbbb2222", UserID: userID, CreatedAt: now.Add(-time.Hour), ExpiresAt: now.Add(time.Hour)},
+		{ID: uuid.New(), Token: "expired", UserID: userID, CreatedAt: now.Add(-3 * time.Hour), ExpiresAt: now.Add(-time.Hour)},
+		{ID: otherSession, Token: "cccc3333", UserID: otherID, CreatedAt: now, ExpiresAt: now.Add(time.Hour)},
+	}}
+	cfg := &apiConfig{db: db, jwtKeys: auth.NewHS256Keys("secret")}
+	token := newTestJWT(t, cfg, userID)
+
+	list := func() []Session {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/api/sessions", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		cfg.authMiddleware(cfg.getSessionsHandler).ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("list: got status %d, want %d", rec.Code, http.StatusOK)
+		}
+		var sessions []Session
+		if err := json.NewDecoder(rec.Body).Decode(&sessions); err != nil {
+			t.Fatalf("decoding response: %v", err)
+		}
+		return sessions
+	}
+	revoke := func(id string) int {
+		req := httptest.NewRequest(http.MethodDelete, "/api/sessions/"+id, nil)
+		req.SetPathValue("tokenID", id)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		cfg.authMiddleware(cfg.revokeSessionHandler).ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	sessions := list()
+	if len(sessions) != 2 || sessions[0].ID != newer || sessions[1].ID != older {
+		t.Fatalf("sessions = %+v; want %v then %v", sessions, newer, older)
+	}
+	if sessions[0].TokenSuffix != "2222" {
+		t.Errorf("token suffix = %q; want %q", sessions[0].TokenSuffix, "2222")
+	}
+
+	if code := revoke(otherSession.String()); code != http.StatusNotFound {
+		t.Errorf("revoke other user's session: got status %d, want %d", code, http.StatusNotFound)
+	}
+	if code := revoke("nope"); code != http.StatusBadRequest {
+		t.Errorf("revoke invalid ID: got status %d, want %d", code, http.StatusBadRequest)
+	}
+	if code := revoke(older.String()); code != http.StatusNoContent {
+		t.Fatalf("revoke: got status %d, want %d", code, http.StatusNoContent)
+	}
+	if code := revoke(older.String()); code != http.StatusNotFound {
+		t.Errorf("revoke twice: got status %d, want %d", code, http.StatusNotFound)
+	}
+
+	if sessions := list(); len(sessions) != 1 || sessions[0].ID != newer {
+		t.Errorf("sessions after revoke = %+v; want only %v", sessions, newer)
+	}
+}
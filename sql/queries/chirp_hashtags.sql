@@ -0,0 +1,12 @@
+-- name: AddChirpHashtag :exec
+INSERT INTO chirp_hashtags (chirp_id, hashtag, created_at)
+VALUES ($1, $2, NOW())
+ON CONFLICT (chirp_id, hashtag) DO NOTHING;
+
+-- name: GetTrendingHashtags :many
+SELECT chirp_hashtags.hashtag, COUNT(*) AS count FROM chirp_hashtags
+JOIN chirps ON chirps.id = chirp_hashtags.chirp_id
+WHERE chirp_hashtags.created_at >= sqlc.arg('since') AND chirps.deleted_at IS NULL
+GROUP BY chirp_hashtags.hashtag
+ORDER BY count DESC, chirp_hashtags.hashtag ASC
+LIMIT sqlc.arg('limit');
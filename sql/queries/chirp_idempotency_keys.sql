@@ -0,0 +1,10 @@
+-- name: GetChirpIDForIdempotencyKey :one
+SELECT chirp_id FROM chirp_idempotency_keys
+WHERE user_id = $1 AND idempotency_key = $2 AND created_at >= sqlc.arg('since');
+
+-- name: SaveChirpIdempotencyKey :exec
+INSERT INTO chirp_idempotency_keys (user_id, idempotency_key, chirp_id, created_at)
+VALUES ($1, $2, $3, NOW())
+ON CONFLICT (user_id, idempotency_key) DO UPDATE
+SET chirp_id = EXCLUDED.chirp_id,
+    created_at = EXCLUDED.created_at;
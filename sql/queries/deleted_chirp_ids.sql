@@ -0,0 +1,23 @@
+-- name: CreateChirpTombstone :exec
+INSERT INTO deleted_chirp_ids (chirp_id, deleted_at)
+VALUES ($1, NOW())
+ON CONFLICT (chirp_id) DO NOTHING;
+
+-- name: IsChirpTombstoned :one
+SELECT EXISTS (
+    SELECT 1 FROM deleted_chirp_ids
+    WHERE chirp_id = $1
+);
+
+-- name: DeleteAllChirpTombstones :exec
+DELETE FROM deleted_chirp_ids;
+
+-- name: PurgeDeletedChirps :execrows
+WITH purged AS (
+    DELETE FROM chirps
+    WHERE deleted_at < sqlc.arg('deleted_before')::timestamp
+    RETURNING id, deleted_at
+)
+INSERT INTO deleted_chirp_ids (chirp_id, deleted_at)
+SELECT id, deleted_at FROM purged
+ON CONFLICT (chirp_id) DO NOTHING;
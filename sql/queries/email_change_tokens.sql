@@ -0,0 +1,30 @@
+-- name: CreateEmailChangeToken :one
+INSERT INTO email_change_tokens (token, created_at, user_id, new_email, expires_at, used_at)
+VALUES (
+    $1,
+    NOW(),
+    $2,
+    $3,
+    $4,
+    NULL
+)
+RETURNING *;
+
+-- name: GetEmailChangeToken :one
+SELECT * FROM email_change_tokens
+WHERE token = $1;
+
+-- name: ConfirmEmailChange :one
+WITH claimed AS (
+    UPDATE email_change_tokens
+    SET used_at = NOW()
+    WHERE token = sqlc.arg('token') AND user_id = sqlc.arg('user_id') AND used_at IS NULL
+    RETURNING user_id, new_email
+)
+UPDATE users
+SET email = claimed.new_email,
+    email_verified = TRUE,
+    updated_at = NOW()
+FROM claimed
+WHERE users.id = claimed.user_id
+RETURNING users.*;
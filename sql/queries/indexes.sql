@@ -0,0 +1,3 @@
+-- name: ListIndexes :many
+SELECT tablename::text AS table_name, indexname::text AS index_name, indexdef FROM pg_indexes
+WHERE schemaname = current_schema();
@@ -0,0 +1,13 @@
+-- name: GetMetric :one
+SELECT value FROM metrics
+WHERE name = $1;
+
+-- name: IncrementMetric :exec
+INSERT INTO metrics (name, value, updated_at)
+VALUES ($1, $2, NOW())
+ON CONFLICT (name) DO UPDATE
+SET value = metrics.value + EXCLUDED.value,
+    updated_at = NOW();
+
+-- name: DeleteAllMetrics :exec
+DELETE FROM metrics;
@@ -1,34 +1,56 @@
 -- name: CreateUser :one
-INSERT INTO users (id, created_at, updated_at, email)
+INSERT INTO users (id, created_at, updated_at, email, username, display_name)
 VALUES (
     gen_random_uuid(),
     NOW(),
     NOW(),
-    $1
+    $1,
+    $2,
+    $3
 )
 RETURNING *;
 
 -- name: DeleteAllUsers :exec
 DELETE FROM users;
 
+-- name: DeleteAllChirps :exec
+DELETE FROM chirps;
+
+-- name: DeleteAllRefreshTokens :exec
+DELETE FROM refresh_tokens;
+
 -- name: CreateChirp :one
-INSERT INTO chirps (id, created_at, updated_at, body, user_id)
+INSERT INTO chirps (id, created_at, updated_at, body, user_id, parent_chirp_id, creator_ip, raw_body, media_url)
 VALUES(
     gen_random_uuid(),
     NOW(), 
     NOW(), 
     $1,
-    $2
+    $2,
+    $3,
+    $4,
+    $5,
+    $6
 )
 RETURNING *;
 
 -- name: GetAllChirps :many
 SELECT * FROM chirps
+WHERE deleted_at IS NULL
 ORDER BY created_at ASC;
 
 -- name: GetChirpByID :one
 SELECT * FROM chirps
-WHERE id = $1;
+WHERE id = $1 AND deleted_at IS NULL;
+
+-- name: GetChirpWithCounts :one
+SELECT sqlc.embed(chirps),
+    (SELECT COUNT(*) FROM chirp_likes
+     WHERE chirp_likes.chirp_id = chirps.id) AS like_count,
+    (SELECT COUNT(*) FROM chirps AS replies
+     WHERE replies.parent_chirp_id = chirps.id AND replies.deleted_at IS NULL) AS reply_count
+FROM chirps
+WHERE chirps.id = $1 AND chirps.deleted_at IS NULL;
 
 -- name: SetPassword :exec
 UPDATE users
@@ -37,17 +59,18 @@ WHERE email = $2;
 
 -- name: GetUserByEmail :one
 SELECT * FROM users
-WHERE email = $1;
+WHERE LOWER(email) = LOWER(sqlc.arg('email'));
 
 -- name: CreateRefreshToken :one
-INSERT INTO refresh_tokens (token, created_at, updated_at, user_id, expires_at, revoked_at) 
+INSERT INTO refresh_tokens (token, created_at, updated_at, user_id, expires_at, revoked_at, family_id) 
 VALUES (
     $1,
     NOW(),
     NOW(),
     $2,
     $3,
-    NULL
+    NULL,
+    $4
 )
 RETURNING *;
 
@@ -55,23 +78,48 @@ RETURNING *;
 SELECT * FROM refresh_tokens
 WHERE token = $1;
 
--- name: RevokeRefreshToken :exec
+-- name: GetRefreshTokenSuccessor :one
+SELECT successor.* FROM refresh_tokens AS rotated
+JOIN refresh_tokens AS successor ON successor.token = rotated.replaced_by
+WHERE rotated.token = sqlc.arg('token')
+  AND rotated.revoked_at > NOW() - make_interval(secs => sqlc.arg('grace_seconds')::float8)
+  AND successor.revoked_at IS NULL
+  AND successor.expires_at > NOW();
+
+-- name: GetRefreshTokenWithUser :one
+SELECT sqlc.embed(refresh_tokens), sqlc.embed(users) FROM refresh_tokens
+JOIN users ON users.id = refresh_tokens.user_id
+WHERE refresh_tokens.token = $1;
+
+-- name: RevokeRefreshToken :execrows
 UPDATE refresh_tokens
-SET revoked_at = NOW(),
+SET revoked_at = COALESCE(revoked_at, NOW()),
     updated_at = NOW()
 WHERE token = $1;
 
+-- name: UpdateLastLogin :exec
+UPDATE users
+SET last_login_at = NOW()
+WHERE id = $1;
+
 -- name: UpdateUserCredentials :one
 UPDATE users
-SET email = $1,
-    hashed_password = $2,
+SET email = COALESCE(sqlc.narg('email'), email),
+    email_verified = CASE
+        WHEN sqlc.narg('email') IS NULL OR sqlc.narg('email') = email THEN email_verified
+        ELSE false
+    END,
+    hashed_password = COALESCE(sqlc.narg('hashed_password'), hashed_password),
     updated_at = NOW()
-WHERE id = $3
+WHERE id = sqlc.arg('id')
 RETURNING *;
 
--- name: DeleteChirpByID :exec
-DELETE FROM chirps
-WHERE id = $1;
+-- name: DeleteChirpByID :one
+UPDATE chirps
+SET deleted_at = NOW(),
+    updated_at = NOW()
+WHERE id = $1 AND deleted_at IS NULL
+RETURNING *;
 
 -- name: SetChirpyRedByID :exec
 UPDATE users 
@@ -79,7 +127,217 @@ SET is_chirpy_red = TRUE,
     updated_at = NOW()
 WHERE id = $1;
 
+-- name: UnsetChirpyRedByID :exec
+UPDATE users
+SET is_chirpy_red = FALSE,
+    updated_at = NOW()
+WHERE id = $1;
+
 -- name: GetChirpsByUserID :many
 SELECT * FROM chirps
-WHERE user_id = $1
-ORDER BY created_at ASC;
\ No newline at end of file
+WHERE user_id = $1 AND deleted_at IS NULL
+ORDER BY created_at ASC;
+
+-- name: SetPasswordByUserID :exec
+UPDATE users
+SET hashed_password = $1,
+    updated_at = NOW()
+WHERE id = $2;
+
+-- name: CreateUserWithOptions :one
+INSERT INTO users (id, created_at, updated_at, email, hashed_password, is_chirpy_red, email_verified, username, display_name, is_admin)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    NOW(),
+    $1,
+    $2,
+    $3,
+    $4,
+    $5,
+    $6,
+    $7
+)
+RETURNING *;
+
+-- name: ListChirps :many
+SELECT sqlc.embed(chirps),
+    (SELECT COUNT(*) FROM chirp_likes
+     WHERE chirp_likes.chirp_id = chirps.id) AS like_count
+FROM chirps
+WHERE (sqlc.arg('include_deleted')::boolean OR deleted_at IS NULL)
+  AND (sqlc.narg('author_id')::uuid IS NULL OR user_id = sqlc.narg('author_id'))
+  AND (sqlc.narg('query')::text IS NULL OR body ILIKE '%' || sqlc.narg('query') || '%')
+  AND (sqlc.narg('hashtag')::text IS NULL OR id IN (
+    SELECT chirp_id FROM chirp_hashtags
+    WHERE hashtag = sqlc.narg('hashtag')
+  ))
+  AND created_at BETWEEN COALESCE(sqlc.narg('created_after')::timestamp, '-infinity')
+                     AND COALESCE(sqlc.narg('created_before')::timestamp, 'infinity')
+  AND (NOT sqlc.arg('clean')::boolean OR raw_body IS NULL)
+  AND char_length(body) >= sqlc.arg('min_length')::int
+ORDER BY CASE WHEN sqlc.arg('newest_first')::boolean THEN created_at END DESC,
+    created_at ASC, id ASC
+LIMIT sqlc.narg('limit') OFFSET sqlc.arg('offset');
+
+-- name: CountListChirps :one
+SELECT COUNT(*) AS count,
+    COALESCE(MAX(updated_at), 'epoch')::timestamp AS last_updated_at
+FROM chirps
+WHERE (sqlc.arg('include_deleted')::boolean OR deleted_at IS NULL)
+  AND (sqlc.narg('author_id')::uuid IS NULL OR user_id = sqlc.narg('author_id'))
+  AND (sqlc.narg('query')::text IS NULL OR body ILIKE '%' || sqlc.narg('query') || '%')
+  AND (sqlc.narg('hashtag')::text IS NULL OR id IN (
+    SELECT chirp_id FROM chirp_hashtags
+    WHERE hashtag = sqlc.narg('hashtag')
+  ))
+  AND created_at BETWEEN COALESCE(sqlc.narg('created_after')::timestamp, '-infinity')
+                     AND COALESCE(sqlc.narg('created_before')::timestamp, 'infinity')
+  AND (NOT sqlc.arg('clean')::boolean OR raw_body IS NULL)
+  AND char_length(body) >= sqlc.arg('min_length')::int;
+
+-- name: ListChirpsAfter :many
+SELECT * FROM chirps
+WHERE deleted_at IS NULL
+  AND (sqlc.narg('author_id')::uuid IS NULL OR user_id = sqlc.narg('author_id'))
+  AND (created_at, id) > (sqlc.arg('after_created_at')::timestamp, sqlc.arg('after_id')::uuid)
+ORDER BY created_at ASC, id ASC
+LIMIT sqlc.arg('limit');
+
+-- name: ListChirpsWithAuthors :many
+SELECT sqlc.embed(chirps), users.email, users.is_chirpy_red, users.username, users.display_name,
+    (SELECT COUNT(*) FROM chirp_likes
+     WHERE chirp_likes.chirp_id = chirps.id) AS like_count
+FROM chirps
+JOIN users ON users.id = chirps.user_id
+WHERE (sqlc.arg('include_deleted')::boolean OR chirps.deleted_at IS NULL)
+  AND (sqlc.narg('author_id')::uuid IS NULL OR chirps.user_id = sqlc.narg('author_id'))
+  AND (sqlc.narg('query')::text IS NULL OR chirps.body ILIKE '%' || sqlc.narg('query') || '%')
+  AND (sqlc.narg('hashtag')::text IS NULL OR chirps.id IN (
+    SELECT chirp_id FROM chirp_hashtags
+    WHERE hashtag = sqlc.narg('hashtag')
+  ))
+  AND chirps.created_at BETWEEN COALESCE(sqlc.narg('created_after')::timestamp, '-infinity')
+                            AND COALESCE(sqlc.narg('created_before')::timestamp, 'infinity')
+  AND (NOT sqlc.arg('clean')::boolean OR chirps.raw_body IS NULL)
+  AND char_length(chirps.body) >= sqlc.arg('min_length')::int
+ORDER BY CASE WHEN sqlc.arg('newest_first')::boolean THEN chirps.created_at END DESC,
+    chirps.created_at ASC, chirps.id ASC
+LIMIT sqlc.narg('limit') OFFSET sqlc.arg('offset');
+
+-- name: GetRecentChirps :many
+SELECT * FROM chirps
+WHERE deleted_at IS NULL
+ORDER BY created_at DESC
+LIMIT $1;
+
+-- name: GetRecentChirpsWithAuthors :many
+SELECT sqlc.embed(chirps), users.username, users.display_name FROM chirps
+JOIN users ON users.id = chirps.user_id
+WHERE chirps.deleted_at IS NULL
+ORDER BY chirps.created_at DESC
+LIMIT $1;
+
+-- name: GetRandomChirp :one
+SELECT * FROM chirps
+WHERE deleted_at IS NULL
+  AND (sqlc.narg('author_id')::uuid IS NULL OR user_id = sqlc.narg('author_id'))
+ORDER BY RANDOM()
+LIMIT 1;
+
+-- name: GetDailyChirpCounts :many
+SELECT date_trunc('day', created_at)::date AS day, COUNT(*) AS count
+FROM chirps
+WHERE user_id = $1 AND deleted_at IS NULL AND created_at >= sqlc.arg('since')
+GROUP BY day
+ORDER BY day;
+
+-- name: GetUserChirpStats :one
+SELECT COUNT(*) AS chirp_count,
+    (SELECT COUNT(*) FROM chirp_likes
+     JOIN chirps AS liked ON liked.id = chirp_likes.chirp_id
+     WHERE liked.user_id = $1 AND liked.deleted_at IS NULL) AS total_likes_received,
+    MIN(created_at) AS first_chirp_at,
+    MAX(created_at) AS last_chirp_at
+FROM chirps
+WHERE user_id = $1 AND deleted_at IS NULL;
+
+-- name: GetChirpReplies :many
+SELECT * FROM chirps
+WHERE parent_chirp_id = $1 AND deleted_at IS NULL
+ORDER BY created_at ASC;
+
+-- name: RevokeAllUserRefreshTokens :exec
+UPDATE refresh_tokens
+SET revoked_at = NOW(),
+    updated_at = NOW()
+WHERE user_id = $1 AND revoked_at IS NULL;
+
+-- name: DeleteUserByID :execrows
+DELETE FROM users
+WHERE id = $1;
+
+-- name: GetUserByID :one
+SELECT * FROM users
+WHERE id = $1;
+
+-- name: SetEmailVerifiedByUserID :one
+UPDATE users
+SET email_verified = TRUE,
+    updated_at = NOW()
+WHERE id = $1
+RETURNING *;
+
+-- name: CountUsers :one
+SELECT COUNT(*) FROM users;
+
+-- name: CountChirps :one
+SELECT COUNT(*) FROM chirps
+WHERE deleted_at IS NULL;
+
+-- name: CountChirpsByAuthor :one
+SELECT COUNT(*) FROM chirps
+WHERE deleted_at IS NULL
+  AND (sqlc.narg('author_id')::uuid IS NULL OR user_id = sqlc.narg('author_id'));
+
+-- name: GetUserByUsername :one
+SELECT * FROM users
+WHERE LOWER(username) = LOWER(sqlc.arg('username'));
+
+-- name: UpdateUserProfile :one
+UPDATE users
+SET username = COALESCE(sqlc.narg('username'), username),
+    display_name = COALESCE(sqlc.narg('display_name'), display_name),
+    updated_at = NOW()
+WHERE id = sqlc.arg('id')
+RETURNING *;
+
+-- name: RotateRefreshToken :execrows
+WITH rotated AS (
+    UPDATE refresh_tokens
+    SET revoked_at = NOW(),
+        replaced_by = sqlc.arg('new_token')::text,
+        updated_at = NOW()
+    WHERE token = sqlc.arg('token') AND revoked_at IS NULL
+    RETURNING user_id, family_id
+)
+INSERT INTO refresh_tokens (token, created_at, updated_at, user_id, expires_at, revoked_at, family_id)
+SELECT sqlc.arg('new_token')::text, NOW(), NOW(), user_id, sqlc.arg('expires_at')::timestamp, NULL, family_id
+FROM rotated;
+
+-- name: ListActiveRefreshTokensByUserID :many
+SELECT * FROM refresh_tokens
+WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > NOW()
+ORDER BY created_at DESC;
+
+-- name: RevokeRefreshTokenByID :execrows
+UPDATE refresh_tokens
+SET revoked_at = NOW(),
+    updated_at = NOW()
+WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL;
+
+-- name: RevokeRefreshTokenFamily :exec
+UPDATE refresh_tokens
+SET revoked_at = NOW(),
+    updated_at = NOW()
+WHERE family_id = $1 AND revoked_at IS NULL;
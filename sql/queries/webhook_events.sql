@@ -0,0 +1,23 @@
+-- name: CreateWebhookEvent :execrows
+INSERT INTO webhook_events (id, created_at, event_type, payload)
+VALUES ($1, NOW(), $2, $3)
+ON CONFLICT (id) DO NOTHING;
+
+-- name: GetUnprocessedWebhookEvents :many
+SELECT * FROM webhook_events
+WHERE processed_at IS NULL AND attempts < sqlc.arg('max_attempts')
+ORDER BY created_at ASC
+LIMIT sqlc.arg('limit');
+
+-- name: MarkWebhookEventProcessed :exec
+UPDATE webhook_events
+SET processed_at = NOW(),
+    attempts = attempts + 1,
+    last_error = NULL
+WHERE id = $1;
+
+-- name: RecordWebhookEventFailure :exec
+UPDATE webhook_events
+SET attempts = attempts + 1,
+    last_error = $2
+WHERE id = $1;
@@ -0,0 +1,8 @@
+-- +goose Up
+CREATE TABLE deleted_chirp_ids (
+    chirp_id UUID PRIMARY KEY,
+    deleted_at TIMESTAMP NOT NULL
+);
+
+-- +goose Down
+DROP TABLE deleted_chirp_ids;
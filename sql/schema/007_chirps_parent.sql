@@ -0,0 +1,8 @@
+-- +goose Up
+ALTER TABLE chirps
+ADD COLUMN parent_chirp_id UUID
+REFERENCES chirps(id)
+ON DELETE SET NULL;
+
+-- +goose Down
+ALTER TABLE chirps DROP COLUMN parent_chirp_id;
@@ -0,0 +1,15 @@
+-- +goose Up
+CREATE TABLE chirp_hashtags (
+    chirp_id UUID NOT NULL,
+    hashtag TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    FOREIGN KEY (chirp_id)
+    REFERENCES chirps(id)
+    ON DELETE CASCADE,
+    PRIMARY KEY (chirp_id, hashtag)
+);
+
+CREATE INDEX chirp_hashtags_hashtag_created_at_idx ON chirp_hashtags (hashtag, created_at);
+
+-- +goose Down
+DROP TABLE chirp_hashtags;
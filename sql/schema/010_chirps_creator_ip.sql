@@ -0,0 +1,7 @@
+-- +goose Up
+ALTER TABLE chirps
+ADD COLUMN creator_ip TEXT;
+
+-- +goose Down
+ALTER TABLE chirps
+DROP COLUMN creator_ip;
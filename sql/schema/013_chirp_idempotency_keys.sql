@@ -0,0 +1,17 @@
+-- +goose Up
+CREATE TABLE chirp_idempotency_keys (
+    user_id UUID NOT NULL,
+    idempotency_key TEXT NOT NULL,
+    chirp_id UUID NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    FOREIGN KEY (user_id)
+    REFERENCES users(id)
+    ON DELETE CASCADE,
+    FOREIGN KEY (chirp_id)
+    REFERENCES chirps(id)
+    ON DELETE CASCADE,
+    PRIMARY KEY (user_id, idempotency_key)
+);
+
+-- +goose Down
+DROP TABLE chirp_idempotency_keys;
@@ -0,0 +1,14 @@
+-- +goose Up
+ALTER TABLE users
+ADD COLUMN username TEXT CHECK (username ~ '^[A-Za-z0-9_]{1,30}$'),
+ADD COLUMN display_name TEXT;
+
+-- Handles are unique regardless of case, so @Alice and @alice are one user.
+CREATE UNIQUE INDEX users_username_lower_idx ON users (LOWER(username));
+
+-- +goose Down
+DROP INDEX users_username_lower_idx;
+
+ALTER TABLE users
+DROP COLUMN display_name,
+DROP COLUMN username;
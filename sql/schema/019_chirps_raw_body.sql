@@ -0,0 +1,8 @@
+-- +goose Up
+-- The body as written, kept only when profanity filtering changed it so
+-- admins can review what was censored.
+ALTER TABLE chirps
+ADD COLUMN raw_body TEXT;
+
+-- +goose Down
+ALTER TABLE chirps DROP COLUMN raw_body;
@@ -0,0 +1,9 @@
+-- +goose Up
+CREATE TABLE metrics (
+    name TEXT PRIMARY KEY,
+    value BIGINT NOT NULL DEFAULT 0,
+    updated_at TIMESTAMP NOT NULL
+);
+
+-- +goose Down
+DROP TABLE metrics;
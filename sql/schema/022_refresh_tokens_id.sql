@@ -0,0 +1,11 @@
+-- +goose Up
+ALTER TABLE refresh_tokens
+ADD COLUMN id UUID NOT NULL DEFAULT gen_random_uuid();
+
+CREATE UNIQUE INDEX refresh_tokens_id_idx ON refresh_tokens (id);
+
+-- +goose Down
+DROP INDEX refresh_tokens_id_idx;
+
+ALTER TABLE refresh_tokens
+DROP COLUMN id;
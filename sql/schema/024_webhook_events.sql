@@ -0,0 +1,16 @@
+-- +goose Up
+CREATE TABLE webhook_events (
+    id TEXT PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    event_type TEXT NOT NULL,
+    payload TEXT NOT NULL,
+    processed_at TIMESTAMP,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT
+);
+
+CREATE INDEX webhook_events_unprocessed_idx ON webhook_events (created_at)
+WHERE processed_at IS NULL;
+
+-- +goose Down
+DROP TABLE webhook_events;
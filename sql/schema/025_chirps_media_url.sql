@@ -0,0 +1,7 @@
+-- +goose Up
+-- An optional http(s) URL of an image attached to the chirp.
+ALTER TABLE chirps
+ADD COLUMN media_url TEXT;
+
+-- +goose Down
+ALTER TABLE chirps DROP COLUMN media_url;
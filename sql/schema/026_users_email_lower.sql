@@ -0,0 +1,20 @@
+-- +goose Up
+-- Emails are stored lowercased from now on, so existing rows are brought in
+-- line first. Accounts whose emails differ only in case can't both be kept:
+-- the UPDATE (or, failing that, the index) rejects them and the migration
+-- stops until they are merged or one of them is renamed by hand. They can be
+-- found with:
+--
+--   SELECT LOWER(email), array_agg(id) FROM users
+--   GROUP BY LOWER(email) HAVING COUNT(*) > 1;
+UPDATE users
+SET email = LOWER(email),
+    updated_at = NOW()
+WHERE email <> LOWER(email);
+
+-- Emails are unique regardless of case, so User@example.com can't register
+-- alongside user@example.com.
+CREATE UNIQUE INDEX users_email_lower_idx ON users (LOWER(email));
+
+-- +goose Down
+DROP INDEX users_email_lower_idx;
@@ -0,0 +1,6 @@
+-- +goose Up
+ALTER TABLE users
+ADD COLUMN last_login_at TIMESTAMP;
+
+-- +goose Down
+ALTER TABLE users DROP COLUMN last_login_at;
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/WOsaka/chirpy-server/internal/database"
+	"github.com/google/uuid"
+)
+
+// polkaEvent is a Polka webhook payload. ID is optional; events without one
+// are deduplicated by a hash of their body instead.
+type polkaEvent struct {
+	ID    string `json:"id"`
+	Event string `json:"event"`
+	Data  struct {
+		UserID string `json:"user_id"`
+	} `json:"data"`
+}
+
+const (
+	// maxWebhookAttempts is how many times an event is processed before
+	// retries give up on it.
+	maxWebhookAttempts          = 5
+	webhookRetryBatchSize       = 100
+	defaultWebhookRetryInterval = time.Minute
+)
+
+// processWebhookEvent applies a stored Polka event and records the outcome,
+// leaving failed events for retryWebhookEvents.
+func (cfg *apiConfig) processWebhookEvent(ctx context.Context, event database.WebhookEvent) error {
+	if err := cfg.applyWebhookEvent(ctx, event); err != nil {
+		if recordErr := cfg.db.RecordWebhookEventFailure(ctx, database.RecordWebhookEventFailureParams{
+			ID:        event.ID,
+			LastError: sql.NullString{String: err.Error(), Valid: true},
+		}); recordErr != nil {
+			return errors.Join(err, recordErr)
+		}
+		return err
+	}
+	return cfg.db.MarkWebhookEventProcessed(ctx, event.ID)
+}
+
+func (cfg *apiConfig) applyWebhookEvent(ctx context.Context, event database.WebhookEvent) error {
+	var payload polkaEvent
+	if err := json.Unmarshal([]byte(event.Payload), &payload); err != nil {
+		return fmt.Errorf("decoding payload: %w", err)
+	}
+
+	switch event.EventType {
+	case "user.upgraded":
+		userID, err := uuid.Parse(payload.Data.UserID)
+		if err != nil {
+			return fmt.Errorf("parsing user ID: %w", err)
+		}
+		// An unknown user is an error like any other, since the event may
+		// have raced their signup. A user who never turns up, for example
+		// because they were deleted, is retried until the event runs out of
+		// maxWebhookAttempts. Upgrading is idempotent, so a user who is
+		// already Chirpy Red needs nothing done.
+		dbUser, err := cfg.db.GetUserByID(ctx, userID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("user %s: %w", userID, err)
+		}
+		if err != nil {
+			return fmt.Errorf("fetching user: %w", err)
+		}
+		if dbUser.IsChirpyRed {
+			return nil
+		}
+		return cfg.db.SetChirpyRedByID(ctx, userID)
+	default:
+		return nil
+	}
+}
+
+// retryWebhookEvents reprocesses stored events that have not succeeded yet,
+// oldest first.
+func (cfg *apiConfig) retryWebhookEvents(ctx context.Context) error {
+	events, err := cfg.db.GetUnprocessedWebhookEvents(ctx, database.GetUnprocessedWebhookEventsParams{
+		MaxAttempts: maxWebhookAttempts,
+		Limit:       webhookRetryBatchSize,
+	})
+	if err != nil {
+		return err
+	}
+	for _, event := range events {
+		if err := cfg.processWebhookEvent(ctx, event); err != nil {
+			slog.Warn("Error retrying webhook event", "event_id", event.ID, "attempt", event.Attempts+1, "error", err)
+		}
+	}
+	return nil
+}
+
+// retryWebhookEventsEvery calls retryWebhookEvents each interval until ctx
+// is done.
+func (cfg *apiConfig) retryWebhookEventsEvery(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := cfg.retryWebhookEvents(ctx); err != nil {
+				slog.Error("Error fetching webhook events to retry", "error", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func postPolkaWebhook(t *testing.T, cfg *apiConfig, body string) int {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/api/polka/webhooks", strings.NewReader(body))
+	req.Header.Set("Authorization", "ApiKey "+cfg.polkaKey)
+	rec := httptest.NewRecorder()
+	cfg.setChirpyRedHandler(rec, req)
+	return rec.Code
+}
+
+func TestWebhookEventDedupe(t *testing.T) {
+	userID := uuid.New()
+	db := &chirpyRedDB{}
+	cfg := &apiConfig{db: db, polkaKey: "polka-key"}
+
+	withID := `{"id": "evt_1", "event": "user.upgraded", "data": {"user_id": "` + userID.String() + `"}}`
+	withoutID := `{"event": "user.upgraded", "data": {"user_id": "` + userID.String() + `"}}`
+	for _, body := range []string{withID, withID, withoutID, withoutID} {
+		if code := postPolkaWebhook(t, cfg, body); code != http.StatusOK {
+			t.Fatalf("got status %d, want %d", code, http.StatusOK)
+		}
+	}
+
+	// The second distinct event finds the user already upgraded.
+	if len(db.upgraded) != 1 {
+		t.Errorf("upgraded %d times; want 1", len(db.upgraded))
+	}
+	if len(db.events) != 2 {
+		t.Fatalf("stored %d events; want 2", len(db.events))
+	}
+	for id, event := range db.events {
+		if !event.ProcessedAt.Valid {
+			t.Errorf("%s stored as %+v; want processed", id, event)
+		}
+	}
+	if event := db.events["evt_1"]; !event.ProcessedAt.Valid || event.Payload != withID {
+		t.Errorf("evt_1 stored as %+v", event)
+	}
+}
+
+func TestWebhookEventRetry(t *testing.T) {
+	userID := uuid.New()
+	db := &chirpyRedDB{failures: 2}
+	cfg := &apiConfig{db: db, polkaKey: "polka-key"}
+
+	body := `{"id": "evt_1", "event": "user.upgraded", "data": {"user_id": "` + userID.String() + `"}}`
+	if code := postPolkaWebhook(t, cfg, body); code != http.StatusInternalServerError {
+		t.Fatalf("got status %d, want %d", code, http.StatusInternalServerError)
+	}
+	if event := db.events["evt_1"]; event.ProcessedAt.Valid || !event.LastError.Valid {
+		t.Fatalf("failed event stored as %+v; want unprocessed with an error", event)
+	}
+
+	for range 2 {
+		if err := cfg.retryWebhookEvents(context.Background()); err != nil {
+			t.Fatalf("retryWebhookEvents: %v", err)
+		}
+	}
+
+	event := db.events["evt_1"]
+	if !event.ProcessedAt.Valid || event.Attempts != 3 {
+		t.Errorf("event after retries = %+v; want processed after 3 attempts", event)
+	}
+	if len(db.upgraded) != 1 || db.upgraded[0] != userID {
+		t.Errorf("upgraded users = %v; want [%v]", db.upgraded, userID)
+	}
+
+	// Redelivering a processed event is acknowledged without reapplying it.
+	if code := postPolkaWebhook(t, cfg, body); code != http.StatusOK {
+		t.Fatalf("redelivery: got status %d, want %d", code, http.StatusOK)
+	}
+	if len(db.upgraded) != 1 {
+		t.Errorf("redelivery upgraded again: %v", db.upgraded)
+	}
+}
+
+func TestWebhookEventRetryGivesUp(t *testing.T) {
+	db := &chirpyRedDB{failures: maxWebhookAttempts + 1}
+	cfg := &apiConfig{db: db, polkaKey: "polka-key"}
+
+	body := `{"id": "evt_1", "event": "user.upgraded", "data": {"user_id": "` + uuid.NewString() + `"}}`
+	postPolkaWebhook(t, cfg, body)
+	for range maxWebhookAttempts + 1 {
+		if err := cfg.retryWebhookEvents(context.Background()); err != nil {
+			t.Fatalf("retryWebhookEvents: %v", err)
+		}
+	}
+
+	if attempts := db.events["evt_1"].Attempts; attempts != maxWebhookAttempts {
+		t.Errorf("event attempted %d times; want %d", attempts, maxWebhookAttempts)
+	}
+}
+
+func TestWebhookUpgradeIdempotent(t *testing.T) {
+	userID := uuid.New()
+	body := func(id string) string {
+		return `{"id": "` + id + `", "event": "user.upgraded", "data": {"user_id": "` + userID.String() + `"}}`
+	}
+
+	t.Run("unknown user", func(t *testing.T) {
+		db := &chirpyRedDB{missing: []uuid.UUID{userID}}
+		cfg := &apiConfig{db: db, polkaKey: "polka-key"}
+		if code := postPolkaWebhook(t, cfg, body("evt_1")); code != http.StatusNotFound {
+			t.Fatalf("got status %d, want %d", code, http.StatusNotFound)
+		}
+		if event := db.events["evt_1"]; event.ProcessedAt.Valid || !event.LastError.Valid {
+			t.Fatalf("event for an unknown user stored as %+v; want unprocessed with an error", event)
+		}
+
+		// Once the user exists, the stored event is applied by a retry and
+		// Polka's redelivery is acknowledged as a duplicate.
+		db.missing = nil
+		if err := cfg.retryWebhookEvents(context.Background()); err != nil {
+			t.Fatalf("retryWebhookEvents: %v", err)
+		}
+		if code := postPolkaWebhook(t, cfg, body("evt_1")); code != http.StatusOK {
+			t.Fatalf("redelivery: got status %d, want %d", code, http.StatusOK)
+		}
+		if len(db.upgraded) != 1 {
+			t.Errorf("upgraded users = %v; want [%v]", db.upgraded, userID)
+		}
+	})
+
+	t.Run("database failure", func(t *testing.T) {
+		db := &chirpyRedDB{lookupErr: errors.New("database unavailable")}
+		cfg := &apiConfig{db: db, polkaKey: "polka-key"}
+		if code := postPolkaWebhook(t, cfg, body("evt_1")); code != http.StatusInternalServerError {
+			t.Fatalf("got status %d, want %d", code, http.StatusInternalServerError)
+		}
+		if event := db.events["evt_1"]; event.ProcessedAt.Valid || !event.LastError.Valid {
+			t.Errorf("event stored as %+v; want unprocessed with an error", event)
+		}
+		if len(db.upgraded) != 0 {
+			t.Errorf("upgraded users = %v; want none", db.upgraded)
+		}
+	})
+
+	t.Run("already red", func(t *testing.T) {
+		db := &chirpyRedDB{upgraded: []uuid.UUID{userID}}
+		cfg := &apiConfig{db: db, polkaKey: "polka-key"}
+		for _, id := range []string{"evt_1", "evt_2"} {
+			if code := postPolkaWebhook(t, cfg, body(id)); code != http.StatusOK {
+				t.Fatalf("%s: got status %d, want %d", id, code, http.StatusOK)
+			}
+			if event := db.events[id]; !event.ProcessedAt.Valid {
+				t.Errorf("%s stored as %+v; want processed", id, event)
+			}
+		}
+		if len(db.upgraded) != 1 {
+			t.Errorf("already-red user upgraded again: %v", db.upgraded)
+		}
+	})
+}